@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configEnv — переменная окружения с путём к конфигу, если не указан --config.
+const configEnv = "BITGET_HISTORY_CONFIG"
+
+// envPrefix — префикс переменных окружения, переопределяющих поля конфига:
+// BITGET_<РАЗДЕЛ>_<КЛЮЧ>, например BITGET_DATABASE_PATH или BITGET_PROXY_USERNAME.
+const envPrefix = "BITGET_"
+
+// configPath возвращает путь к конфигу: --config, затем BITGET_HISTORY_CONFIG, затем config/config.yaml.
+func configPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if env := os.Getenv(configEnv); env != "" {
+		return env
+	}
+	return filepath.Join("config", "config.yaml")
+}
+
+// overridePath возвращает путь к файлу переопределения рядом с конфигом:
+// config.yaml → config-override.yaml.
+func overridePath(configFile string) string {
+	ext := filepath.Ext(configFile)
+	return strings.TrimSuffix(configFile, ext) + "-override" + ext
+}
+
+// loadConfig читает конфиг, файл переопределения поверх него и затем переменные окружения
+// BITGET_<РАЗДЕЛ>_<КЛЮЧ>. Относительные пути из файлов считаются от каталога конфига, а не от
+// текущего каталога. Если required=false, отсутствующий конфиг не ошибка: все поля можно задать
+// через окружение.
+func loadConfig(configFile string, required bool) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(configFile)
+	if os.IsNotExist(err) && !required {
+		return cfg, applyEnvOverrides(&cfg)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config %s: %w", configFile, err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config %s: %w", configFile, err)
+	}
+
+	// Читаем переопределение, если есть
+	configOverrideFile := overridePath(configFile)
+	if _, err := os.Stat(configOverrideFile); err == nil {
+		overrideData, err := os.ReadFile(configOverrideFile)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read override config %s: %w", configOverrideFile, err)
+		}
+		if err := yaml.Unmarshal(overrideData, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse override config %s: %w", configOverrideFile, err)
+		}
+	}
+
+	resolveConfigPaths(&cfg, filepath.Dir(configFile))
+	return cfg, applyEnvOverrides(&cfg)
+}
+
+// applyEnvOverrides переписывает поля конфига значениями переменных BITGET_<РАЗДЕЛ>_<КЛЮЧ>,
+// где раздел и ключ — имена из YAML в верхнем регистре. Списки задаются через запятую.
+func applyEnvOverrides(cfg *Config) error {
+	sections := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+		sectionName := yamlName(sections.Type().Field(i))
+		for j := 0; j < section.NumField(); j++ {
+			name := envPrefix + strings.ToUpper(sectionName+"_"+yamlName(section.Type().Field(j)))
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			if err := setField(section.Field(j), value); err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// yamlName возвращает имя поля из тега yaml.
+func yamlName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("yaml"), ",")[0]
+}
+
+// setField разбирает value по типу поля и записывает в него.
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// resolveConfigPaths делает относительные пути конфига путями от каталога dir.
+func resolveConfigPaths(cfg *Config, dir string) {
+	for _, p := range []*string{
+		&cfg.Proxy.RawFile,
+		&cfg.Proxy.WorkingFile,
+		&cfg.Proxy.StaticFile,
+		&cfg.Proxy.BlacklistFile,
+		&cfg.Database.Path,
+		&cfg.Database.TempPath,
+		&cfg.Datafiles.Path,
+		&cfg.Datafiles.TmpRawPath,
+		&cfg.Export.OutputPath,
+		&cfg.Server.AccessLogFile,
+	} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+}
@@ -1,28 +1,38 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"database/sql"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/magf/bitget-history/engine"
 	"github.com/magf/bitget-history/internal/cmdutils"
 	"github.com/magf/bitget-history/internal/cmdutils/export"
 	"github.com/magf/bitget-history/internal/db"
 	"github.com/magf/bitget-history/internal/downloader"
+	"github.com/magf/bitget-history/internal/logfile"
+	"github.com/magf/bitget-history/internal/logging"
+	"github.com/magf/bitget-history/internal/notifier"
 	"github.com/magf/bitget-history/internal/proxymanager"
+	"github.com/magf/bitget-history/internal/server/accesslog"
 	"github.com/magf/bitget-history/internal/server/backend"
 	"github.com/magf/bitget-history/internal/server/web"
 	_ "github.com/mattn/go-sqlite3"
-	"gopkg.in/yaml.v3"
 )
 
 // Config представляет структуру конфигурационного файла.
@@ -33,37 +43,166 @@ type Config struct {
 		Fallback    string `yaml:"fallback"`
 		Username    string `yaml:"username"`
 		Password    string `yaml:"password"`
+		StaticFile  string `yaml:"static_file"`
+		StaticCheck bool   `yaml:"static_check"`
+
+		CheckConcurrency int `yaml:"check_concurrency"`
+
+		BlacklistFile        string  `yaml:"blacklist_file"`
+		BlacklistMinFailures int     `yaml:"blacklist_min_failures"`
+		BlacklistThreshold   float64 `yaml:"blacklist_threshold"`
+		BlacklistCooldown    int     `yaml:"blacklist_cooldown"`
+
+		AllowCIDRs     []string `yaml:"allow_cidrs"`
+		BlockCIDRs     []string `yaml:"block_cidrs"`
+		KeepUnresolved bool     `yaml:"keep_unresolved"`
+
+		SourceInterval int `yaml:"source_interval"`
 	} `yaml:"proxy"`
 	Database struct {
-		Path         string `yaml:"path"`
-		TempPath     string `yaml:"temp_path"`
-		BackupSuffix string `yaml:"bak_suffix"`
+		Path             string `yaml:"path"`
+		TempPath         string `yaml:"temp_path"`
+		BackupSuffix     string `yaml:"bak_suffix"`
+		BackupKeep       int    `yaml:"backup_keep"`
+		VacuumThreshold  int64  `yaml:"vacuum_threshold"`
+		ImportBatchSize  int    `yaml:"import_batch_size"`
+		CreatePriceIndex bool   `yaml:"create_price_index"`
+		ImportWorkers    int    `yaml:"import_workers"`
+
+		Driver string `yaml:"driver"`
+		DSN    string `yaml:"dsn"`
+
+		BusyTimeout int    `yaml:"busy_timeout"`
+		Synchronous string `yaml:"synchronous"`
 	} `yaml:"database"`
 	Datafiles struct {
-		Path string `yaml:"path"`
+		Path               string `yaml:"path"`
+		TmpRawPath         string `yaml:"tmp_raw_path"`
+		RecheckConcurrency int    `yaml:"recheck_concurrency"`
+
+		MaxInMemoryBytes int64 `yaml:"max_in_memory_bytes"` // Устарело: CSV и XLSX читаются потоково, значение игнорируется
 	} `yaml:"datafiles"`
 	Downloader struct {
-		BaseURL   string `yaml:"base_url"`
-		UserAgent string `yaml:"user_agent"`
+		BaseURL        string `yaml:"base_url"`
+		UserAgent      string `yaml:"user_agent"`
+		MaxBytesPerSec int64  `yaml:"max_bytes_per_sec"`
+
+		CheckConcurrency    int `yaml:"check_concurrency"`
+		DownloadConcurrency int `yaml:"download_concurrency"`
+
+		TradesMissRun int `yaml:"trades_miss_run"`
+
+		MaxFileBytes int64 `yaml:"max_file_bytes"`
+
+		DialTimeout           int `yaml:"dial_timeout"`
+		TLSHandshakeTimeout   int `yaml:"tls_handshake_timeout"`
+		ResponseHeaderTimeout int `yaml:"response_header_timeout"`
+		StallTimeout          int `yaml:"stall_timeout"`
+
+		UserAgents        []string `yaml:"user_agents"`
+		UserAgentRotation string   `yaml:"user_agent_rotation"`
 	} `yaml:"downloader"`
+	Export struct {
+		OutputPath string `yaml:"output_path"`
+	} `yaml:"export"`
+	Server struct {
+		Gzip           bool     `yaml:"gzip"`
+		QueryTimeout   int      `yaml:"query_timeout"`
+		AllowedOrigins []string `yaml:"allowed_origins"`
+		APIKey         string   `yaml:"api_key"`
+		Metrics        bool     `yaml:"metrics"`
+
+		AccessLog     string `yaml:"access_log"`
+		AccessLogFile string `yaml:"access_log_file"`
+	} `yaml:"server"`
+	Notify struct {
+		WebhookURL string `yaml:"webhook_url"`
+		Command    string `yaml:"command"`
+	} `yaml:"notify"`
+	Log struct {
+		Level  string `yaml:"level"`
+		Format string `yaml:"format"`
+	} `yaml:"log"`
 }
 
+// runNotifier и runSummary используются для уведомления о завершении запуска и отчёта --report;
+// runEngine даёт счётчики загрузок и импорта, runReportPath — файл отчёта (пусто — без отчёта).
+var (
+	runNotifier   *notifier.Notifier
+	runSummary    = cmdutils.RunSummary{StartedAt: time.Now()}
+	runEngine     *engine.Engine
+	runReportPath string
+)
+
 func main() {
 	// Парсим флаги
 	helpFlag := flag.Bool("help", false, "Show help message")
+	configFlag := flag.String("config", "", "Path to the config file (default: $BITGET_HISTORY_CONFIG or config/config.yaml)")
 	serverFlag := flag.Bool("server", false, "Run server")
-	pairFlag := flag.String("pair", "BTCUSDT", "Trading pair (e.g., BTCUSDT)")
-	typeFlag := flag.String("type", "", "Data type: trades or depth")
-	marketFlag := flag.String("market", "all", "Market type: spot, futures or all")
-	startFlag := flag.String("start", "", "Start date (YYYY-MM-DD, default: 1 year ago)")
-	endFlag := flag.String("end", "", "End date (YYYY-MM-DD, default: today)")
+	pairFlag := flag.String("pair", "BTCUSDT", "Trading pair or comma-separated pairs (e.g., BTCUSDT,ETHUSDT)")
+	pairsFileFlag := flag.String("pairs-file", "", "File with one trading pair per line (overrides --pair)")
+	typeFlag := flag.String("type", "", "Data type: trades, depth, kline or funding")
+	marketFlag := flag.String("market", "all", "Market type: spot, futures, all or a Bitget product code (e.g., DMCBL)")
+	startFlag := flag.String("start", "", "Start date (YYYY-MM-DD or YYYYMMDD, default: 1 year ago)")
+	endFlag := flag.String("end", "", "End date (YYYY-MM-DD or YYYYMMDD, default: today)")
+	dateFormatFlag := flag.String("date-format", "", "Go layout for --start and --end instead of YYYY-MM-DD/YYYYMMDD (e.g., 02.01.2006)")
 	exportMT5 := flag.Bool("export-mt5", false, "Export data to MT5 CSV format")
+	exportJSON := flag.Bool("export-json", false, "Export raw depth or trades rows to JSON")
+	exportParquetFlag := flag.Bool("export-parquet", false, "Export raw depth or trades rows to Parquet")
+	exportTradesDepthFlag := flag.Bool("export-trades-depth", false, "Export trades with the nearest depth mid and spread to CSV")
+	jsonLinesFlag := flag.Bool("json-lines", false, "Write newline-delimited JSON for --export-json")
+	fillGapsFlag := flag.Bool("fill-gaps", false, "Fill empty intervals in --export-mt5 with flat candles at the previous close")
+	withSymbolFlag := flag.Bool("with-symbol", false, "Prepend Symbol and Market columns to --export-mt5 output")
+	appendFlag := flag.Bool("append", false, "With --export-mt5, append new candles to <pair>_<market>_<tf>.csv, recomputing only its last candle")
+	sideVolumeFlag := flag.Bool("side-volume", false, "Add BuyVolume and SellVolume columns to --export-mt5 trades candles")
+	volumeFlag := flag.String("volume", "base", "Volume of --export-mt5 trades candles: base (sum of size_base) or quote (sum of volume_quote)")
+	timeframesFlag := flag.String("timeframes", "m1", "Comma-separated candle timeframes for --export-mt5 (m1,m5,m15,m30,h1,h4,d1)")
+	volumeRefFlag := flag.String("volume-reference", "", "CSV with expected daily volumes (date,volume) to reconcile against in trades --export-mt5")
+	volumeTolFlag := flag.Float64("volume-tolerance", export.DefaultVolumeTolerance, "Allowed relative daily volume difference for --volume-reference")
 	timeoutFlag := flag.Int("timeout", 3, "Proxy check timeout in seconds")
+	concurrencyFlag := flag.Int("concurrency", 0, "Limit concurrent URL checks, downloads and proxy checks to N (overrides the *_concurrency config values)")
+	proxyFileFlag := flag.String("proxy-file", "", "Use this proxy list as is instead of downloading and checking free proxies (overrides proxy.static_file)")
+	proxyFileCheckFlag := flag.Bool("proxy-file-check", false, "With --proxy-file, drop proxies that do not accept a TCP connection (enables proxy.static_check)")
 	debugFlag := flag.Bool("debug", false, "Enable debug logging")
 	skipExistsFlag := flag.Bool("skip-exists", false, "Skip downloading if file exists locally")
 	repeatFlag := flag.Bool("repeat", false, "Repeat process until all files are downloaded (for --skip-exists only)")
 	recheckExists := flag.Bool("recheck-exists", false, "Recheck existing non-zero archives for corruption")
+	recheckOnlyFlag := flag.Bool("recheck-only", false, "Recheck existing archives and report broken ones without redownloading")
+	proxyTestFlag := flag.Bool("proxy-test", false, "Check proxies, print the number of working ones and the fastest few with latency, then exit")
+	reportFlag := flag.String("report", "", "Write a JSON run summary (download counts, inserted rows, failed URLs, timing) to this file; with a recheck or --validate, write broken archives or failed databases instead (JSON if it ends with .json, else one per line)")
+	validateFlag := flag.Bool("validate", false, "Run PRAGMA integrity_check and foreign_key_check on every database and report failures without modifying anything")
+	cleanFlag := flag.Bool("clean", false, "Remove temporary databases, extracted archive data and backups beyond database.backup_keep, then exit")
+	yesFlag := flag.Bool("yes", false, "With --clean, remove without asking for confirmation")
 	skipDownloadFlag := flag.Bool("skip-download", false, "Skip downloading and reimport existing local files")
+	importMappedFlag := flag.String("import-mapped", "", "Import a CSV with a custom column layout (requires --mapping, --type and --market spot|futures)")
+	importDirFlag := flag.String("import-dir", "", "Import every zip under a directory (recursively) into the pair database, ignoring the Bitget directory layout (requires --type, --market spot|futures and --pair)")
+	mappingFlag := flag.String("mapping", "", "Column mapping for --import-mapped (e.g., ts=0,price=2,side=3,size=4 or ts=time,price=px)")
+	maxBpsFlag := flag.Int64("max-bps", -1, "Limit total download speed in bytes per second (overrides downloader.max_bytes_per_sec, 0 disables)")
+	queryFlag := flag.Bool("query", false, "Print row count, time range and first/last price from the imported database and exit")
+	queryCSVFlag := flag.Bool("query-csv", false, "With --query, also dump the rows of the period as CSV to stdout")
+	listMissingFlag := flag.Bool("list-missing", false, "Print dates of the period without local archives or imported rows and exit")
+	normalizeTimestampsFlag := flag.Bool("normalize-timestamps", false, "Convert millisecond timestamps in the pair databases of --type to seconds and exit")
+	pruneBeforeFlag := flag.String("prune-before", "", "Delete rows older than this date (YYYY-MM-DD) from the pair databases of --type, VACUUM them and exit")
+	quietFlag := flag.Bool("quiet", false, "Log only download progress and errors instead of every file and attempt")
+	compactFlag := flag.Bool("compact", false, "With --export-json and --type depth, export spot and futures into one file with a market field")
+	sinceLastFlag := flag.Bool("since-last", false, "Start from the date of the last row already imported for the pair (overrides --start)")
+	dryRunFlag := flag.Bool("dry-run", false, "Only report how many files and bytes would be downloaded, without downloading or importing")
+	noPlaceholdersFlag := flag.Bool("no-placeholders", false, "Do not create empty placeholder files for missing depth archives")
+	noCacheFlag := flag.Bool("no-cache", false, "Ignore cached URL checks in checked_urls and send HEAD requests (detects archives changed on the server)")
+	pairDiscoveryFlag := flag.Bool("pair-discovery", false, "List pairs with data on the server from its directory listing, or probe --pair/--pairs-file candidates on the --end date")
+	headOnlyCheckFlag := flag.Bool("head-only-check", false, "Report live HTTP status per date/part without downloading or writing anything")
+	rebuildFlag := flag.Bool("rebuild", false, "Drop and rebuild depth tables of the selected market instead of importing incrementally")
+	klineTimeframeFlag := flag.String("kline-timeframe", "", "Timeframe of imported --type kline candles (m1,m5,m15,m30,h1,h4,d1); default: from the archive path, else m1")
+	strictFlag := flag.Bool("strict", false, "Fail the import when a CSV has more or fewer rows than were inserted and skipped")
+	vacuumFlag := flag.Bool("vacuum", false, "Run VACUUM and ANALYZE on the database after import")
+	noShrinkFlag := flag.Bool("no-shrink", false, "Refuse to replace a database with one that has fewer rows")
+	forceFlag := flag.Bool("force", false, "Replace the database even when --no-shrink would refuse")
+	logFileFlag := flag.String("log-file", "", "Also write logs to this file with rotation")
+	logMaxSizeFlag := flag.Int("log-max-size", 100, "Rotate --log-file when it exceeds this size in MB (0 disables)")
+	logRotateEveryFlag := flag.Duration("log-rotate-every", 24*time.Hour, "Rotate --log-file when it is older than this (0 disables)")
+	logMaxBackupsFlag := flag.Int("log-max-backups", 7, "Number of rotated --log-file copies to keep (0 keeps all)")
+	logLevelFlag := flag.String("log-level", "", "Log level: error, warn, info or debug (overrides log.level)")
+	logFormatFlag := flag.String("log-format", "", "Log format: pretty, text or json (overrides log.format)")
 
 	// Короткие флаги
 	flag.BoolVar(helpFlag, "h", false, "Show help message (short)")
@@ -78,6 +217,7 @@ func main() {
 	flag.BoolVar(repeatFlag, "r", false, "Repeat process until all files are downloaded (for --skip-exists only) (short)")
 	flag.BoolVar(recheckExists, "R", false, "Recheck existing non-zero archives for corruption (short)")
 	flag.BoolVar(skipDownloadFlag, "S", false, "Skip downloading and reimport existing local files (short)")
+	flag.StringVar(timeframesFlag, "timeframe", "m1", "Comma-separated candle timeframes for --export-mt5 (alias of --timeframes)")
 
 	flag.Parse()
 
@@ -87,520 +227,912 @@ func main() {
 		return
 	}
 
+	// Пишем логи ещё и в файл с ротацией
+	var logOutput io.Writer = os.Stderr
+	if *logFileFlag != "" {
+		logFile, err := logfile.NewRotatingFile(*logFileFlag, int64(*logMaxSizeFlag)*1024*1024, *logRotateEveryFlag, *logMaxBackupsFlag)
+		if err != nil {
+			logging.Fatalf("Failed to open log file: %v", err)
+		}
+		defer logFile.Close()
+		logOutput = io.MultiWriter(os.Stderr, logFile)
+	}
+	// До чтения конфига логируем с параметрами из флагов
+	if err := setupLogging(logOutput, Config{}, *logLevelFlag, *logFormatFlag, debugFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// Читаем конфиг и переопределение рядом с ним
+	// Без явного --config или BITGET_HISTORY_CONFIG конфиг можно не создавать: поля берутся из окружения
+	cfg, err := loadConfig(configPath(*configFlag), *configFlag != "" || os.Getenv(configEnv) != "")
+	if err != nil {
+		logging.Fatalf("%v", err)
+	}
+	if err := setupLogging(logOutput, cfg, *logLevelFlag, *logFormatFlag, debugFlag); err != nil {
+		logging.Fatalf("Error: %v", err)
+	}
+	if cfg.Datafiles.MaxInMemoryBytes != 0 {
+		logging.Warnf("datafiles.max_in_memory_bytes is obsolete and ignored: CSV and XLSX entries are streamed, so archives of any size are imported")
+	}
+
 	// Run server
 	if *serverFlag {
 		// Настраиваем единый сервер
 		mux := http.NewServeMux()
-		backend.StartServer(mux)
-		web.StartServer(mux)
-		log.Println("Server running on http://localhost:8080")
-		if err := http.ListenAndServe(":8080", mux); err != nil {
-			log.Fatalf("Server failed: %v", err)
+		backend.StartServer(mux, backend.Options{
+			DBRoot:         cfg.Database.Path,
+			Gzip:           cfg.Server.Gzip,
+			QueryTimeout:   time.Duration(cfg.Server.QueryTimeout) * time.Second,
+			AllowedOrigins: cfg.Server.AllowedOrigins,
+			APIKey:         cfg.Server.APIKey,
+			Metrics:        cfg.Server.Metrics,
+		})
+		web.StartServer(mux, os.Getenv("BITGET_HISTORY_STATIC_DIR")) // Пустое значение — встроенные файлы
+
+		// Журнал запросов оборачивает весь mux
+		accessOpts := accesslog.Options{Format: cfg.Server.AccessLog, Output: logOutput}
+		if cfg.Server.AccessLogFile != "" {
+			accessFile, err := logfile.NewRotatingFile(cfg.Server.AccessLogFile, int64(*logMaxSizeFlag)*1024*1024, *logRotateEveryFlag, *logMaxBackupsFlag)
+			if err != nil {
+				logging.Fatalf("Failed to open access log file: %v", err)
+			}
+			defer accessFile.Close()
+			accessOpts.File = accessFile
+		}
+		accessLog, err := accesslog.New(accessOpts)
+		if err != nil {
+			logging.Fatalf("Error: %v", err)
+		}
+		logging.Infof("Server running on http://localhost:8080")
+		if err := http.ListenAndServe(":8080", accessLog.Wrap(mux)); err != nil {
+			logging.Fatalf("Server failed: %v", err)
 		}
 		return
 	}
 
-	// Читаем конфиг
-	configFile := filepath.Join("config", "config.yaml")
-	configOverrideFile := filepath.Join("config", "config-override.yaml")
-	var cfg Config
+	// Отменяем контекст по SIGINT/SIGTERM: загрузки и импорт завершаются аккуратно,
+	// а повторный сигнал обрабатывается по умолчанию и прерывает процесс сразу
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+		logging.Infof("Interrupt received, finishing current work (press Ctrl-C again to force exit)...")
+	}()
 
-	// Читаем основной конфиг
-	data, err := os.ReadFile(configFile)
-	if err != nil {
-		log.Fatalf("Failed to read config %s: %v", configFile, err)
-	}
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		log.Fatalf("Failed to parse config %s: %v", configFile, err)
-	}
+	// Настраиваем уведомления о завершении запуска
+	runNotifier = notifier.NewNotifier(cfg.Notify.WebhookURL, cfg.Notify.Command)
 
-	// Читаем переопределение, если есть
-	if _, err := os.Stat(configOverrideFile); err == nil {
-		overrideData, err := os.ReadFile(configOverrideFile)
+	// Проверяем целостность баз, ничего не создавая и не изменяя
+	if *validateFlag {
+		logging.Infof("Validating databases in %s...", cfg.Database.Path)
+		failed, checked, err := cmdutils.ValidateDatabases(cfg.Database.Path)
 		if err != nil {
-			log.Fatalf("Failed to read override config %s: %v", configOverrideFile, err)
+			fatalf("Failed to validate databases: %v", err)
 		}
-		if err := yaml.Unmarshal(overrideData, &cfg); err != nil {
-			log.Fatalf("Failed to parse override config %s: %v", configOverrideFile, err)
+		if *reportFlag != "" {
+			if err := writeValidationReport(*reportFlag, failed); err != nil {
+				fatalf("Failed to write report: %v", err)
+			}
+			logging.Infof("Wrote %d failed database paths to %s", len(failed), *reportFlag)
 		}
+		logging.Infof("Validated %d databases, %d failed", checked, len(failed))
+		finishRun(nil)
+		return
 	}
 
-	// Формируем имя базы для проверенных URL-ов из cfg.Downloader.BaseURL
-	// Пример: https://data.bitget.com → bitget_checked_urls.db
-	baseURL := strings.TrimPrefix(cfg.Downloader.BaseURL, "https://")
-	baseURL = strings.TrimPrefix(baseURL, "http://")
-	baseURL = strings.Split(baseURL, "/")[0] // Берём домен
-	baseURL = strings.ReplaceAll(baseURL, ".", "_")
-	checkedUrlsDBName := fmt.Sprintf("%s_checked_urls.db", baseURL)
-	checkedUrlsDBPath := filepath.Join(cfg.Database.Path, checkedUrlsDBName)
-	if err := os.MkdirAll(filepath.Dir(checkedUrlsDBPath), 0755); err != nil {
-		log.Fatalf("Failed to create directory for checked URLs database %s: %v", checkedUrlsDBPath, err)
-	}
-	// Открываем SQLite с WAL и shared cache для многопоточности
-	checkedUrlsDB, err := sql.Open("sqlite3", checkedUrlsDBPath+"?_journal_mode=WAL&cache=shared")
-	if err != nil {
-		log.Fatalf("Failed to open checked URLs database %s: %v", checkedUrlsDBPath, err)
-	}
-	defer checkedUrlsDB.Close()
-
-	// Создаём таблицу checked_urls, если не существует
-	_, err = checkedUrlsDB.Exec(`
-		CREATE TABLE IF NOT EXISTS checked_urls (
-			url TEXT PRIMARY KEY,
-			status_code INTEGER NOT NULL,
-			content_length INTEGER NOT NULL,
-			checked_at TIMESTAMP NOT NULL
-		)
-	`)
-	if err != nil {
-		log.Fatalf("Failed to create checked_urls table: %v", err)
+	// Удаляем остатки прерванных запусков; рабочие базы не трогаем
+	if *cleanFlag {
+		runClean(cfg, *yesFlag)
+		return
 	}
 
-	// Создаём ProxyManager
-	timeout := time.Duration(*timeoutFlag) * time.Second
-	pm, err := proxymanager.NewProxyManager(cfg.Proxy.RawFile, cfg.Proxy.WorkingFile, cfg.Proxy.Fallback, cfg.Proxy.Username, cfg.Proxy.Password, timeout)
+	// Создаём движок загрузки, импорта и экспорта
+	if *proxyFileFlag != "" {
+		cfg.Proxy.StaticFile = *proxyFileFlag
+	}
+	if *concurrencyFlag > 0 {
+		cfg.Downloader.CheckConcurrency = *concurrencyFlag
+		cfg.Downloader.DownloadConcurrency = *concurrencyFlag
+		cfg.Proxy.CheckConcurrency = *concurrencyFlag
+	}
+	if *proxyFileCheckFlag {
+		cfg.Proxy.StaticCheck = true
+	}
+	maxBps := cfg.Downloader.MaxBytesPerSec
+	if *maxBpsFlag >= 0 {
+		maxBps = *maxBpsFlag
+	}
+	engOpts := engineOptions(cfg, *timeoutFlag, maxBps, *noCacheFlag, *quietFlag, *debugFlag)
+	engOpts.ReadOnly = *headOnlyCheckFlag // Отчёт о доступности ничего не пишет на диск
+	eng, err := engine.New(engOpts)
 	if err != nil {
-		log.Fatalf("Failed to create proxy manager: %v", err)
+		fatalf("Failed to create engine: %v", err)
 	}
+	defer eng.Close()
+	runEngine = eng
+	pm, dl := eng.ProxyManager(), eng.Downloader()
 
-	// Создаём Downloader
-	dl, err := downloader.NewDownloader(cfg.Downloader.BaseURL, cfg.Downloader.UserAgent, cfg.Datafiles.Path, pm, checkedUrlsDB)
-	if err != nil {
-		log.Fatalf("Failed to create downloader: %v", err)
+	// Только проверка прокси: без загрузки и импорта
+	if *proxyTestFlag {
+		if err := pm.EnsureProxies(ctx); err != nil {
+			fatalf("Proxy test failed: %v", err)
+		}
+		proxies, err := pm.GetProxies()
+		if err != nil {
+			fatalf("Failed to read working proxies: %v", err)
+		}
+		cmdutils.PrintProxyTest(os.Stdout, proxies, pm.Latency, 10)
+		finishRun(nil)
+		return
 	}
 
-	// Проверяем существующие архивы, если указан флаг --recheck-exists
-	if *recheckExists {
-		log.Println("Rechecking existing archives...")
-		brokenArchives, err := recheckExistingArchives(cfg.Datafiles.Path, *debugFlag)
+	// Проверяем существующие архивы, если указан флаг --recheck-exists или --recheck-only
+	if *recheckExists || *recheckOnlyFlag {
+		logging.Infof("Rechecking existing archives...")
+		brokenArchives, err := recheckExistingArchives(cfg.Datafiles.Path, cfg.Datafiles.RecheckConcurrency, *debugFlag)
 		if err != nil {
-			log.Fatalf("Failed to recheck archives: %v", err)
+			fatalf("Failed to recheck archives: %v", err)
+		}
+		if *reportFlag != "" {
+			if err := writeBrokenReport(*reportFlag, brokenArchives); err != nil {
+				fatalf("Failed to write report: %v", err)
+			}
+			logging.Infof("Wrote %d broken archive paths to %s", len(brokenArchives), *reportFlag)
+		}
+		if *recheckOnlyFlag {
+			logging.Infof("Found %d broken archives", len(brokenArchives))
+			finishRun(nil)
+			return
 		}
 		if len(brokenArchives) > 0 {
-			log.Printf("Found %d broken archives. Starting redownload...", len(brokenArchives))
-			redownloadBrokenArchives(brokenArchives, cfg, pm, dl)
+			logging.Infof("Found %d broken archives. Starting redownload...", len(brokenArchives))
+			redownloadBrokenArchives(ctx, brokenArchives, cfg, pm, dl)
 		} else {
-			log.Println("No broken archives found.")
+			logging.Infof("No broken archives found.")
 		}
 		return
 	}
+	// Дальше --report получает сводку запуска
+	runReportPath = *reportFlag
 
 	// Проверяем обязательный флаг --type
-	if *typeFlag == "" && !*exportMT5 {
-		log.Fatal("Error: --type (trades or depth) or --export-mt5 is required")
+	if *typeFlag == "" && !*exportMT5 && !*exportJSON && !*exportParquetFlag && !*exportTradesDepthFlag {
+		fatalf("Error: --type (trades, depth, kline or funding), --export-mt5, --export-json, --export-parquet or --export-trades-depth is required")
 	}
 
-	// Проверяем логику флагов
-	if *exportMT5 && *typeFlag != "" && *typeFlag != "depth" {
-		log.Println("Warning: --export-mt5 is ignored for --type trades")
-		*exportMT5 = false
+	if *typeFlag != "" && *typeFlag != "trades" && *typeFlag != "depth" && *typeFlag != "kline" && *typeFlag != "funding" {
+		fatalf("Error: invalid --type value: %s (must be trades, depth, kline or funding)", *typeFlag)
 	}
 
-	if !*exportMT5 && *typeFlag != "trades" && *typeFlag != "depth" {
-		log.Fatalf("Error: invalid --type value: %s (must be trades or depth)", *typeFlag)
+	// Проверяем market: spot, futures, all или код продукта Bitget (DMCBL и т.п.)
+	market, err := cmdutils.NormalizeMarket(*marketFlag)
+	if err != nil {
+		fatalf("Error: invalid --market value: %s (must be spot, futures, all or a Bitget product code such as DMCBL)", *marketFlag)
+	}
+	*marketFlag = market
+	if err := cmdutils.ValidateMarket(*typeFlag, *marketFlag); err != nil {
+		fatalf("Error: %v", err)
 	}
 
-	// Проверяем market
-	if *marketFlag != "spot" && *marketFlag != "futures" && *marketFlag != "all" {
-		log.Fatalf("Error: invalid --market value: %s (must be spot, futures or all)", *marketFlag)
+	// Таймфрейм свечей kline; пусто — по пути архива
+	var klineTimeframe string
+	if *klineTimeframeFlag != "" {
+		if klineTimeframe, err = db.ParseKlineTimeframe(*klineTimeframeFlag); err != nil {
+			fatalf("Error: %v", err)
+		}
 	}
 
 	// Устанавливаем даты
 	endDate := time.Now()
 	if *endFlag != "" {
 		var err error
-		endDate, err = time.Parse("2006-01-02", *endFlag)
+		endDate, err = cmdutils.ParseDate(*endFlag, *dateFormatFlag)
 		if err != nil {
-			log.Fatalf("Error: invalid --end format: %v", err)
+			fatalf("Error: invalid --end format: %v", err)
 		}
 	}
 	startDate := endDate.AddDate(-1, 0, 0)
 	if *startFlag != "" {
 		var err error
-		startDate, err = time.Parse("2006-01-02", *startFlag)
+		startDate, err = cmdutils.ParseDate(*startFlag, *dateFormatFlag)
 		if err != nil {
-			log.Fatalf("Error: invalid --start format: %v", err)
+			fatalf("Error: invalid --start format: %v", err)
 		}
 	}
 
 	// Проверяем даты
 	if startDate.After(endDate) {
-		log.Fatal("Error: start date is after end date")
+		fatalf("Error: start date is after end date")
 	}
-
-	// Включаем дебаг-логирование
-	if *debugFlag {
-		log.SetFlags(log.LstdFlags | log.Lshortfile)
+	timeframes, err := export.ParseTimeframes(*timeframesFlag)
+	if err != nil {
+		fatalf("Error: invalid --timeframes value: %v", err)
 	}
-
-	// Проверяем путь к базе
-	if cfg.Database.TempPath == "" || strings.Contains(cfg.Database.TempPath, "%s") {
-		log.Fatalf("Error: invalid temp database path in config: %s", cfg.Database.TempPath)
+	volume, err := export.ParseVolume(*volumeFlag)
+	if err != nil {
+		fatalf("Error: %v", err)
 	}
-	log.Printf("Using temp database path from config: %s", cfg.Database.TempPath)
-
-	if cfg.Database.Path == "" || strings.Contains(cfg.Database.Path, "%s") {
-		log.Fatalf("Error: invalid root database path in config: %s", cfg.Database.Path)
+	pairs, err := cmdutils.ParsePairs(*pairFlag, *pairsFileFlag)
+	if err != nil {
+		fatalf("Error: invalid pairs: %v", err)
 	}
-	log.Printf("Using root database path from config: %s", cfg.Database.Path)
+	runSummary.Pair = strings.Join(pairs, ",")
+	runSummary.Type = *typeFlag
+	runSummary.Market = *marketFlag
+	runSummary.StartDate = startDate.Format("2006-01-02")
+	runSummary.EndDate = endDate.Format("2006-01-02")
 
-	// Проверяем --repeat
-	if *repeatFlag && !*skipExistsFlag {
-		*repeatFlag = false
+	// Запрос к импортированным данным без сервера и загрузки
+	if *queryFlag {
+		if *typeFlag != "trades" && *typeFlag != "depth" {
+			fatalf("Error: --query requires --type trades or depth")
+		}
+		for _, pair := range pairs {
+			for _, target := range eng.Targets(*typeFlag, *marketFlag, pair) {
+				if err := queryTarget(target, pair, *typeFlag, startDate, endDate, *queryCSVFlag); err != nil {
+					fatalf("Query failed: %v", err)
+				}
+			}
+		}
+		return
 	}
 
-	// Собираем все ZIP-файлы из директорий spot (1) и futures (2)
-	marketCodes := []string{"1"} // spot
-	if *marketFlag == "futures" {
-		marketCodes = []string{"2"}
-	} else if *marketFlag == "all" {
-		marketCodes = []string{"1", "2"}
-	}
-
-	// Основной цикл
-	if *typeFlag != "" {
-		var proxies []string
-		for {
-			// Проверяем прокси, если не пропускаем загрузку
-			if !*skipDownloadFlag {
-				log.Println("Ensuring proxies...")
-				if err := pm.EnsureProxies(context.Background()); err != nil {
-					log.Printf("Warning: failed to ensure proxies: %v", err)
-					if len(proxies) == 0 {
-						log.Fatalf("No proxies available to continue")
-					}
-					log.Println("Continuing with last known proxies")
+	// Список дней без локальных данных: только файлы и базы, без сети
+	if *listMissingFlag {
+		if *typeFlag == "" {
+			fatalf("Error: --list-missing requires --type (trades, depth, kline or funding)")
+		}
+		for _, pair := range pairs {
+			dates, err := eng.MissingDates(engine.Spec{Pair: pair, Type: *typeFlag, Market: *marketFlag, Start: startDate, End: endDate})
+			if err != nil {
+				fatalf("Failed to list missing dates for %s: %v", pair, err)
+			}
+			logging.Infof("%s %s: %d missing dates from %s to %s", pair, *typeFlag, len(dates), runSummary.StartDate, runSummary.EndDate)
+			for _, d := range dates {
+				if len(pairs) > 1 {
+					fmt.Printf("%s %s\n", pair, d.Format("2006-01-02"))
 				} else {
-					proxies, err = pm.GetProxies()
-					if err != nil {
-						log.Printf("Warning: failed to get proxies: %v", err)
-						if len(proxies) == 0 {
-							log.Fatalf("No proxies available to continue")
-						}
-						log.Println("Continuing with last known proxies")
-					} else if len(proxies) == 0 {
-						log.Fatalf("No working proxies found")
-					} else {
-						log.Printf("Found %d working proxies", len(proxies))
-					}
+					fmt.Println(d.Format("2006-01-02"))
 				}
 			}
+		}
+		return
+	}
 
-			// Генерируем URL-ы
-			log.Println("Generating URLs...")
-			urls, err := cmdutils.GenerateURLs(dl, *marketFlag, *pairFlag, *typeFlag, startDate, endDate, *debugFlag, *skipExistsFlag, *skipDownloadFlag, cfg.Datafiles.Path)
+	// Удаление старых строк: каждая база очищается во временной копии и заменяет рабочую
+	if *pruneBeforeFlag != "" {
+		if *typeFlag == "" {
+			fatalf("Error: --prune-before requires --type (trades, depth, kline or funding)")
+		}
+		cutoff, err := cmdutils.ParseDate(*pruneBeforeFlag, *dateFormatFlag)
+		if err != nil {
+			fatalf("Error: invalid --prune-before format: %v", err)
+		}
+		var rows, freed int64
+		for _, pair := range pairs {
+			results, err := eng.PruneBefore(*typeFlag, *marketFlag, pair, cutoff)
 			if err != nil {
-				log.Fatalf("Failed to generate URLs: %v", err)
+				fatalf("Failed to prune %s: %v", pair, err)
 			}
-
-			if !*skipDownloadFlag {
-				// Запускаем загрузку
-				fmt.Fprintln(os.Stdout)
-				log.Println("Downloading files...")
-				if err := dl.DownloadFiles(context.Background(), urls); err != nil {
-					log.Printf("Warning: some files failed to download: %v", err)
-				}
+			for _, r := range results {
+				rows += r.Rows
+				freed += r.BytesFreed
 			}
+		}
+		logging.Infof("Pruned rows before %s: removed %d rows, freed %d bytes", cutoff.Format("2006-01-02"), rows, freed)
+		finishRun(nil)
+		return
+	}
 
-			// Группируем ZIP-файлы по типу и рынку
-			type ZipGroup struct {
-				TempDbPath string
-				dbPath     string
-				files      []string
+	// Перевод timestamp в секунды: каждая база правится во временной копии и заменяет рабочую
+	if *normalizeTimestampsFlag {
+		if *typeFlag == "" {
+			fatalf("Error: --normalize-timestamps requires --type (trades, depth, kline or funding)")
+		}
+		var converted, dropped int64
+		for _, pair := range pairs {
+			results, err := eng.NormalizeTimestamps(*typeFlag, *marketFlag, pair)
+			if err != nil {
+				fatalf("Failed to normalize timestamps of %s: %v", pair, err)
+			}
+			for _, r := range results {
+				converted += r.Converted
+				dropped += r.Dropped
 			}
+		}
+		logging.Infof("Normalized timestamps: converted %d rows to seconds, dropped %d duplicates", converted, dropped)
+		finishRun(nil)
+		return
+	}
 
-			// Обрабатываем trades
-			if *typeFlag == "trades" {
-				log.Println("Processing Trades...")
-				var zipGroups []ZipGroup
-				spblFiles := make([]string, 0)
-				umcblFiles := make([]string, 0)
-
-				// Определяем директории в зависимости от marketFlag
-				marketDirs := []string{}
-				if *marketFlag == "spot" {
-					marketDirs = append(marketDirs, "SPBL")
-				} else if *marketFlag == "futures" {
-					marketDirs = append(marketDirs, "UMCBL")
-				} else if *marketFlag == "all" {
-					marketDirs = append(marketDirs, "SPBL", "UMCBL")
-				}
+	// Только отчёт о доступности файлов: без заглушек, кэша и загрузки
+	if *headOnlyCheckFlag {
+		if *typeFlag == "" {
+			fatalf("Error: --head-only-check requires --type (trades, depth, kline or funding)")
+		}
+		// Рабочий список только читается: EnsureProxies перезаписал бы его
+		if err := pm.UseExistingProxies(ctx); err != nil {
+			fatalf("Failed to load proxies: %v (run --proxy-test first to build the working list)", err)
+		}
+		var results []cmdutils.Availability
+		for _, pair := range pairs {
+			results = append(results, cmdutils.CheckAvailability(ctx, dl, *marketFlag, pair, *typeFlag, startDate, endDate, *debugFlag)...)
+		}
+		cmdutils.PrintAvailability(os.Stdout, results)
+		finishRun(nil)
+		return
+	}
 
-				// Собираем все ZIP-файлы из директорий
-				for _, marketDir := range marketDirs {
-					dir := filepath.Join(cfg.Datafiles.Path, "trades", marketDir, *pairFlag)
-					if *debugFlag {
-						log.Printf("Scanning directory: %s", dir)
-					}
-					err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-						if err != nil {
-							log.Printf("Error accessing path %s: %v", path, err)
-							return nil
-						}
-						if !info.IsDir() && strings.HasSuffix(info.Name(), ".zip") {
-							// Фильтруем по датам
-							dateStr := strings.Split(strings.TrimSuffix(info.Name(), ".zip"), "_")[0]
-							if len(dateStr) != 8 {
-								if *debugFlag {
-									log.Printf("Skipping file %s: invalid date format", path)
-								}
-								return nil
-							}
-							fileDate, err := time.Parse("20060102", dateStr)
-							if err != nil {
-								if *debugFlag {
-									log.Printf("Skipping file %s: cannot parse date %s", path, dateStr)
-								}
-								return nil
-							}
-							if !fileDate.Before(startDate) && !fileDate.After(endDate) {
-								if marketDir == "SPBL" {
-									spblFiles = append(spblFiles, path)
-								} else if marketDir == "UMCBL" {
-									umcblFiles = append(umcblFiles, path)
-								}
-								if *debugFlag {
-									log.Printf("Added local file: %s", path)
-								}
-							}
-						}
-						return nil
-					})
-					if err != nil {
-						log.Printf("Failed to walk directory %s: %v", dir, err)
-					}
-				}
+	if *pairDiscoveryFlag {
+		if *typeFlag == "" {
+			fatalf("Error: --pair-discovery requires --type (trades, depth, kline or funding)")
+		}
+		// Архивы за сегодня ещё не выложены: по умолчанию проверяем вчерашний день
+		probeDate := endDate
+		if *endFlag == "" {
+			probeDate = endDate.AddDate(0, 0, -1)
+		}
+		logging.Infof("Ensuring proxies...")
+		if err := pm.EnsureProxies(ctx); err != nil {
+			fatalf("Failed to ensure proxies: %v", err)
+		}
+		results := cmdutils.DiscoverPairs(ctx, dl, *typeFlag, *marketFlag, pairs, probeDate, *debugFlag)
+		cmdutils.PrintPairDiscovery(os.Stdout, results)
+		finishRun(nil)
+		return
+	}
 
-				if (*marketFlag == "spot" || *marketFlag == "all") && len(spblFiles) > 0 {
-					dbPath := filepath.Join(cfg.Database.Path, "trades", "SPBL", *pairFlag+".db")
-					TempDbPath := filepath.Join(cfg.Database.TempPath, "trades", "SPBL", *pairFlag+".db")
-					sort.Strings(spblFiles)
-					log.Printf("Adding SPBL group: TempDbPath=%s, files=%v", TempDbPath, spblFiles)
-					zipGroups = append(zipGroups, ZipGroup{dbPath: dbPath, TempDbPath: TempDbPath, files: spblFiles})
-				}
-				if (*marketFlag == "futures" || *marketFlag == "all") && len(umcblFiles) > 0 {
-					dbPath := filepath.Join(cfg.Database.Path, "trades", "UMCBL", *pairFlag+".db")
-					TempDbPath := filepath.Join(cfg.Database.TempPath, "trades", "UMCBL", *pairFlag+".db")
-					sort.Strings(umcblFiles)
-					log.Printf("Adding UMCBL group: TempDbPath=%s, files=%v", TempDbPath, umcblFiles)
-					zipGroups = append(zipGroups, ZipGroup{dbPath: dbPath, TempDbPath: TempDbPath, files: umcblFiles})
-				}
-				if len(spblFiles) == 0 && len(umcblFiles) == 0 {
-					log.Printf("No trades files found")
+	logging.Infof("Using temp database path from config: %s", cfg.Database.TempPath)
+	logging.Infof("Using root database path from config: %s", cfg.Database.Path)
+
+	// Импорт CSV с пользовательской схемой колонок
+	if *importMappedFlag != "" {
+		if *typeFlag != "trades" && *typeFlag != "depth" {
+			fatalf("Error: --import-mapped requires --type (trades or depth)")
+		}
+		if len(cmdutils.MarketCodes(*typeFlag, *marketFlag)) != 1 {
+			fatalf("Error: --import-mapped requires --market spot, futures or a product code")
+		}
+		if len(pairs) != 1 {
+			fatalf("Error: --import-mapped requires a single --pair")
+		}
+		spec := engine.ImportSpec{
+			Spec:     engine.Spec{Pair: pairs[0], Type: *typeFlag, Market: *marketFlag},
+			NoShrink: *noShrinkFlag,
+			Force:    *forceFlag,
+		}
+		if err := eng.ImportMapped(*importMappedFlag, *mappingFlag, spec); err != nil {
+			fatalf("Failed to import %s: %v", *importMappedFlag, err)
+		}
+		finishRun(nil)
+		logging.Infof("Processing completed successfully")
+		return
+	}
+
+	// Импорт архивов из произвольного каталога
+	if *importDirFlag != "" {
+		if *typeFlag == "" {
+			fatalf("Error: --import-dir requires --type (trades, depth, kline or funding)")
+		}
+		if len(cmdutils.MarketCodes(*typeFlag, *marketFlag)) != 1 {
+			fatalf("Error: --import-dir requires --market spot, futures or a product code")
+		}
+		if len(pairs) != 1 {
+			fatalf("Error: --import-dir requires a single --pair")
+		}
+		spec := engine.ImportSpec{
+			Spec:     engine.Spec{Pair: pairs[0], Type: *typeFlag, Market: *marketFlag},
+			Rebuild:  *rebuildFlag,
+			Vacuum:   *vacuumFlag,
+			NoShrink: *noShrinkFlag,
+			Force:    *forceFlag,
+			Strict:   *strictFlag,
+
+			KlineTimeframe: klineTimeframe,
+		}
+		if err := eng.ImportDir(ctx, *importDirFlag, spec); err != nil {
+			fatalf("Failed to import %s: %v", *importDirFlag, err)
+		}
+		finishRun(nil)
+		logging.Infof("Processing completed successfully")
+		return
+	}
+
+	// Проверяем --repeat
+	if *repeatFlag && !*skipExistsFlag {
+		*repeatFlag = false
+	}
+
+	if *sinceLastFlag && *typeFlag == "" {
+		fatalf("Error: --since-last requires --type (trades, depth, kline or funding)")
+	}
+
+	// --dry-run только проверяет URL-ы
+	if *dryRunFlag {
+		if *typeFlag == "" {
+			fatalf("Error: --dry-run requires --type (trades, depth, kline or funding)")
+		}
+		if *skipDownloadFlag {
+			fatalf("Error: --dry-run cannot be combined with --skip-download")
+		}
+	}
+
+	// Прокси проверяем один раз на все пары и повторно — только между циклами --repeat.
+	// --dry-run пишет на диск только кэш checked_urls, поэтому рабочий список лишь читает
+	if *typeFlag != "" && *dryRunFlag {
+		if err := pm.UseExistingProxies(ctx); err != nil {
+			fatalf("Failed to load proxies: %v (run --proxy-test first to build the working list)", err)
+		}
+	} else if *typeFlag != "" && !*skipDownloadFlag {
+		if err := eng.EnsureProxies(ctx); err != nil {
+			fatalf("Error: %v", err)
+		}
+	}
+
+	// Параметры экспорта
+	exportSpec := engine.ExportSpec{
+		MT5:        *exportMT5,
+		JSON:       *exportJSON,
+		Timeframes: timeframes,
+		JSONLines:  *jsonLinesFlag,
+		FillGaps:   *fillGapsFlag,
+		WithSymbol: *withSymbolFlag,
+		Compact:    *compactFlag,
+		Append:     *appendFlag,
+		Volume:     volume,
+		SideVolume: *sideVolumeFlag,
+
+		TradesDepth: *exportTradesDepthFlag,
+		Parquet:     *exportParquetFlag,
+	}
+	if *volumeRefFlag != "" {
+		volumes, err := export.LoadDailyVolumes(*volumeRefFlag)
+		if err != nil {
+			fatalf("Failed to load volume reference: %v", err)
+		}
+		exportSpec.ExpectedVolumes = volumes
+		exportSpec.VolumeTolerance = *volumeTolFlag
+	}
+
+	// processPair загружает, импортирует и экспортирует данные одной пары
+	processPair := func(pair string) error {
+		spec := engine.Spec{Pair: pair, Type: *typeFlag, Market: *marketFlag, Start: startDate, End: endDate}
+
+		if *typeFlag != "" {
+			// Начало загрузки и импорта; экспорт по-прежнему охватывает весь период --start/--end
+			fetchSpec := spec
+			if *sinceLastFlag {
+				last, ok, err := eng.LastImported(*typeFlag, *marketFlag, pair)
+				if err != nil {
+					return fmt.Errorf("failed to find last imported date: %w", err)
 				}
-				for _, group := range zipGroups {
-					log.Printf("Processing database: %s with %d zip files", group.TempDbPath, len(group.files))
-					if err := os.MkdirAll(filepath.Dir(group.TempDbPath), 0755); err != nil {
-						log.Printf("Failed to create directory for %s: %v", group.TempDbPath, err)
-						continue
-					}
-					// Для trades: копируем существующую БД из dbPath в TempDbPath, если она существует
-					if _, err := os.Stat(group.dbPath); err == nil {
-						if *debugFlag {
-							log.Printf("Copying existing database from %s to %s", group.dbPath, group.TempDbPath)
-						}
-						srcFile, err := os.Open(group.dbPath)
-						if err != nil {
-							log.Printf("Failed to open source database %s: %v", group.dbPath, err)
-							continue
-						}
-						defer srcFile.Close()
-						dstFile, err := os.Create(group.TempDbPath)
-						if err != nil {
-							log.Printf("Failed to create temp database %s: %v", group.TempDbPath, err)
-							continue
-						}
-						defer dstFile.Close()
-						if _, err := io.Copy(dstFile, srcFile); err != nil {
-							log.Printf("Failed to copy database from %s to %s: %v", group.dbPath, group.TempDbPath, err)
-							continue
-						}
-					} else if *debugFlag {
-						log.Printf("No existing database found at %s, creating new one at %s", group.dbPath, group.TempDbPath)
-					}
-					dbInstance, err := db.NewDB(group.TempDbPath, *typeFlag)
-					if err != nil {
-						log.Printf("Failed to create database %s: %v", group.TempDbPath, err)
-						continue
-					}
-					if err := dbInstance.ProcessZipFiles(group.files, *debugFlag); err != nil {
-						log.Printf("Failed to process zip files for %s: %v", group.TempDbPath, err)
-					}
-					if err := dbInstance.Close(); err != nil {
-						log.Printf("Failed to close database %s: %v", group.TempDbPath, err)
-					}
-					if err := cmdutils.MoveTempDatabase(group.TempDbPath, group.dbPath, cfg.Database.BackupSuffix, *debugFlag); err != nil {
-						log.Fatalf("Error: %v\n", err)
-					}
+				if ok {
+					logging.Infof("Resuming %s %s from last imported date %s", pair, *typeFlag, last.Format("2006-01-02"))
+					fetchSpec.Start = last
+				} else {
+					logging.Infof("No imported %s data for %s yet, starting from %s", *typeFlag, pair, startDate.Format("2006-01-02"))
 				}
 			}
 
-			// Обрабатываем depth
-			if *typeFlag == "depth" {
-				log.Println("Processing Depth...")
-				dbPath := filepath.Join(cfg.Database.Path, "depth", *pairFlag+".db")
-				TempDbPath := filepath.Join(cfg.Database.TempPath, "depth", *pairFlag+".db")
-				var depthFiles []string
-
-				for _, marketCode := range marketCodes {
-					dir := filepath.Join(cfg.Datafiles.Path, "depth", *pairFlag, marketCode)
-					if *debugFlag {
-						log.Printf("Scanning directory: %s", dir)
-					}
-					err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-						if err != nil {
-							log.Printf("Error accessing path %s: %v", path, err)
-							return nil
-						}
-						if !info.IsDir() && strings.HasSuffix(info.Name(), ".zip") {
-							// Фильтруем по датам
-							dateStr := strings.Split(strings.TrimSuffix(info.Name(), ".zip"), "_")[0]
-							if len(dateStr) != 8 {
-								if *debugFlag {
-									log.Printf("Skipping file %s: invalid date format", path)
-								}
-								return nil
-							}
-							fileDate, err := time.Parse("20060102", dateStr)
-							if err != nil {
-								if *debugFlag {
-									log.Printf("Skipping file %s: cannot parse date %s", path, dateStr)
-								}
-								return nil
-							}
-							if !fileDate.Before(startDate) && !fileDate.After(endDate) {
-								depthFiles = append(depthFiles, path)
-								if *debugFlag {
-									log.Printf("Added local file: %s", path)
-								}
-							}
-						}
-						return nil
-					})
-					if err != nil {
-						log.Printf("Failed to walk directory %s: %v", dir, err)
+			for cycle := 0; ; cycle++ {
+				// Между циклами --repeat перепроверяем прокси
+				if cycle > 0 && !*skipDownloadFlag {
+					if err := eng.EnsureProxies(ctx); err != nil {
+						return err
 					}
 				}
 
-				if len(depthFiles) > 0 {
-					// Сортируем файлы в алфавитном порядке
-					sort.Strings(depthFiles)
-					log.Printf("Processing depth database: %s with %d zip files", TempDbPath, len(depthFiles))
-
-					// Создаём директорию для базы
-					if err := os.MkdirAll(filepath.Dir(TempDbPath), 0755); err != nil {
-						log.Printf("Failed to create directory for %s: %v", TempDbPath, err)
-					} else {
-						// Обрабатываем базу
-						dbInstance, err := db.NewDB(TempDbPath, *typeFlag)
-						if err != nil {
-							log.Printf("Failed to create database %s: %v", TempDbPath, err)
-						} else {
-							if err := dbInstance.ProcessZipFiles(depthFiles, *debugFlag); err != nil {
-								log.Printf("Failed to process zip files for %s: %v", TempDbPath, err)
-							}
-							if err := dbInstance.Close(); err != nil {
-								log.Printf("Failed to close database %s: %v", TempDbPath, err)
-							}
-						}
-					}
-				} else {
-					log.Printf("No depth files found for %s", TempDbPath)
+				result, err := eng.Download(ctx, engine.DownloadSpec{
+					Spec:           fetchSpec,
+					SkipExists:     *skipExistsFlag,
+					SkipDownload:   *skipDownloadFlag,
+					NoPlaceholders: *noPlaceholdersFlag,
+					DryRun:         *dryRunFlag,
+				})
+				if err != nil {
+					return err
 				}
-				if err := cmdutils.MoveTempDatabase(TempDbPath, dbPath, cfg.Database.BackupSuffix, *debugFlag); err != nil {
-					log.Fatalf("Error: %v\n", err)
+				runSummary.URLs += result.URLs
+				if *dryRunFlag {
+					fmt.Fprintln(os.Stdout)
+					cmdutils.PrintPlan(os.Stdout, pair, result.Plan)
+					return nil
 				}
-			}
-			log.Printf("Repeat cycle: %d URLs remaining, continuing...", len(urls))
 
-			// Проверяем, нужно ли повторять
-			if !*repeatFlag || len(urls) == 0 {
-				if *repeatFlag && len(urls) == 0 {
-					log.Println("Repeat cycle completed: no URLs remaining")
+				err = eng.Import(ctx, engine.ImportSpec{
+					Spec:     fetchSpec,
+					Rebuild:  *rebuildFlag,
+					Vacuum:   *vacuumFlag,
+					NoShrink: *noShrinkFlag,
+					Force:    *forceFlag,
+					Strict:   *strictFlag,
+
+					KlineTimeframe: klineTimeframe,
+				})
+				if err != nil {
+					return err
+				}
+				logging.Infof("Repeat cycle: %d URLs remaining, continuing...", result.URLs)
+
+				// Проверяем, нужно ли повторять
+				if !*repeatFlag || result.URLs == 0 {
+					if *repeatFlag && result.URLs == 0 {
+						logging.Infof("Repeat cycle completed: no URLs remaining")
+					}
+					break
 				}
-				break
 			}
 		}
+
+		// Экспорт (если указан --export-mt5, --export-json, --export-parquet или --export-trades-depth)
+		if !*exportMT5 && !*exportJSON && !*exportParquetFlag && !*exportTradesDepthFlag {
+			return nil
+		}
+		exportSpec.Spec = spec
+		outputFiles, err := eng.Export(ctx, exportSpec)
+		for _, outputFile := range outputFiles {
+			fmt.Println(outputFile) // Выводим имена файлов в stdout
+		}
+		return err
 	}
-	// Экспорт в MT5 CSV (если указан --export-mt5)
-	if *exportMT5 {
-		for _, marketCode := range marketCodes {
-			dbPath := filepath.Join(cfg.Database.Path, "depth", *pairFlag+".db")
-			outputFile, err := export.ExportToMT5CSV(dbPath, *pairFlag, marketCode, "m1", startDate, endDate)
-			if err != nil {
-				log.Printf("Failed to export to MT5 CSV: %v", err)
-			} else {
-				fmt.Println(outputFile) // Выводим имя файла в stdout
-			}
+
+	// Основной цикл: ошибка одной пары не останавливает остальные
+	var failedPairs []string
+	for _, pair := range pairs {
+		if ctx.Err() != nil {
+			break
+		}
+		if len(pairs) > 1 {
+			logging.Infof("Processing pair %s...", pair)
+		}
+		if err := processPair(pair); err != nil {
+			logging.Errorf("Failed to process pair %s: %v", pair, err)
+			failedPairs = append(failedPairs, pair)
+		}
+	}
+	if ctx.Err() != nil {
+		eng.Close()
+		fatalf("Interrupted by signal")
+	}
+	if len(failedPairs) > 0 {
+		fatalf("Failed pairs: %s", strings.Join(failedPairs, ","))
+	}
+
+	finishRun(nil)
+	logging.Infof("Processing completed successfully")
+}
+
+// finishRun завершает сводку запуска, сохраняет её в --report и отправляет уведомление,
+// если оно настроено.
+func finishRun(runErr error) {
+	if !runNotifier.Enabled() && runReportPath == "" {
+		return
+	}
+	runSummary.Finish(runErr)
+	if runEngine != nil {
+		stats := runEngine.Stats()
+		runSummary.Downloaded = stats.Downloaded
+		runSummary.SkippedExisting = stats.Skipped
+		runSummary.Failed = stats.Failed
+		runSummary.BrokenRedownloaded = stats.Redownloaded
+		runSummary.FailedURLs = stats.FailedURLs
+		runSummary.InsertedRows = stats.InsertedRows
+	}
+	if runReportPath != "" {
+		if err := runSummary.WriteFile(runReportPath); err != nil {
+			logging.Warnf("%v", err)
+		} else {
+			logging.Infof("Wrote run report to %s", runReportPath)
+		}
+	}
+	if !runNotifier.Enabled() {
+		return
+	}
+	payload, err := runSummary.JSON()
+	if err != nil {
+		logging.Warnf("failed to encode run summary: %v", err)
+		return
+	}
+	if err := runNotifier.Notify(context.Background(), payload); err != nil {
+		logging.Warnf("%v", err)
+	}
+}
+
+// runClean показывает, что удалит --clean, спрашивает подтверждение (без --yes) и удаляет.
+func runClean(cfg Config, yes bool) {
+	tmpRawDirs := []string{db.DefaultTmpRawDir}
+	if cfg.Datafiles.TmpRawPath != "" {
+		tmpRawDirs = append(tmpRawDirs, cfg.Datafiles.TmpRawPath)
+	}
+	items, err := cmdutils.PlanClean(cmdutils.CleanOptions{
+		DatabasePath:     cfg.Database.Path,
+		TempDatabasePath: cfg.Database.TempPath,
+		TmpRawDirs:       tmpRawDirs,
+		BackupSuffix:     cfg.Database.BackupSuffix,
+		BackupKeep:       cfg.Database.BackupKeep,
+	})
+	if err != nil {
+		fatalf("Failed to plan cleanup: %v", err)
+	}
+	if len(items) == 0 {
+		logging.Infof("Nothing to clean")
+		return
+	}
+	cmdutils.PrintCleanPlan(os.Stdout, items)
+	if !yes {
+		fmt.Fprintf(os.Stdout, "Remove these %d items? [y/N]: ", len(items))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			logging.Infof("Cleanup cancelled")
+			return
 		}
 	}
+	reclaimed, failed := cmdutils.RemoveCleanItems(items)
+	for _, f := range failed {
+		logging.Errorf("Failed to remove %s", f)
+	}
+	logging.Infof("Removed %d items, reclaimed %d bytes", len(items)-len(failed), reclaimed)
+	if len(failed) > 0 {
+		fatalf("Failed to remove %d items", len(failed))
+	}
+}
+
+// engineOptions собирает параметры движка из конфига и флагов.
+func engineOptions(cfg Config, proxyTimeout int, maxBps int64, noCache, quiet, debug bool) engine.Options {
+	return engine.Options{
+		DatabasePath:     cfg.Database.Path,
+		TempDatabasePath: cfg.Database.TempPath,
+		BackupSuffix:     cfg.Database.BackupSuffix,
+		BackupKeep:       cfg.Database.BackupKeep,
+		VacuumThreshold:  cfg.Database.VacuumThreshold,
+		ImportBatchSize:  cfg.Database.ImportBatchSize,
+		CreatePriceIndex: cfg.Database.CreatePriceIndex,
+		ImportWorkers:    cfg.Database.ImportWorkers,
+
+		DatabaseDriver: cfg.Database.Driver,
+		DatabaseDSN:    cfg.Database.DSN,
+
+		BusyTimeout: time.Duration(cfg.Database.BusyTimeout) * time.Millisecond,
+		Synchronous: cfg.Database.Synchronous,
+
+		DatafilesPath:  cfg.Datafiles.Path,
+		TmpRawPath:     cfg.Datafiles.TmpRawPath,
+		BaseURL:        cfg.Downloader.BaseURL,
+		UserAgent:      cfg.Downloader.UserAgent,
+		MaxBytesPerSec: maxBps,
+		NoCache:        noCache,
+		Quiet:          quiet,
+		Proxy: engine.ProxyOptions{
+			RawFile:     cfg.Proxy.RawFile,
+			WorkingFile: cfg.Proxy.WorkingFile,
+			Fallback:    cfg.Proxy.Fallback,
+			Username:    cfg.Proxy.Username,
+			Password:    cfg.Proxy.Password,
+			Timeout:     time.Duration(proxyTimeout) * time.Second,
+			StaticFile:  cfg.Proxy.StaticFile,
+			StaticCheck: cfg.Proxy.StaticCheck,
+
+			CheckConcurrency: cfg.Proxy.CheckConcurrency,
+
+			BlacklistFile:        cfg.Proxy.BlacklistFile,
+			BlacklistMinFailures: cfg.Proxy.BlacklistMinFailures,
+			BlacklistThreshold:   cfg.Proxy.BlacklistThreshold,
+			BlacklistCooldown:    time.Duration(cfg.Proxy.BlacklistCooldown) * time.Second,
+
+			AllowCIDRs:     cfg.Proxy.AllowCIDRs,
+			BlockCIDRs:     cfg.Proxy.BlockCIDRs,
+			KeepUnresolved: cfg.Proxy.KeepUnresolved,
+
+			SourceInterval: time.Duration(cfg.Proxy.SourceInterval) * time.Second,
+		},
+		CheckConcurrency:    cfg.Downloader.CheckConcurrency,
+		DownloadConcurrency: cfg.Downloader.DownloadConcurrency,
+
+		TradesMissRun: cfg.Downloader.TradesMissRun,
+		MaxFileBytes:  cfg.Downloader.MaxFileBytes,
+
+		DialTimeout:           time.Duration(cfg.Downloader.DialTimeout) * time.Second,
+		TLSHandshakeTimeout:   time.Duration(cfg.Downloader.TLSHandshakeTimeout) * time.Second,
+		ResponseHeaderTimeout: time.Duration(cfg.Downloader.ResponseHeaderTimeout) * time.Second,
+		StallTimeout:          time.Duration(cfg.Downloader.StallTimeout) * time.Second,
+
+		UserAgents:        cfg.Downloader.UserAgents,
+		UserAgentRotation: cfg.Downloader.UserAgentRotation,
+
+		ExportPath: cfg.Export.OutputPath,
+		Debug:      debug,
+	}
+}
 
-	log.Println("Processing completed successfully")
+// setupLogging настраивает логгер: флаги важнее конфига, --debug включает уровень debug.
+// Уровень debug, заданный любым способом, включает и --debug, от которого зависят
+// подробный вывод и сохранение временных файлов.
+func setupLogging(w io.Writer, cfg Config, levelFlag, formatFlag string, debug *bool) error {
+	opts := logging.Options{Level: cfg.Log.Level, Format: cfg.Log.Format}
+	if levelFlag != "" {
+		opts.Level = levelFlag
+	}
+	if formatFlag != "" {
+		opts.Format = formatFlag
+	}
+	if *debug {
+		opts.Level = "debug"
+	}
+	level, err := logging.ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+	if level == slog.LevelDebug {
+		*debug = true
+		opts.AddSource = true
+	}
+	return logging.Setup(w, opts)
+}
+
+// fatalf логирует ошибку, уведомляет о неудачном запуске и завершает процесс.
+func fatalf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	finishRun(errors.New(msg))
+	logging.Fatalf("%s", msg)
+}
+
+// queryTarget печатает сводку по базе за период; при dumpCSV сводка уходит в лог,
+// а строки периода — в stdout как CSV.
+func queryTarget(target engine.Target, pair, dataType string, startDate, endDate time.Time, dumpCSV bool) error {
+	summary, err := export.QuerySummary(target.DBPath, target.Market, startDate, endDate)
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%s %s %s: rows=%d", pair, target.Market, dataType, summary.Rows)
+	if summary.Rows > 0 {
+		line += fmt.Sprintf(" from=%s to=%s first_price=%g last_price=%g",
+			time.Unix(summary.FirstTime, 0).UTC().Format("2006-01-02 15:04:05"),
+			time.Unix(summary.LastTime, 0).UTC().Format("2006-01-02 15:04:05"),
+			summary.FirstPrice, summary.LastPrice)
+	}
+	if !dumpCSV {
+		fmt.Println(line)
+		return nil
+	}
+	logging.Infof("%s", line)
+	if _, err := export.DumpCSV(os.Stdout, target.DBPath, target.Market, startDate, endDate); err != nil {
+		return fmt.Errorf("failed to dump %s: %w", target.DBPath, err)
+	}
+	return nil
 }
 
-// recheckExistingArchives проверяет все ненулевые ZIP-архивы в директории и возвращает список битых
-func recheckExistingArchives(rootDir string, debug bool) ([]string, error) {
+// recheckExistingArchives проверяет все ненулевые ZIP-архивы в директории и возвращает список битых.
+// Архивы проверяются параллельно в concurrency потоков (0 — по числу CPU), порядок списка не гарантируется.
+func recheckExistingArchives(rootDir string, concurrency int, debug bool) ([]string, error) {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
 	var brokenArchives []string
-	log.Println("Rechecking existing archives...")
+	var mu sync.Mutex // Защищает brokenArchives и вывод статуса
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				logging.Debugf("Checking archive: %s", path)
+				// Проверяем, что файл является Zip
+				err := downloader.CheckZipFile(path)
+				mu.Lock()
+				if err != nil {
+					if debug {
+						logging.Debugf("Archive %s is broken", path)
+					} else if logging.Pretty() {
+						fmt.Fprintf(os.Stdout, "\rArchive %s is broken", path)
+					}
+					brokenArchives = append(brokenArchives, path)
+				} else {
+					if debug {
+						logging.Debugf("Archive %s is valid", path)
+					} else if logging.Pretty() {
+						fmt.Fprintf(os.Stdout, "\rArchive %s is valid", path)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	logging.Infof("Rechecking existing archives with %d workers...", concurrency)
 	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Printf("Error accessing path %s: %v", path, err)
+			logging.Errorf("Error accessing path %s: %v", path, err)
 			return nil // Пропускаем проблемные пути
 		}
 		if !info.IsDir() && strings.HasSuffix(strings.ToLower(info.Name()), ".zip") {
 			if info.Size() == 0 {
-				if debug {
-					log.Printf("Skipping zero-sized archive: %s", path)
-				}
+				logging.Debugf("Skipping zero-sized archive: %s", path)
 				return nil
 			}
-			if debug {
-				log.Printf("Checking archive: %s", path)
-			}
-			// Проверяем, что файл является Zip
-			if err := downloader.CheckZipFile(path); err != nil {
-				if debug {
-					log.Printf("Archive %s is broken", path)
-				} else {
-					fmt.Fprintf(os.Stdout, "\rArchive %s is broken", path)
-				}
-				brokenArchives = append(brokenArchives, path)
-			} else {
-				if debug {
-					log.Printf("Archive %s is valid", path)
-				} else {
-					fmt.Fprintf(os.Stdout, "\rArchive %s is valid", path)
-				}
-			}
+			paths <- path
 		}
 		return nil
 	})
+	close(paths)
+	wg.Wait()
 	if err != nil {
 		return nil, fmt.Errorf("failed to walk directory %s: %w", rootDir, err)
 	}
-	log.Println("Recheck done.")
+	fmt.Fprintln(os.Stdout)
+	logging.Infof("Recheck done.")
 	return brokenArchives, nil
 }
 
+// writeBrokenReport записывает отсортированный список битых архивов: JSON-массив,
+// если путь оканчивается на .json, иначе по пути в строке.
+func writeBrokenReport(path string, brokenArchives []string) error {
+	paths := append([]string{}, brokenArchives...)
+	sort.Strings(paths)
+	var data []byte
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		var err error
+		if data, err = json.MarshalIndent(paths, "", "  "); err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+		data = append(data, '\n')
+	} else {
+		for _, p := range paths {
+			data = append(data, p+"\n"...)
+		}
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeValidationReport пишет базы, не прошедшие --validate: JSON с проблемами, если путь
+// оканчивается на .json, иначе по строке "путь: проблемы" на базу.
+func writeValidationReport(path string, failed []cmdutils.ValidationResult) error {
+	var data []byte
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		if failed == nil {
+			failed = []cmdutils.ValidationResult{}
+		}
+		var err error
+		if data, err = json.MarshalIndent(failed, "", "  "); err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+		data = append(data, '\n')
+	} else {
+		for _, r := range failed {
+			data = append(data, r.Path+": "+strings.Join(r.Problems, "; ")+"\n"...)
+		}
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report %s: %w", path, err)
+	}
+	return nil
+}
+
 // redownloadBrokenArchives перезагружает битые архивы через валидные прокси
-func redownloadBrokenArchives(brokenArchives []string, cfg Config, pm *proxymanager.ProxyManager, dl *downloader.Downloader) {
+func redownloadBrokenArchives(ctx context.Context, brokenArchives []string, cfg Config, pm *proxymanager.ProxyManager, dl *downloader.Downloader) {
 	// Обновляем прокси
-	log.Println("Ensuring proxies for redownload...")
+	logging.Infof("Ensuring proxies for redownload...")
 	var proxies []string
-	if err := pm.EnsureProxies(context.Background()); err != nil {
-		log.Printf("Warning: failed to ensure proxies: %v", err)
+	if err := pm.EnsureProxies(ctx); err != nil {
+		logging.Warnf("failed to ensure proxies: %v", err)
 		proxies, err = pm.GetProxies()
 		if err != nil || len(proxies) == 0 {
-			log.Fatalf("No proxies available to continue")
+			fatalf("No proxies available to continue")
 		}
-		log.Println("Continuing with last known proxies")
+		logging.Infof("Continuing with last known proxies")
 	} else {
 		var err error
 		proxies, err = pm.GetProxies()
 		if err != nil || len(proxies) == 0 {
-			log.Fatalf("No working proxies found")
+			fatalf("No working proxies found")
 		}
-		log.Printf("Found %d working proxies", len(proxies))
+		logging.Infof("Found %d working proxies", len(proxies))
 	}
 
 	// Формируем список файлов для загрузки
@@ -609,7 +1141,7 @@ func redownloadBrokenArchives(brokenArchives []string, cfg Config, pm *proxymana
 		// Получаем относительный путь
 		relPath, err := filepath.Rel(cfg.Datafiles.Path, archive)
 		if err != nil {
-			log.Printf("Failed to get relative path for %s: %v", archive, err)
+			logging.Errorf("Failed to get relative path for %s: %v", archive, err)
 			continue
 		}
 		// Формируем URL
@@ -621,16 +1153,16 @@ func redownloadBrokenArchives(brokenArchives []string, cfg Config, pm *proxymana
 	}
 
 	if len(urls) == 0 {
-		log.Println("No valid URLs generated for broken archives")
+		logging.Infof("No valid URLs generated for broken archives")
 		return
 	}
 
 	// Запускаем загрузку
 	fmt.Fprintln(os.Stdout)
-	log.Printf("Redownloading %d broken archives...", len(urls))
-	if err := dl.DownloadFiles(context.Background(), urls); err != nil {
-		log.Printf("Warning: some files failed to redownload: %v", err)
+	logging.Infof("Redownloading %d broken archives...", len(urls))
+	if err := dl.DownloadFiles(ctx, urls); err != nil {
+		logging.Warnf("some files failed to redownload: %v", err)
 	} else {
-		log.Println("Redownload completed successfully")
+		logging.Infof("Redownload completed successfully")
 	}
 }
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/magf/bitget-history/internal/cmdutils"
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// DownloadSpec — параметры загрузки архивов.
+type DownloadSpec struct {
+	Spec
+	SkipExists     bool // Не скачивать архивы, уже лежащие на диске
+	SkipDownload   bool // Только перечислить URL-ы, ничего не скачивая
+	NoPlaceholders bool // Не создавать пустые заглушки для отсутствующих архивов
+	DryRun         bool // Только составить план загрузки
+}
+
+// DownloadResult — итог Download.
+type DownloadResult struct {
+	URLs int                   // Сколько URL-ов сформировано
+	Plan []cmdutils.MarketPlan // План загрузки при DryRun
+}
+
+// Download формирует URL-ы архивов за период и скачивает их через прокси. Ошибки отдельных
+// файлов логируются; ошибка возвращается, если URL-ы сформировать не удалось или ctx отменён.
+func (e *Engine) Download(ctx context.Context, spec DownloadSpec) (DownloadResult, error) {
+	logging.Infof("Generating URLs...")
+	// В DryRun на диск пишется только кэш checked_urls, поэтому без заглушек
+	placeholders := !spec.NoPlaceholders && !spec.DryRun
+	urls, err := cmdutils.GenerateURLs(ctx, e.dl, spec.Market, spec.Pair, spec.Type, spec.Start, spec.End, e.opts.Debug, spec.SkipExists, spec.SkipDownload, placeholders, e.opts.DatafilesPath)
+	if err != nil {
+		return DownloadResult{}, fmt.Errorf("failed to generate URLs: %w", err)
+	}
+	result := DownloadResult{URLs: len(urls)}
+	if spec.DryRun {
+		result.Plan = cmdutils.PlanDownloads(e.dl.BaseURL, urls)
+		return result, nil
+	}
+	if spec.SkipDownload {
+		return result, nil
+	}
+
+	if logging.Pretty() {
+		fmt.Fprintln(os.Stdout) // Завершаем строку прогресса
+	}
+	logging.Infof("Downloading files...")
+	if err := e.dl.DownloadFiles(ctx, urls); err != nil {
+		if ctx.Err() != nil {
+			return result, err
+		}
+		logging.Warnf("some files failed to download: %v", err)
+	}
+	return result, nil
+}
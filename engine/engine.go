@@ -0,0 +1,332 @@
+// Package engine — библиотечный интерфейс загрузки, импорта и экспорта архивов Bitget без CLI
+// и файлов конфигурации: все параметры передаются в Options.
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/magf/bitget-history/internal/db"
+	"github.com/magf/bitget-history/internal/downloader"
+	"github.com/magf/bitget-history/internal/logging"
+	"github.com/magf/bitget-history/internal/proxymanager"
+	_ "github.com/mattn/go-sqlite3" // Драйвер SQLite
+)
+
+// Options — параметры Engine; соответствуют разделам config.yaml.
+type Options struct {
+	// Базы данных
+	DatabasePath     string // Каталог рабочих баз
+	TempDatabasePath string // Каталог временных копий баз на время импорта
+	BackupSuffix     string // Расширение резервной копии заменяемой базы
+	BackupKeep       int    // Сколько резервных копий хранить (0 или 1 — одна)
+	VacuumThreshold  int64  // VACUUM после импорта не меньше стольких строк; 0 — отключено
+	ImportBatchSize  int    // Строк в транзакции импорта
+	CreatePriceIndex bool   // Индексы по ценам для запросов по диапазону цен
+	ImportWorkers    int    // Потоков распаковки архивов при импорте
+
+	BusyTimeout time.Duration // Ожидание блокировки баз SQLite другим подключением; 0 — по умолчанию драйвера
+	Synchronous string        // PRAGMA synchronous баз импорта: FULL, NORMAL или OFF; пусто — FULL
+
+	// Хранилище импорта trades и depth: "sqlite" (по умолчанию, файлы в DatabasePath) или "postgres".
+	// Остальные типы данных, экспорт и запросы всегда работают с файлами SQLite
+	DatabaseDriver string
+	DatabaseDSN    string // Строка подключения PostgreSQL
+
+	// Архивы
+	DatafilesPath string // Каталог скачанных архивов
+	TmpRawPath    string // Каталог распаковки архивов
+
+	// Загрузка
+	BaseURL        string
+	UserAgent      string
+	MaxBytesPerSec int64 // Общий предел скорости загрузки; 0 — без ограничения
+	NoCache        bool  // Проверять URL HEAD-запросами, не читая и не записывая кэш checked_urls
+	Quiet          bool  // Логировать только прогресс и ошибки загрузки
+	Proxy          ProxyOptions
+
+	// Параллельность; 0 — значения по умолчанию пакетов downloader и proxymanager
+	CheckConcurrency    int // Одновременных проверок URL
+	DownloadConcurrency int // Одновременных загрузок
+
+	TradesMissRun int // Отсутствующих подряд частей trades до конца перебора даты; 0 — по умолчанию
+
+	MaxFileBytes int64 // Предел размера одного скачиваемого файла; 0 — по умолчанию (2 ГиБ)
+
+	// Таймауты этапов HTTP-запроса; 0 — значения по умолчанию пакета downloader
+	DialTimeout           time.Duration // Подключение к прокси
+	TLSHandshakeTimeout   time.Duration // Рукопожатие TLS
+	ResponseHeaderTimeout time.Duration // Ожидание заголовков ответа
+	StallTimeout          time.Duration // Простой без данных при чтении тела
+
+	UserAgents        []string // Чередуемые User-Agent вместо UserAgent; пусто — всегда UserAgent
+	UserAgentRotation string   // random (по умолчанию) или round_robin
+
+	ExportPath string // Каталог выходных файлов экспорта
+
+	Debug bool // Подробные логи и сохранение временных файлов
+
+	ReadOnly bool // Только отчёты: без базы checked_urls (включает NoCache)
+}
+
+// ProxyOptions — параметры менеджера прокси.
+type ProxyOptions struct {
+	RawFile     string
+	WorkingFile string
+	Fallback    string
+	Username    string
+	Password    string
+	Timeout     time.Duration // Таймаут одной проверки прокси
+	StaticFile  string        // Собственный список рабочих прокси вместо скачивания и проверки
+	StaticCheck bool          // Отбрасывать недоступные прокси из StaticFile
+
+	CheckConcurrency int // Одновременных проверок прокси; 0 — по умолчанию
+
+	// Исключение часто отказывающих прокси; BlacklistCooldown 0 — выключено
+	BlacklistFile        string        // Статистика отказов между запусками
+	BlacklistMinFailures int           // Меньше отказов — прокси не исключается
+	BlacklistThreshold   float64       // Доля отказов, выше которой прокси исключается
+	BlacklistCooldown    time.Duration // Срок исключения после последнего отказа
+
+	// Отбор прокси по подсетям до проверки; пустые списки — без отбора
+	AllowCIDRs     []string // Оставлять только прокси из этих подсетей
+	BlockCIDRs     []string // Отбрасывать прокси из этих подсетей
+	KeepUnresolved bool     // Оставлять прокси, имя хоста которых не разрешилось в IP
+
+	SourceInterval time.Duration // Минимальный интервал между скачиваниями списков прокси; 0 — скачивать только при отсутствии RawFile
+}
+
+// Engine загружает, импортирует и экспортирует данные. Создаётся через New и закрывается Close.
+type Engine struct {
+	opts        Options
+	checkedURLs *sql.DB
+	pm          *proxymanager.ProxyManager
+	dl          *downloader.Downloader
+	proxies     []string // Последний известный список рабочих прокси
+
+	inserted map[string]int64 // База → строк вставлено за время работы Engine
+}
+
+// Stats — итоги загрузки и импорта за время работы Engine.
+type Stats struct {
+	downloader.DownloadStats
+	InsertedRows map[string]int64 // Путь базы SQLite или описание базы PostgreSQL → вставлено строк
+}
+
+// Stats возвращает итоги загрузок и число строк, вставленных в каждую базу.
+func (e *Engine) Stats() Stats {
+	stats := Stats{DownloadStats: e.dl.Stats(), InsertedRows: make(map[string]int64, len(e.inserted))}
+	for name, n := range e.inserted {
+		stats.InsertedRows[name] = n
+	}
+	return stats
+}
+
+// countInserted добавляет n вставленных строк к итогу базы name.
+func (e *Engine) countInserted(name string, n int64) {
+	if e.inserted == nil {
+		e.inserted = make(map[string]int64)
+	}
+	e.inserted[name] += n
+}
+
+// New проверяет пути, открывает кэш проверенных URL-ов и создаёт менеджер прокси и загрузчик.
+func New(opts Options) (*Engine, error) {
+	if opts.TempDatabasePath == "" || strings.Contains(opts.TempDatabasePath, "%s") {
+		return nil, fmt.Errorf("invalid temp database path: %s", opts.TempDatabasePath)
+	}
+	if opts.DatabasePath == "" || strings.Contains(opts.DatabasePath, "%s") {
+		return nil, fmt.Errorf("invalid root database path: %s", opts.DatabasePath)
+	}
+	switch opts.DatabaseDriver {
+	case "", "sqlite":
+	case "postgres":
+		if opts.DatabaseDSN == "" {
+			return nil, fmt.Errorf("database driver postgres requires a DSN")
+		}
+	default:
+		return nil, fmt.Errorf("invalid database driver: %s (must be sqlite or postgres)", opts.DatabaseDriver)
+	}
+
+	synchronous, err := db.ParseSynchronous(opts.Synchronous)
+	if err != nil {
+		return nil, err
+	}
+	opts.Synchronous = synchronous
+	if synchronous == "NORMAL" || synchronous == "OFF" {
+		logging.Warnf("Importing with synchronous=%s: faster, but a crash or power loss may lose or corrupt the last writes to a temporary database", synchronous)
+	}
+
+	// В режиме без записи на диск кэш проверенных URL-ов не открывается и не создаётся
+	var checkedURLs *sql.DB
+	if opts.ReadOnly {
+		opts.NoCache = true
+	} else {
+		checkedURLs, err = openCheckedURLs(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	closeCache := func() {
+		if checkedURLs != nil {
+			checkedURLs.Close()
+		}
+	}
+
+	pm, err := proxymanager.NewProxyManager(opts.Proxy.RawFile, opts.Proxy.WorkingFile, opts.Proxy.Fallback, opts.Proxy.Username, opts.Proxy.Password, opts.Proxy.Timeout)
+	if err != nil {
+		closeCache()
+		return nil, fmt.Errorf("failed to create proxy manager: %w", err)
+	}
+	pm.SetDebug(opts.Debug)
+	pm.SetCheckConcurrency(opts.Proxy.CheckConcurrency)
+	pm.SetSourceInterval(opts.Proxy.SourceInterval)
+	if opts.Proxy.StaticFile != "" {
+		pm.SetStaticFile(opts.Proxy.StaticFile, opts.Proxy.StaticCheck)
+	}
+	if opts.Proxy.BlacklistCooldown > 0 {
+		pm.SetBlacklist(proxymanager.BlacklistOptions{
+			File:        opts.Proxy.BlacklistFile,
+			MinFailures: opts.Proxy.BlacklistMinFailures,
+			Threshold:   opts.Proxy.BlacklistThreshold,
+			Cooldown:    opts.Proxy.BlacklistCooldown,
+		})
+	}
+	if err := pm.SetNetFilter(proxymanager.NetFilterOptions{
+		Allow:          opts.Proxy.AllowCIDRs,
+		Block:          opts.Proxy.BlockCIDRs,
+		KeepUnresolved: opts.Proxy.KeepUnresolved,
+	}); err != nil {
+		closeCache()
+		return nil, err
+	}
+
+	dl, err := downloader.NewDownloader(opts.BaseURL, opts.UserAgent, opts.DatafilesPath, pm, checkedURLs)
+	if err != nil {
+		closeCache()
+		return nil, fmt.Errorf("failed to create downloader: %w", err)
+	}
+	dl.SetCacheEnabled(!opts.NoCache)
+	dl.SetQuiet(opts.Quiet)
+	dl.SetConcurrency(opts.CheckConcurrency, opts.DownloadConcurrency)
+	dl.SetTradesMissRun(opts.TradesMissRun)
+	dl.SetMaxFileBytes(opts.MaxFileBytes)
+	dl.SetTimeouts(downloader.Timeouts{
+		Dial:           opts.DialTimeout,
+		TLSHandshake:   opts.TLSHandshakeTimeout,
+		ResponseHeader: opts.ResponseHeaderTimeout,
+		Stall:          opts.StallTimeout,
+	})
+	if err := dl.SetUserAgents(opts.UserAgents, opts.UserAgentRotation); err != nil {
+		closeCache()
+		return nil, err
+	}
+	if opts.MaxBytesPerSec > 0 {
+		logging.Infof("Limiting download speed to %d bytes/s", opts.MaxBytesPerSec)
+	}
+	dl.SetMaxBytesPerSec(opts.MaxBytesPerSec)
+
+	return &Engine{opts: opts, checkedURLs: checkedURLs, pm: pm, dl: dl}, nil
+}
+
+// openCheckedURLs открывает кэш проверенных URL-ов рядом с базами, создавая базу и таблицу checked_urls.
+func openCheckedURLs(opts Options) (*sql.DB, error) {
+	// Формируем имя базы для проверенных URL-ов из BaseURL
+	// Пример: https://data.bitget.com → bitget_checked_urls.db
+	baseURL := strings.TrimPrefix(opts.BaseURL, "https://")
+	baseURL = strings.TrimPrefix(baseURL, "http://")
+	baseURL = strings.Split(baseURL, "/")[0] // Берём домен
+	baseURL = strings.ReplaceAll(baseURL, ".", "_")
+	checkedUrlsDBPath := filepath.Join(opts.DatabasePath, fmt.Sprintf("%s_checked_urls.db", baseURL))
+	if err := os.MkdirAll(filepath.Dir(checkedUrlsDBPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for checked URLs database %s: %w", checkedUrlsDBPath, err)
+	}
+	// Открываем SQLite с WAL и shared cache для многопоточности
+	checkedDSN := checkedUrlsDBPath + "?_journal_mode=WAL&cache=shared"
+	if opts.BusyTimeout > 0 {
+		checkedDSN += fmt.Sprintf("&_busy_timeout=%d", opts.BusyTimeout.Milliseconds())
+	}
+	checkedURLs, err := sql.Open("sqlite3", checkedDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checked URLs database %s: %w", checkedUrlsDBPath, err)
+	}
+	// Создаём таблицу checked_urls, если не существует
+	_, err = checkedURLs.Exec(`
+		CREATE TABLE IF NOT EXISTS checked_urls (
+			url TEXT PRIMARY KEY,
+			status_code INTEGER NOT NULL,
+			content_length INTEGER NOT NULL,
+			checked_at TIMESTAMP NOT NULL,
+			etag TEXT NOT NULL DEFAULT '',
+			last_modified TEXT NOT NULL DEFAULT '',
+			downloaded_etag TEXT NOT NULL DEFAULT '',
+			downloaded_last_modified TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		checkedURLs.Close()
+		return nil, fmt.Errorf("failed to create checked_urls table: %w", err)
+	}
+	// Валидаторы сервера (etag, last_modified) и скачанной версии файла (downloaded_*)
+	// появились позже: в базе, созданной старой версией, добавляем колонки
+	for _, column := range []string{"etag", "last_modified", "downloaded_etag", "downloaded_last_modified"} {
+		if err := db.EnsureColumn(checkedURLs, checkedUrlsDBPath, "checked_urls", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			checkedURLs.Close()
+			return nil, err
+		}
+	}
+	return checkedURLs, nil
+}
+
+// Close закрывает кэш проверенных URL-ов.
+func (e *Engine) Close() error {
+	if e.checkedURLs == nil {
+		return nil
+	}
+	return e.checkedURLs.Close()
+}
+
+// Downloader возвращает загрузчик движка для вспомогательных режимов (перепроверка архивов и т.п.).
+func (e *Engine) Downloader() *downloader.Downloader {
+	return e.dl
+}
+
+// ProxyManager возвращает менеджер прокси движка.
+func (e *Engine) ProxyManager() *proxymanager.ProxyManager {
+	return e.pm
+}
+
+// EnsureProxies обновляет список рабочих прокси. Если обновить не удалось, продолжает
+// с последним известным списком, а без него возвращает ошибку.
+func (e *Engine) EnsureProxies(ctx context.Context) error {
+	logging.Infof("Ensuring proxies...")
+	if err := e.pm.EnsureProxies(ctx); err != nil {
+		logging.Warnf("failed to ensure proxies: %v", err)
+		if len(e.proxies) == 0 {
+			return errors.New("no proxies available to continue")
+		}
+		logging.Infof("Continuing with last known proxies")
+		return nil
+	}
+	list, err := e.pm.GetProxies()
+	if err != nil {
+		logging.Warnf("failed to get proxies: %v", err)
+		if len(e.proxies) == 0 {
+			return errors.New("no proxies available to continue")
+		}
+		logging.Infof("Continuing with last known proxies")
+		return nil
+	}
+	if len(list) == 0 {
+		return errors.New("no working proxies found")
+	}
+	e.proxies = list
+	logging.Infof("Found %d working proxies", len(e.proxies))
+	return nil
+}
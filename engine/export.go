@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/magf/bitget-history/internal/cmdutils"
+	"github.com/magf/bitget-history/internal/cmdutils/export"
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// ExportSpec — параметры экспорта импортированных данных.
+type ExportSpec struct {
+	Spec
+	MT5        bool     // Свечи MT5 CSV (для funding — CSV ставок)
+	JSON       bool     // Сырые строки depth или trades в JSON
+	Timeframes []string // Таймфреймы свечей MT5; пусто — m1
+	JSONLines  bool     // NDJSON вместо массива
+	FillGaps   bool     // Заполнять пустые интервалы свечами по цене предыдущего закрытия
+	WithSymbol bool     // Колонки Symbol и Market в начале строк MT5
+	Compact    bool     // depth в JSON: оба рынка одним файлом с полем market
+	Append     bool     // Дописывать свечи MT5 в <pair>_<market>_<tf>.csv с последней свечи файла
+	Volume     string   // Объём свечей сделок: export.VolumeBase или export.VolumeQuote
+
+	SideVolume bool // Колонки BuyVolume и SellVolume в свечах сделок MT5
+
+	TradesDepth bool // CSV сделок с ближайшей серединой спреда depth
+	Parquet     bool // Сырые строки depth или trades в Parquet
+
+	// Сверка дневного объёма сделок с эталоном (только для trades в MT5)
+	ExpectedVolumes map[string]float64 // Объёмы по дням YYYY-MM-DD; nil — сверка отключена
+	VolumeTolerance float64            // Допустимое относительное расхождение
+}
+
+// Export выгружает данные пары за период и возвращает имена созданных файлов. Ошибки отдельных
+// выгрузок логируются и не останавливают остальные.
+func (e *Engine) Export(ctx context.Context, spec ExportSpec) ([]string, error) {
+	opts := export.Options{
+		OutputDir:       e.opts.ExportPath,
+		JSONLines:       spec.JSONLines,
+		FillGaps:        spec.FillGaps,
+		WithSymbol:      spec.WithSymbol,
+		Append:          spec.Append,
+		Volume:          spec.Volume,
+		SideVolume:      spec.SideVolume,
+		ExpectedVolumes: spec.ExpectedVolumes,
+		VolumeTolerance: spec.VolumeTolerance,
+	}
+	timeframes := spec.Timeframes
+	if len(timeframes) == 0 {
+		timeframes = []string{"m1"}
+	}
+	pair := spec.Pair
+	var outputFiles []string
+
+	if spec.TradesDepth {
+		// Сделки рынка сопоставляются с таблицей depth того же рынка (SPBL — 1, UMCBL — 2)
+		depthDBPath := filepath.Join(e.opts.DatabasePath, "depth", pair+".db")
+		for _, marketDir := range cmdutils.MarketCodes("trades", spec.Market) {
+			depthTables := cmdutils.MarketCodes("depth", marketDir)
+			if len(depthTables) == 0 {
+				logging.Infof("No depth data for market %s, skipping trades with depth export", marketDir)
+				continue
+			}
+			tradesDBPath := filepath.Join(e.opts.DatabasePath, "trades", marketDir, pair+".db")
+			outputFile, err := export.ExportTradesWithDepth(tradesDBPath, depthDBPath, pair, marketDir, depthTables[0], spec.Start, spec.End, opts)
+			if err != nil {
+				logging.Errorf("Failed to export trades with depth: %v", err)
+			} else if outputFile != "" {
+				outputFiles = append(outputFiles, outputFile)
+			}
+		}
+	}
+
+	if spec.Type == "funding" {
+		// Ставки финансирования выгружаются простым CSV вместо свечей MT5
+		if spec.JSON || spec.Parquet {
+			logging.Infof("JSON and Parquet export of funding data is not supported")
+		}
+		if spec.MT5 {
+			for _, marketDir := range cmdutils.MarketCodes(spec.Type, spec.Market) {
+				dbPath := filepath.Join(e.opts.DatabasePath, "funding", marketDir, pair+".db")
+				outputFile, err := export.ExportFundingToCSV(dbPath, pair, spec.Start, spec.End, opts)
+				if err != nil {
+					logging.Errorf("Failed to export funding to CSV: %v", err)
+				} else if outputFile != "" {
+					outputFiles = append(outputFiles, outputFile)
+				}
+			}
+		}
+		return outputFiles, nil
+	}
+
+	if spec.MT5 && spec.SideVolume && spec.Type == "depth" {
+		logging.Infof("--side-volume applies to trades candles only, depth candles are written without BuyVolume/SellVolume")
+	}
+
+	compact := spec.Compact && spec.Type == "depth" && spec.JSON
+	if compact {
+		// Обе таблицы рынков выгружаются одним файлом через представление depth_all
+		outputFile, err := export.ExportToJSON(filepath.Join(e.opts.DatabasePath, "depth", pair+".db"), pair, export.DepthAllMarket, spec.Start, spec.End, opts)
+		if err != nil {
+			logging.Errorf("Failed to export to JSON: %v", err)
+		} else if outputFile != "" {
+			outputFiles = append(outputFiles, outputFile)
+		}
+	}
+	for _, target := range e.Targets(spec.Type, spec.Market, pair) {
+		if err := ctx.Err(); err != nil {
+			return outputFiles, err
+		}
+		if spec.MT5 {
+			var files []string
+			var err error
+			if target.Trades {
+				files, err = export.ExportTradesToMT5CSV(target.DBPath, pair, target.Market, timeframes, spec.Start, spec.End, opts)
+			} else {
+				files, err = export.ExportToMT5CSV(target.DBPath, pair, target.Market, timeframes, spec.Start, spec.End, opts)
+			}
+			if err != nil {
+				logging.Errorf("Failed to export to MT5 CSV: %v", err)
+			}
+			outputFiles = append(outputFiles, files...)
+		}
+		if spec.Parquet {
+			outputFile, err := export.ExportToParquet(target.DBPath, pair, target.Market, spec.Start, spec.End, opts)
+			if err != nil {
+				logging.Errorf("Failed to export to Parquet: %v", err)
+			} else if outputFile != "" {
+				outputFiles = append(outputFiles, outputFile)
+			}
+		}
+		if spec.JSON && !compact {
+			outputFile, err := export.ExportToJSON(target.DBPath, pair, target.Market, spec.Start, spec.End, opts)
+			if err != nil {
+				logging.Errorf("Failed to export to JSON: %v", err)
+			} else if outputFile != "" {
+				outputFiles = append(outputFiles, outputFile)
+			}
+		}
+	}
+	return outputFiles, nil
+}
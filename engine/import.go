@@ -0,0 +1,400 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/magf/bitget-history/internal/cmdutils"
+	"github.com/magf/bitget-history/internal/db"
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// ImportSpec — параметры импорта скачанных архивов.
+type ImportSpec struct {
+	Spec
+	Rebuild  bool // Пересоздать таблицы рынков depth вместо инкрементального импорта
+	Vacuum   bool // VACUUM и ANALYZE после импорта
+	NoShrink bool // Не заменять базу базой с меньшим числом строк
+	Force    bool // Заменять базу даже при срабатывании NoShrink
+	Strict   bool // Не заменять базу, если строк в CSV архива не столько, сколько вставлено и пропущено
+
+	KlineTimeframe string // Таймфрейм свечей kline; пусто — из пути архива или m1
+}
+
+// importOptions возвращает параметры импорта Zip-файлов.
+func (e *Engine) importOptions(vacuum bool) db.ImportOptions {
+	return db.ImportOptions{
+		TmpRawDir:       e.opts.TmpRawPath,
+		Vacuum:          vacuum,
+		VacuumThreshold: e.opts.VacuumThreshold,
+		BatchSize:       e.opts.ImportBatchSize,
+		Schema:          e.schemaOptions(),
+		Workers:         e.opts.ImportWorkers,
+	}
+}
+
+// schemaOptions возвращает необязательные индексы и параметры подключения баз SQLite.
+func (e *Engine) schemaOptions() db.SchemaOptions {
+	return db.SchemaOptions{
+		PriceIndex:  e.opts.CreatePriceIndex,
+		BusyTimeout: e.opts.BusyTimeout,
+		Synchronous: e.opts.Synchronous,
+	}
+}
+
+// moveOptions возвращает параметры замены рабочей базы.
+func (e *Engine) moveOptions(noShrink, force bool) cmdutils.MoveOptions {
+	return cmdutils.MoveOptions{NoShrink: noShrink, Force: force, BackupKeep: e.opts.BackupKeep}
+}
+
+// Import импортирует скачанные архивы пары за период во временные копии баз и заменяет ими
+// рабочие базы. Ошибка одной базы логируется и не останавливает остальные; ошибка возвращается
+// при отмене ctx или если базу не удалось заменить.
+func (e *Engine) Import(ctx context.Context, spec ImportSpec) error {
+	if e.opts.DatabaseDriver == "postgres" && (spec.Type == "trades" || spec.Type == "depth") {
+		return e.importPostgres(ctx, spec)
+	}
+	importOpts := e.importOptions(spec.Vacuum)
+	importOpts.Strict = spec.Strict
+	importOpts.KlineTimeframe = spec.KlineTimeframe
+	moveOpts := e.moveOptions(spec.NoShrink, spec.Force)
+	pair := spec.Pair
+
+	// Обрабатываем trades, kline и funding: отдельная база на рынок
+	if spec.Type != "depth" {
+		logging.Infof("Processing %s...", spec.Type)
+		for _, marketDir := range cmdutils.MarketCodes(spec.Type, spec.Market) {
+			dir := filepath.Join(e.opts.DatafilesPath, spec.Type, marketDir, pair)
+			files := collectArchives(dir, spec.Start, spec.End)
+			dbPath := filepath.Join(e.opts.DatabasePath, spec.Type, marketDir, pair+".db")
+			TempDbPath := filepath.Join(e.opts.TempDatabasePath, spec.Type, marketDir, pair+".db")
+			if len(files) == 0 {
+				logging.Infof("No %s files found for %s", spec.Type, TempDbPath)
+				continue
+			}
+			logging.Infof("Processing %s database: %s with %d zip files", spec.Type, TempDbPath, len(files))
+			inserted, err := importArchives(ctx, spec.Type, dbPath, TempDbPath, files, nil, importOpts, false, e.opts.Debug)
+			if err != nil {
+				if ctx.Err() != nil || errors.Is(err, db.ErrRowCountMismatch) {
+					return err
+				}
+				logging.Errorf("Failed to import %s database %s: %v", spec.Type, TempDbPath, err)
+				continue
+			}
+			if err := cmdutils.MoveTempDatabase(TempDbPath, dbPath, e.opts.BackupSuffix, moveOpts, e.opts.Debug); err != nil {
+				return err
+			}
+			e.countInserted(dbPath, inserted)
+		}
+		return nil
+	}
+
+	// Обрабатываем depth: одна база на пару, таблица на рынок
+	logging.Infof("Processing Depth...")
+	marketCodes := cmdutils.MarketCodes("depth", spec.Market)
+	dbPath := filepath.Join(e.opts.DatabasePath, "depth", pair+".db")
+	TempDbPath := filepath.Join(e.opts.TempDatabasePath, "depth", pair+".db")
+	var depthFiles []string
+	for _, marketCode := range marketCodes {
+		depthFiles = append(depthFiles, collectArchives(filepath.Join(e.opts.DatafilesPath, "depth", pair, marketCode), spec.Start, spec.End)...)
+	}
+	if len(depthFiles) == 0 {
+		logging.Infof("No depth files found for %s", TempDbPath)
+		return nil
+	}
+	// Сортируем файлы в алфавитном порядке
+	sort.Strings(depthFiles)
+	logging.Infof("Processing depth database: %s with %d zip files", TempDbPath, len(depthFiles))
+	inserted, err := importArchives(ctx, "depth", dbPath, TempDbPath, depthFiles, marketCodes, importOpts, spec.Rebuild, e.opts.Debug)
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, db.ErrRowCountMismatch) {
+			return err
+		}
+		logging.Errorf("Failed to import depth database %s: %v", TempDbPath, err)
+		return nil
+	}
+	if err := cmdutils.MoveTempDatabase(TempDbPath, dbPath, e.opts.BackupSuffix, moveOpts, e.opts.Debug); err != nil {
+		return err
+	}
+	e.countInserted(dbPath, inserted)
+	return nil
+}
+
+// importPostgres импортирует архивы trades или depth пары прямо в PostgreSQL: временные копии
+// и замена баз не нужны, повторы отсекаются ограничениями уникальности.
+func (e *Engine) importPostgres(ctx context.Context, spec ImportSpec) error {
+	if spec.Rebuild {
+		return fmt.Errorf("rebuild is not supported with the postgres database driver")
+	}
+	importOpts := e.importOptions(false)
+	importOpts.Strict = spec.Strict
+	// Для depth одно подключение на пару, рынок каждой строки определяется по пути архива
+	markets := []string{""}
+	if spec.Type == "trades" {
+		markets = cmdutils.MarketCodes(spec.Type, spec.Market)
+	}
+	for _, market := range markets {
+		var files []string
+		if spec.Type == "trades" {
+			files = collectArchives(filepath.Join(e.opts.DatafilesPath, "trades", market, spec.Pair), spec.Start, spec.End)
+		} else {
+			for _, marketCode := range cmdutils.MarketCodes("depth", spec.Market) {
+				files = append(files, collectArchives(filepath.Join(e.opts.DatafilesPath, "depth", spec.Pair, marketCode), spec.Start, spec.End)...)
+			}
+			sort.Strings(files)
+		}
+		if len(files) == 0 {
+			logging.Infof("No %s files found for %s %s", spec.Type, spec.Pair, market)
+			continue
+		}
+
+		dbInstance, err := db.NewPostgresDB(e.opts.DatabaseDSN, spec.Type, spec.Pair, market, importOpts.Schema)
+		if err != nil {
+			return err
+		}
+		logging.Infof("Importing %d %s zip files for %s into PostgreSQL", len(files), spec.Type, spec.Pair)
+		err = dbInstance.ProcessZipFiles(ctx, files, importOpts, e.opts.Debug)
+		e.countInserted(dbInstance.Name(), dbInstance.Inserted())
+		if err != nil {
+			dbInstance.Close()
+			if ctx.Err() != nil || errors.Is(err, db.ErrRowCountMismatch) {
+				return err
+			}
+			logging.Errorf("Failed to process zip files for %s: %v", spec.Pair, err)
+			continue
+		}
+		if err := dbInstance.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportMapped импортирует CSV с пользовательской схемой колонок (см. db.ParseColumnMapping)
+// в базу пары через временную копию. Тип — trades или depth, рынок — один: spot, futures или код продукта.
+func (e *Engine) ImportMapped(csvPath, mapping string, spec ImportSpec) error {
+	if spec.Type != "trades" && spec.Type != "depth" {
+		return fmt.Errorf("mapped import requires type trades or depth")
+	}
+	marketCodes := cmdutils.MarketCodes(spec.Type, spec.Market)
+	if len(marketCodes) != 1 {
+		return fmt.Errorf("mapped import requires a single market (spot, futures or a product code)")
+	}
+	columns, err := db.ParseColumnMapping(mapping)
+	if err != nil {
+		return err
+	}
+
+	// Выбираем базу и таблицу так же, как основной импорт
+	var dbPath, tempDbPath, tableName string
+	if spec.Type == "trades" {
+		marketDir := marketCodes[0]
+		dbPath = filepath.Join(e.opts.DatabasePath, "trades", marketDir, spec.Pair+".db")
+		tempDbPath = filepath.Join(e.opts.TempDatabasePath, "trades", marketDir, spec.Pair+".db")
+	} else {
+		tableName = marketCodes[0]
+		dbPath = filepath.Join(e.opts.DatabasePath, "depth", spec.Pair+".db")
+		tempDbPath = filepath.Join(e.opts.TempDatabasePath, "depth", spec.Pair+".db")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(tempDbPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", tempDbPath, err)
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		logging.Debugf("Copying existing database from %s to %s", dbPath, tempDbPath)
+		if err := copyDatabase(dbPath, tempDbPath); err != nil {
+			return err
+		}
+	}
+
+	dbInstance, err := db.NewDB(tempDbPath, spec.Type, e.schemaOptions())
+	if err != nil {
+		return fmt.Errorf("failed to create database %s: %w", tempDbPath, err)
+	}
+	importErr := dbInstance.ImportMappedCSV(csvPath, columns, tableName, e.opts.Debug)
+	inserted := dbInstance.Inserted()
+	if err := dbInstance.Close(); err != nil {
+		logging.Errorf("Failed to close database %s: %v", tempDbPath, err)
+	}
+	if importErr != nil {
+		return importErr
+	}
+	if err := cmdutils.MoveTempDatabase(tempDbPath, dbPath, e.opts.BackupSuffix, e.moveOptions(spec.NoShrink, spec.Force), e.opts.Debug); err != nil {
+		return err
+	}
+	e.countInserted(dbPath, inserted)
+	return nil
+}
+
+// ImportDir импортирует все ZIP-архивы каталога dir (рекурсивно) в базу пары, не требуя структуры
+// каталогов Bitget: тип, рынок и пара берутся из spec, рынок — один. Даты в именах архивов не проверяются.
+func (e *Engine) ImportDir(ctx context.Context, dir string, spec ImportSpec) error {
+	marketCodes := cmdutils.MarketCodes(spec.Type, spec.Market)
+	if len(marketCodes) != 1 {
+		return fmt.Errorf("directory import requires a single market (spot, futures or a product code)")
+	}
+	files, err := collectZips(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no zip files found in %s", dir)
+	}
+	importOpts := e.importOptions(spec.Vacuum)
+	importOpts.MarketCode = marketCodes[0]
+	importOpts.Strict = spec.Strict
+	importOpts.KlineTimeframe = spec.KlineTimeframe
+
+	if e.opts.DatabaseDriver == "postgres" && (spec.Type == "trades" || spec.Type == "depth") {
+		market := marketCodes[0]
+		if spec.Type == "depth" {
+			market = "" // Рынок строки depth берётся из MarketCode
+		}
+		dbInstance, err := db.NewPostgresDB(e.opts.DatabaseDSN, spec.Type, spec.Pair, market, importOpts.Schema)
+		if err != nil {
+			return err
+		}
+		logging.Infof("Importing %d %s zip files from %s for %s into PostgreSQL", len(files), spec.Type, dir, spec.Pair)
+		err = dbInstance.ProcessZipFiles(ctx, files, importOpts, e.opts.Debug)
+		e.countInserted(dbInstance.Name(), dbInstance.Inserted())
+		if closeErr := dbInstance.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	// Выбираем базу так же, как основной импорт
+	dbPath := filepath.Join(e.opts.DatabasePath, spec.Type, marketCodes[0], spec.Pair+".db")
+	tempDbPath := filepath.Join(e.opts.TempDatabasePath, spec.Type, marketCodes[0], spec.Pair+".db")
+	if spec.Type == "depth" {
+		dbPath = filepath.Join(e.opts.DatabasePath, "depth", spec.Pair+".db")
+		tempDbPath = filepath.Join(e.opts.TempDatabasePath, "depth", spec.Pair+".db")
+	}
+	logging.Infof("Processing %s database: %s with %d zip files from %s", spec.Type, tempDbPath, len(files), dir)
+	inserted, err := importArchives(ctx, spec.Type, dbPath, tempDbPath, files, marketCodes, importOpts, spec.Rebuild, e.opts.Debug)
+	if err != nil {
+		return err
+	}
+	if err := cmdutils.MoveTempDatabase(tempDbPath, dbPath, e.opts.BackupSuffix, e.moveOptions(spec.NoShrink, spec.Force), e.opts.Debug); err != nil {
+		return err
+	}
+	e.countInserted(dbPath, inserted)
+	return nil
+}
+
+// collectZips возвращает отсортированные ZIP-архивы каталога и его подкаталогов.
+func collectZips(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(strings.ToLower(d.Name()), ".zip") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", dir, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// importArchives импортирует архивы во временную копию базы. Существующая база копируется,
+// поэтому импорт инкрементальный; при rebuild таблицы рынков depth пересоздаются.
+// Возвращает число вставленных строк.
+func importArchives(ctx context.Context, dataType, dbPath, tempDbPath string, files, marketCodes []string, opts db.ImportOptions, rebuild, debug bool) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(tempDbPath), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory for %s: %w", tempDbPath, err)
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		logging.Debugf("Copying existing database from %s to %s", dbPath, tempDbPath)
+		// Файлы закрываются внутри copyDatabase, до открытия копии в NewDB
+		if err := copyDatabase(dbPath, tempDbPath); err != nil {
+			return 0, err
+		}
+	} else {
+		logging.Debugf("No existing database found at %s, creating new one at %s", dbPath, tempDbPath)
+	}
+
+	dbInstance, err := db.NewDB(tempDbPath, dataType, opts.Schema)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create database %s: %w", tempDbPath, err)
+	}
+	if rebuild && dataType == "depth" {
+		if err := dbInstance.ResetDepthTables(marketCodes...); err != nil {
+			dbInstance.Close()
+			return 0, err
+		}
+	}
+	if err := dbInstance.ProcessZipFiles(ctx, files, opts, debug); err != nil {
+		if ctx.Err() != nil || errors.Is(err, db.ErrRowCountMismatch) {
+			// Прерванный импорт и потерянные при --strict строки не заменяют рабочую базу
+			dbInstance.Close()
+			return 0, err
+		}
+		logging.Errorf("Failed to process zip files for %s: %v", tempDbPath, err)
+	}
+	return dbInstance.Inserted(), dbInstance.Close()
+}
+
+// collectArchives возвращает отсортированные ZIP-архивы каталога, дата которых (YYYYMMDD в начале
+// имени) попадает в диапазон.
+func collectArchives(dir string, startDate, endDate time.Time) []string {
+	logging.Debugf("Scanning directory: %s", dir)
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			logging.Errorf("Error accessing path %s: %v", path, err)
+			return nil
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".zip") {
+			return nil
+		}
+		dateStr := strings.Split(strings.TrimSuffix(info.Name(), ".zip"), "_")[0]
+		fileDate, err := time.Parse("20060102", dateStr)
+		if err != nil {
+			logging.Debugf("Skipping file %s: cannot parse date %s", path, dateStr)
+			return nil
+		}
+		if !fileDate.Before(startDate) && !fileDate.After(endDate) {
+			files = append(files, path)
+			logging.Debugf("Added local file: %s", path)
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Errorf("Failed to walk directory %s: %v", dir, err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// copyDatabase копирует файл базы и закрывает оба файла до возврата.
+func copyDatabase(srcPath, dstPath string) error {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open source database %s: %w", srcPath, err)
+	}
+	defer srcFile.Close()
+	dstFile, err := os.Create(dstPath)
+	if err != nil {
+		return fmt.Errorf("failed to create temp database %s: %w", dstPath, err)
+	}
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		dstFile.Close()
+		return fmt.Errorf("failed to copy database from %s to %s: %w", srcPath, dstPath, err)
+	}
+	if err := dstFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp database %s: %w", dstPath, err)
+	}
+	return nil
+}
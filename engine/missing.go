@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magf/bitget-history/internal/cmdutils"
+	"github.com/magf/bitget-history/internal/cmdutils/export"
+)
+
+// archiveDay — локальные архивы одного дня.
+type archiveDay struct {
+	parts    []int // Номера частей trades (_NNN.zip)
+	complete bool  // Для trades — части идут подряд с 001, иначе — есть непустой архив
+}
+
+// MissingDates возвращает отсортированные дни периода, за которые у пары нет данных локально.
+// День считается загруженным, если есть его непустые архивы, а для trades — части без пропусков
+// начиная с _001.zip. Если архивов дня нет (например, они удалены после импорта), день берётся
+// из импортированных строк базы. При нескольких рынках день отсутствует, если его нет хотя бы в одном.
+func (e *Engine) MissingDates(spec Spec) ([]time.Time, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	start := spec.Start.UTC().Truncate(24 * time.Hour)
+	end := spec.End.UTC().Truncate(24 * time.Hour)
+
+	missing := make(map[string]time.Time)
+	for _, marketCode := range cmdutils.MarketCodes(spec.Type, spec.Market) {
+		archiveDir := filepath.Join(e.opts.DatafilesPath, spec.Type, marketCode, spec.Pair)
+		dbPath := filepath.Join(e.opts.DatabasePath, spec.Type, marketCode, spec.Pair+".db")
+		table := spec.Type
+		switch spec.Type {
+		case "depth":
+			archiveDir = filepath.Join(e.opts.DatafilesPath, "depth", spec.Pair, marketCode)
+			dbPath = filepath.Join(e.opts.DatabasePath, "depth", spec.Pair+".db")
+			table = marketCode
+		case "trades":
+			table = "trades"
+		}
+
+		archives := localArchiveDays(archiveDir, spec.Type, start, end)
+		imported, err := export.ImportedDays(dbPath, table, start, end.AddDate(0, 0, 1))
+		if err != nil {
+			return nil, err
+		}
+		for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+			key := d.Format("20060102")
+			if day, ok := archives[key]; ok {
+				if !day.complete {
+					missing[key] = d
+				}
+				continue
+			}
+			if !imported[key] {
+				missing[key] = d
+			}
+		}
+	}
+
+	dates := make([]time.Time, 0, len(missing))
+	for _, d := range missing {
+		dates = append(dates, d)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates, nil
+}
+
+// localArchiveDays группирует непустые архивы каталога по дням (YYYYMMDD). Пустые файлы —
+// заглушки отсутствующих на сервере архивов depth — данными не считаются.
+func localArchiveDays(dir, dataType string, start, end time.Time) map[string]*archiveDay {
+	days := make(map[string]*archiveDay)
+	if _, err := os.Stat(dir); err != nil {
+		return days // Архивов рынка нет совсем
+	}
+	for _, path := range collectArchives(dir, start, end) {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() == 0 {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".zip")
+		dateStr, partStr, _ := strings.Cut(name, "_")
+		day, ok := days[dateStr]
+		if !ok {
+			day = &archiveDay{}
+			days[dateStr] = day
+		}
+		if dataType != "trades" {
+			day.complete = true
+			continue
+		}
+		if part, err := strconv.Atoi(partStr); err == nil {
+			day.parts = append(day.parts, part)
+		}
+	}
+	if dataType == "trades" {
+		for _, day := range days {
+			sort.Ints(day.parts)
+			day.complete = len(day.parts) > 0
+			for i, part := range day.parts {
+				if part != i+1 {
+					day.complete = false // Пропущена часть между найденными
+					break
+				}
+			}
+		}
+	}
+	return days
+}
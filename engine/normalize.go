@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/magf/bitget-history/internal/cmdutils"
+	"github.com/magf/bitget-history/internal/db"
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// NormalizeResult — итог нормализации timestamp одной базы.
+type NormalizeResult struct {
+	DBPath    string
+	Converted int64 // Строк переведено из миллисекунд (микросекунд) в секунды
+	Dropped   int64 // Строк удалено как повторы уже записанных в секундах
+}
+
+// NormalizeTimestamps переводит в секунды timestamp, записанные в базы пары в миллисекундах
+// или микросекундах до появления определения единиц при импорте. Каждая база правится во
+// временной копии и заменяет рабочую через MoveTempDatabase; базы, в которых нечего
+// переводить, не заменяются, поэтому повторный запуск ничего не меняет. Отсутствующие базы
+// пропускаются.
+func (e *Engine) NormalizeTimestamps(dataType, market, pair string) ([]NormalizeResult, error) {
+	if e.opts.DatabaseDriver == "postgres" && (dataType == "trades" || dataType == "depth") {
+		return nil, fmt.Errorf("timestamp normalization is not supported with the postgres database driver")
+	}
+	var results []NormalizeResult
+	for _, src := range e.maintenanceSources(dataType, market, pair) {
+		if _, err := os.Stat(src.dbPath); os.IsNotExist(err) {
+			logging.Infof("No database to normalize at %s", src.dbPath)
+			continue
+		}
+		result, err := e.normalizeDatabase(dataType, src.dbPath, src.tables)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// normalizeDatabase нормализует timestamp в копии базы dbPath в каталоге временных баз и
+// заменяет ею рабочую, если хоть одна строка изменилась.
+func (e *Engine) normalizeDatabase(dataType, dbPath string, tables []string) (NormalizeResult, error) {
+	result := NormalizeResult{DBPath: dbPath}
+	rel, err := filepath.Rel(e.opts.DatabasePath, dbPath)
+	if err != nil {
+		return result, fmt.Errorf("failed to resolve temp path for %s: %w", dbPath, err)
+	}
+	tempDbPath := filepath.Join(e.opts.TempDatabasePath, rel)
+	if err := os.MkdirAll(filepath.Dir(tempDbPath), 0755); err != nil {
+		return result, fmt.Errorf("failed to create directory for %s: %w", tempDbPath, err)
+	}
+	if err := copyDatabase(dbPath, tempDbPath); err != nil {
+		return result, err
+	}
+
+	dbInstance, err := db.NewDB(tempDbPath, dataType, e.schemaOptions())
+	if err != nil {
+		return result, fmt.Errorf("failed to open database %s: %w", tempDbPath, err)
+	}
+	result.Converted, result.Dropped, err = dbInstance.NormalizeTimestamps(tables...)
+	if closeErr := dbInstance.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempDbPath) // Рабочая база не тронута
+		return result, err
+	}
+	if result.Converted == 0 && result.Dropped == 0 {
+		os.Remove(tempDbPath)
+		logging.Infof("Timestamps in %s are already in seconds", dbPath)
+		return result, nil
+	}
+	if err := cmdutils.MoveTempDatabase(tempDbPath, dbPath, e.opts.BackupSuffix, e.moveOptions(false, false), e.opts.Debug); err != nil {
+		return result, err
+	}
+	logging.Infof("Normalized timestamps in %s: converted %d rows to seconds, dropped %d duplicates", dbPath, result.Converted, result.Dropped)
+	return result, nil
+}
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/magf/bitget-history/internal/cmdutils"
+	"github.com/magf/bitget-history/internal/db"
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// PruneResult — итог очистки одной базы.
+type PruneResult struct {
+	DBPath     string
+	Rows       int64 // Удалено строк
+	BytesFreed int64 // Насколько уменьшился файл базы
+}
+
+// PruneBefore удаляет из баз пары строки старше cutoff и сжимает базы VACUUM. Каждая база
+// очищается во временной копии и заменяет рабочую через MoveTempDatabase, поэтому сбой
+// посреди очистки не портит рабочую базу. Отсутствующие базы пропускаются.
+func (e *Engine) PruneBefore(dataType, market, pair string, cutoff time.Time) ([]PruneResult, error) {
+	if e.opts.DatabaseDriver == "postgres" && (dataType == "trades" || dataType == "depth") {
+		return nil, fmt.Errorf("pruning is not supported with the postgres database driver")
+	}
+	var results []PruneResult
+	for _, src := range e.maintenanceSources(dataType, market, pair) {
+		if _, err := os.Stat(src.dbPath); os.IsNotExist(err) {
+			logging.Infof("No database to prune at %s", src.dbPath)
+			continue
+		}
+		result, err := e.pruneDatabase(dataType, src.dbPath, src.tables, cutoff.Unix())
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// maintenanceSource — база пары и её таблицы с данными для обслуживания (очистки, нормализации).
+type maintenanceSource struct {
+	dbPath string
+	tables []string
+}
+
+// maintenanceSources возвращает базы пары типа dataType на рынках market: для depth — одну базу
+// с таблицами рынков, для остальных типов — по базе на рынок.
+func (e *Engine) maintenanceSources(dataType, market, pair string) []maintenanceSource {
+	if dataType == "depth" {
+		return []maintenanceSource{{filepath.Join(e.opts.DatabasePath, "depth", pair+".db"), cmdutils.MarketCodes("depth", market)}}
+	}
+	var sources []maintenanceSource
+	for _, marketDir := range cmdutils.MarketCodes(dataType, market) {
+		sources = append(sources, maintenanceSource{filepath.Join(e.opts.DatabasePath, dataType, marketDir, pair+".db"), []string{dataType}})
+	}
+	return sources
+}
+
+// pruneDatabase очищает копию базы dbPath в каталоге временных баз и заменяет ею рабочую.
+func (e *Engine) pruneDatabase(dataType, dbPath string, tables []string, cutoff int64) (PruneResult, error) {
+	result := PruneResult{DBPath: dbPath}
+	rel, err := filepath.Rel(e.opts.DatabasePath, dbPath)
+	if err != nil {
+		return result, fmt.Errorf("failed to resolve temp path for %s: %w", dbPath, err)
+	}
+	tempDbPath := filepath.Join(e.opts.TempDatabasePath, rel)
+	if err := os.MkdirAll(filepath.Dir(tempDbPath), 0755); err != nil {
+		return result, fmt.Errorf("failed to create directory for %s: %w", tempDbPath, err)
+	}
+	if err := copyDatabase(dbPath, tempDbPath); err != nil {
+		return result, err
+	}
+	before := fileSize(dbPath)
+
+	dbInstance, err := db.NewDB(tempDbPath, dataType, e.schemaOptions())
+	if err != nil {
+		return result, fmt.Errorf("failed to open database %s: %w", tempDbPath, err)
+	}
+	result.Rows, err = dbInstance.DeleteBefore(cutoff, tables...)
+	if err == nil {
+		err = dbInstance.Vacuum()
+	}
+	if closeErr := dbInstance.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempDbPath) // Рабочая база не тронута
+		return result, err
+	}
+	if err := cmdutils.MoveTempDatabase(tempDbPath, dbPath, e.opts.BackupSuffix, e.moveOptions(false, false), e.opts.Debug); err != nil {
+		return result, err
+	}
+	result.BytesFreed = before - fileSize(dbPath)
+	logging.Infof("Pruned %s: removed %d rows, freed %d bytes", dbPath, result.Rows, result.BytesFreed)
+	return result, nil
+}
+
+// fileSize возвращает размер файла или 0, если его нет.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/magf/bitget-history/internal/cmdutils"
+	"github.com/magf/bitget-history/internal/cmdutils/export"
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// Spec — пара, тип данных, рынок и период операции.
+type Spec struct {
+	Pair   string
+	Type   string // trades, depth, kline или funding
+	Market string // spot, futures, all или код продукта Bitget (DMCBL и т.п.)
+	Start  time.Time
+	End    time.Time
+}
+
+// Validate проверяет тип данных, рынок и период.
+func (s Spec) Validate() error {
+	switch s.Type {
+	case "trades", "depth", "kline", "funding":
+	default:
+		return fmt.Errorf("invalid type: %s (must be trades, depth, kline or funding)", s.Type)
+	}
+	if market, err := cmdutils.NormalizeMarket(s.Market); err != nil || market != s.Market {
+		return fmt.Errorf("invalid market: %s (must be spot, futures, all or an upper-case Bitget product code)", s.Market)
+	}
+	if err := cmdutils.ValidateMarket(s.Type, s.Market); err != nil {
+		return err
+	}
+	if s.Start.After(s.End) {
+		return fmt.Errorf("start date is after end date")
+	}
+	return nil
+}
+
+// Target — база и рынок для экспорта или запроса.
+type Target struct {
+	DBPath string
+	Market string // "1"/"2" для depth, код продукта ("SPBL", "UMCBL", ...) для trades
+	Trades bool
+}
+
+// Targets возвращает базы для экспорта: для trades — trades/<MARKET>/<pair>.db, иначе таблицы depth.
+// Для kline экспорт не поддерживается.
+func (e *Engine) Targets(dataType, market, pair string) []Target {
+	var targets []Target
+	if dataType == "kline" {
+		logging.Infof("Export of kline data is not supported")
+		return nil
+	}
+	if dataType == "trades" {
+		for _, marketDir := range cmdutils.MarketCodes(dataType, market) {
+			dbPath := filepath.Join(e.opts.DatabasePath, "trades", marketDir, pair+".db")
+			targets = append(targets, Target{DBPath: dbPath, Market: marketDir, Trades: true})
+		}
+		return targets
+	}
+	for _, marketCode := range cmdutils.MarketCodes("depth", market) {
+		dbPath := filepath.Join(e.opts.DatabasePath, "depth", pair+".db")
+		targets = append(targets, Target{DBPath: dbPath, Market: marketCode})
+	}
+	return targets
+}
+
+// LastImported возвращает день последней записи в базах пары, с которого стоит продолжать загрузку.
+// При нескольких базах (рынок all) берётся самая ранняя из последних дат, чтобы ни один рынок
+// не пропустил дни. ok=false, если хотя бы одной базы нет или она пуста.
+func (e *Engine) LastImported(dataType, market, pair string) (time.Time, bool, error) {
+	type source struct{ dbPath, table string }
+	var sources []source
+	if dataType == "kline" || dataType == "funding" {
+		for _, marketDir := range cmdutils.MarketCodes(dataType, market) {
+			sources = append(sources, source{filepath.Join(e.opts.DatabasePath, dataType, marketDir, pair+".db"), dataType})
+		}
+	} else {
+		for _, target := range e.Targets(dataType, market, pair) {
+			table := target.Market
+			if target.Trades {
+				table = "trades"
+			}
+			sources = append(sources, source{target.DBPath, table})
+		}
+	}
+
+	var start time.Time
+	for i, src := range sources {
+		last, ok, err := export.LastTimestamp(src.dbPath, src.table)
+		if err != nil || !ok {
+			return time.Time{}, false, err
+		}
+		// День последней записи загружаем заново: он мог быть импортирован не полностью
+		day := time.Unix(last, 0).UTC().Truncate(24 * time.Hour)
+		if i == 0 || day.Before(start) {
+			start = day
+		}
+	}
+	return start, len(sources) > 0, nil
+}
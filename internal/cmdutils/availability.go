@@ -0,0 +1,112 @@
+package cmdutils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magf/bitget-history/internal/downloader"
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// Availability — результат живой проверки одного файла на сервере.
+type Availability struct {
+	Date          string
+	MarketCode    string
+	Part          int // Номер части для trades, 0 для depth
+	URL           string
+	StatusCode    int
+	ContentLength int64
+	Err           error
+}
+
+// CheckAvailability выполняет живые HEAD-запросы по всем датам диапазона, ничего не записывая
+// на диск и не обращаясь к кэшу checked_urls. Для trades части перебираются по порядку
+// до первого ответа, отличного от 200; этот ответ тоже попадает в отчёт.
+func CheckAvailability(ctx context.Context, dl *downloader.Downloader, market, pair, dataType string, startDate, endDate time.Time, debug bool) []Availability {
+	var results []Availability
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 10) // Не более 10 дат одновременно
+
+	baseURL := strings.TrimSuffix(dl.BaseURL, "/")
+	for _, marketCode := range MarketCodes(dataType, market) {
+		for d := startDate; !d.After(endDate); d = d.AddDate(0, 0, 1) {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(marketCode string, d time.Time) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				dateStr := d.Format("20060102")
+				var dateResults []Availability
+				if dataType == "trades" {
+					for part := 1; part <= 999; part++ {
+						url := fmt.Sprintf("%s/trades/%s/%s/%s_%03d.zip", baseURL, marketCode, pair, dateStr, part)
+						res := headAvailability(ctx, dl, url, debug)
+						res.Date, res.MarketCode, res.Part = d.Format("2006-01-02"), marketCode, part
+						dateResults = append(dateResults, res)
+						if res.Err != nil || res.StatusCode != 200 {
+							break
+						}
+					}
+				} else if dataType == "kline" || dataType == "funding" {
+					url := fmt.Sprintf("%s/%s/%s/%s/%s.zip", baseURL, dataType, marketCode, pair, dateStr)
+					res := headAvailability(ctx, dl, url, debug)
+					res.Date, res.MarketCode = d.Format("2006-01-02"), marketCode
+					dateResults = append(dateResults, res)
+				} else {
+					url := fmt.Sprintf("%s/depth/%s/%s/%s.zip", baseURL, pair, marketCode, dateStr)
+					res := headAvailability(ctx, dl, url, debug)
+					res.Date, res.MarketCode = d.Format("2006-01-02"), marketCode
+					dateResults = append(dateResults, res)
+				}
+
+				mu.Lock()
+				results = append(results, dateResults...)
+				mu.Unlock()
+			}(marketCode, d)
+		}
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].MarketCode != results[j].MarketCode {
+			return results[i].MarketCode < results[j].MarketCode
+		}
+		if results[i].Date != results[j].Date {
+			return results[i].Date < results[j].Date
+		}
+		return results[i].Part < results[j].Part
+	})
+	return results
+}
+
+// headAvailability выполняет один живой HEAD-запрос.
+func headAvailability(ctx context.Context, dl *downloader.Downloader, url string, debug bool) Availability {
+	statusCode, contentLength, err := dl.HeadFile(ctx, url, debug)
+	if err != nil && debug {
+		logging.Errorf("Error checking %s: %v", url, err)
+	}
+	return Availability{URL: url, StatusCode: statusCode, ContentLength: contentLength, Err: err}
+}
+
+// PrintAvailability выводит отчёт о доступности: дата, рынок, часть, HTTP-статус, размер.
+func PrintAvailability(w io.Writer, results []Availability) {
+	fmt.Fprintf(w, "%-10s  %-6s  %-4s  %-6s  %12s  %s\n", "DATE", "MARKET", "PART", "STATUS", "SIZE", "URL")
+	for _, r := range results {
+		part := "-"
+		if r.Part > 0 {
+			part = fmt.Sprintf("%03d", r.Part)
+		}
+		status := fmt.Sprintf("%d", r.StatusCode)
+		if r.Err != nil {
+			status = "error"
+		}
+		fmt.Fprintf(w, "%-10s  %-6s  %-4s  %-6s  %12d  %s\n", r.Date, r.MarketCode, part, status, r.ContentLength, r.URL)
+	}
+}
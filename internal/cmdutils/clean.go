@@ -0,0 +1,154 @@
+package cmdutils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CleanOptions — каталоги и правила хранения копий для --clean.
+type CleanOptions struct {
+	DatabasePath     string   // Каталог рабочих баз: из него удаляются только лишние резервные копии
+	TempDatabasePath string   // Каталог временных баз: удаляется всё содержимое
+	TmpRawDirs       []string // Каталоги распаковки архивов: удаляется всё содержимое
+	BackupSuffix     string   // Расширение резервной копии базы (bak_suffix)
+	BackupKeep       int      // Сколько копий хранить; 0 или 1 — одна копия без номера
+}
+
+// CleanItem — файл или каталог, который удалит --clean.
+type CleanItem struct {
+	Path  string
+	Bytes int64 // Для каталога — суммарный размер файлов
+	Why   string
+}
+
+// PlanClean собирает то, что можно удалить: временные базы, остатки распаковки архивов и резервные
+// копии баз сверх BackupKeep. Рабочие базы (*.db и их -wal/-shm) в список не попадают никогда;
+// каталог временных файлов, совпадающий с каталогом баз или содержащий его, пропускается.
+func PlanClean(opts CleanOptions) ([]CleanItem, error) {
+	var items []CleanItem
+	seen := make(map[string]bool)
+	roots := append([]string{opts.TempDatabasePath}, opts.TmpRawDirs...)
+	for i, root := range roots {
+		if root == "" || seen[root] {
+			continue
+		}
+		seen[root] = true
+		if overlaps(root, opts.DatabasePath) {
+			return nil, fmt.Errorf("refusing to clean %s: it overlaps the database directory %s", root, opts.DatabasePath)
+		}
+		why := "temporary database"
+		if i > 0 {
+			why = "extracted archive data"
+		}
+		entries, err := os.ReadDir(root)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", root, err)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(root, entry.Name())
+			items = append(items, CleanItem{Path: path, Bytes: treeSize(path), Why: why})
+		}
+	}
+
+	backups, err := staleBackups(opts.DatabasePath, opts.BackupSuffix, opts.BackupKeep)
+	if err != nil {
+		return nil, err
+	}
+	return append(items, backups...), nil
+}
+
+// staleBackups находит резервные копии баз, которые не сохранила бы ротация: при keep больше 1 —
+// копии <db><suffix>.N с N > keep, иначе — все нумерованные копии. Пустой suffix не позволяет
+// отличить копию от базы, поэтому копии тогда не ищутся.
+func staleBackups(dbRoot, suffix string, keep int) ([]CleanItem, error) {
+	if dbRoot == "" || suffix == "" {
+		return nil, nil
+	}
+	var items []CleanItem
+	err := filepath.Walk(dbRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dbRoot {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		base, num, ok := strings.Cut(info.Name(), suffix+".")
+		if !ok || !strings.HasSuffix(base, ".db") {
+			return nil
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 1 {
+			return nil
+		}
+		if keep > 1 && n <= keep {
+			return nil
+		}
+		items = append(items, CleanItem{Path: path, Bytes: info.Size(), Why: fmt.Sprintf("backup beyond backup_keep=%d", keep)})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s for backups: %w", dbRoot, err)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+	return items, nil
+}
+
+// overlaps сообщает, что каталог dir совпадает с dbRoot или содержит его.
+func overlaps(dir, dbRoot string) bool {
+	if dbRoot == "" {
+		return false
+	}
+	a, errA := filepath.Abs(dir)
+	b, errB := filepath.Abs(dbRoot)
+	if errA != nil || errB != nil {
+		return true // Не можем проверить — считаем опасным
+	}
+	rel, err := filepath.Rel(a, b)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// treeSize возвращает размер файла или суммарный размер файлов каталога.
+func treeSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// PrintCleanPlan выводит список удаляемого и итог.
+func PrintCleanPlan(w io.Writer, items []CleanItem) {
+	var total int64
+	for _, item := range items {
+		fmt.Fprintf(w, "%14d  %s  (%s)\n", item.Bytes, item.Path, item.Why)
+		total += item.Bytes
+	}
+	fmt.Fprintf(w, "%14d  TOTAL in %d items\n", total, len(items))
+}
+
+// RemoveCleanItems удаляет файлы и каталоги из плана и возвращает освобождённые байты.
+// Ошибки удаления собираются и не прерывают остальное.
+func RemoveCleanItems(items []CleanItem) (reclaimed int64, failed []string) {
+	for _, item := range items {
+		if err := os.RemoveAll(item.Path); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", item.Path, err))
+			continue
+		}
+		reclaimed += item.Bytes
+	}
+	return reclaimed, failed
+}
@@ -0,0 +1,27 @@
+package cmdutils
+
+import (
+	"fmt"
+	"time"
+)
+
+// dateLayouts — форматы --start/--end по умолчанию: YYYY-MM-DD и YYYYMMDD, как в именах архивов.
+var dateLayouts = []string{"2006-01-02", "20060102"}
+
+// ParseDate разбирает дату --start/--end в UTC. Пустой layout — YYYY-MM-DD или YYYYMMDD,
+// иначе только layout в нотации Go (например, 02.01.2006 для --date-format).
+func ParseDate(value, layout string) (time.Time, error) {
+	if layout != "" {
+		date, err := time.Parse(layout, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q does not match --date-format %q", value, layout)
+		}
+		return date, nil
+	}
+	for _, l := range dateLayouts {
+		if date, err := time.Parse(l, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date in YYYY-MM-DD or YYYYMMDD format", value)
+}
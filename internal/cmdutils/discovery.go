@@ -0,0 +1,175 @@
+package cmdutils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magf/bitget-history/internal/downloader"
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// DiscoveredPair — пара, найденная в листинге каталога на сервере или проверенная по архиву за дату.
+type DiscoveredPair struct {
+	Pair       string
+	MarketCode string // Пусто для листинга depth: каталог пары общий для всех рынков
+	Source     string // listing или probe
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+// listingLimit — сколько байт листинга каталога читается.
+const listingLimit = 4 << 20
+
+// listingEntry находит ссылки (HTML-листинг) и префиксы (XML-листинг S3) в листинге каталога.
+var listingEntry = regexp.MustCompile(`(?i)href="([^"]+)"|<Prefix>([^<]+)</Prefix>`)
+
+// DiscoverPairs перечисляет пары, для которых на сервере есть данные dataType. Сначала читается
+// листинг каталога (trades|kline|funding/<MARKET>/ или depth/); если сервер его не отдаёт,
+// проверяются пары candidates: HEAD-запрос архива за date (для trades — части 001).
+func DiscoverPairs(ctx context.Context, dl *downloader.Downloader, dataType, market string, candidates []string, date time.Time, debug bool) []DiscoveredPair {
+	baseURL := strings.TrimSuffix(dl.BaseURL, "/")
+	marketCodes := MarketCodes(dataType, market)
+	var results []DiscoveredPair
+
+	if dataType == "depth" {
+		// Каталоги пар depth лежат выше каталогов рынков
+		listingURL := baseURL + "/depth/"
+		pairs, err := listPairs(ctx, dl, listingURL)
+		if err != nil {
+			logging.Infof("No directory listing at %s (%v), probing %d candidate pairs", listingURL, err, len(candidates))
+			return probePairs(ctx, dl, dataType, marketCodes, candidates, date, debug)
+		}
+		for _, pair := range pairs {
+			results = append(results, DiscoveredPair{Pair: pair, Source: "listing", URL: listingURL + pair + "/", StatusCode: 200})
+		}
+		return results
+	}
+
+	for _, marketCode := range marketCodes {
+		listingURL := fmt.Sprintf("%s/%s/%s/", baseURL, dataType, marketCode)
+		pairs, err := listPairs(ctx, dl, listingURL)
+		if err != nil {
+			logging.Infof("No directory listing at %s (%v), probing %d candidate pairs", listingURL, err, len(candidates))
+			results = append(results, probePairs(ctx, dl, dataType, []string{marketCode}, candidates, date, debug)...)
+			continue
+		}
+		for _, pair := range pairs {
+			results = append(results, DiscoveredPair{Pair: pair, MarketCode: marketCode, Source: "listing", URL: listingURL + pair + "/", StatusCode: 200})
+		}
+	}
+	return results
+}
+
+// listPairs читает листинг каталога и возвращает имена подкаталогов, похожие на пары.
+func listPairs(ctx context.Context, dl *downloader.Downloader, listingURL string) ([]string, error) {
+	status, body, err := dl.FetchPage(ctx, listingURL, listingLimit)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("status %d", status)
+	}
+	seen := make(map[string]bool)
+	var pairs []string
+	for _, m := range listingEntry.FindAllStringSubmatch(string(body), -1) {
+		entry := m[1] + m[2]
+		if !strings.HasSuffix(entry, "/") {
+			continue // Файлы и ссылки без завершающей косой черты — не каталоги
+		}
+		name := path.Base(strings.TrimSuffix(entry, "/"))
+		if isPairName(name) && !seen[name] {
+			seen[name] = true
+			pairs = append(pairs, name)
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no pair directories in listing")
+	}
+	sort.Strings(pairs)
+	return pairs, nil
+}
+
+// isPairName сообщает, похоже ли имя каталога на пару: заглавные латинские буквы и цифры.
+func isPairName(name string) bool {
+	if len(name) < 5 {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// probePairs проверяет HEAD-запросами архивы пар candidates за date на рынках marketCodes.
+func probePairs(ctx context.Context, dl *downloader.Downloader, dataType string, marketCodes, candidates []string, date time.Time, debug bool) []DiscoveredPair {
+	var results []DiscoveredPair
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 10) // Не более 10 пар одновременно
+
+	baseURL := strings.TrimSuffix(dl.BaseURL, "/")
+	dateStr := date.Format("20060102")
+	for _, marketCode := range marketCodes {
+		for _, pair := range candidates {
+			var url string
+			switch dataType {
+			case "trades":
+				url = fmt.Sprintf("%s/trades/%s/%s/%s_001.zip", baseURL, marketCode, pair, dateStr)
+			case "depth":
+				url = fmt.Sprintf("%s/depth/%s/%s/%s.zip", baseURL, pair, marketCode, dateStr)
+			default:
+				url = fmt.Sprintf("%s/%s/%s/%s/%s.zip", baseURL, dataType, marketCode, pair, dateStr)
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(pair, marketCode, url string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				res := headAvailability(ctx, dl, url, debug)
+				mu.Lock()
+				results = append(results, DiscoveredPair{Pair: pair, MarketCode: marketCode, Source: "probe", URL: url, StatusCode: res.StatusCode, Err: res.Err})
+				mu.Unlock()
+			}(pair, marketCode, url)
+		}
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Pair != results[j].Pair {
+			return results[i].Pair < results[j].Pair
+		}
+		return results[i].MarketCode < results[j].MarketCode
+	})
+	return results
+}
+
+// PrintPairDiscovery выводит найденные пары: пара, рынок, источник, HTTP-статус и URL.
+// Пары со статусом 200 можно собрать в файл для --pairs-file.
+func PrintPairDiscovery(w io.Writer, results []DiscoveredPair) {
+	fmt.Fprintf(w, "%-16s  %-6s  %-7s  %-6s  %s\n", "PAIR", "MARKET", "SOURCE", "STATUS", "URL")
+	available := 0
+	for _, r := range results {
+		market := r.MarketCode
+		if market == "" {
+			market = "-"
+		}
+		status := fmt.Sprintf("%d", r.StatusCode)
+		if r.Err != nil {
+			status = "error"
+		} else if r.StatusCode == 200 {
+			available++
+		}
+		fmt.Fprintf(w, "%-16s  %-6s  %-7s  %-6s  %s\n", r.Pair, market, r.Source, status, r.URL)
+	}
+	fmt.Fprintf(w, "%d of %d available\n", available, len(results))
+}
@@ -0,0 +1,87 @@
+package cmdutils
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/magf/bitget-history/internal/downloader"
+)
+
+// MarketPlan — сводка --dry-run по одному рынку.
+type MarketPlan struct {
+	MarketCode string
+	Files      int
+	Bytes      int64
+	FirstDate  string // YYYYMMDD
+	LastDate   string // YYYYMMDD
+	Present    int    // Файлов, уже лежащих на диске (--skip-exists); в Files и даты не входят
+}
+
+// PlanDownloads группирует URL-ы по рынкам и считает файлы, байты и покрытый диапазон дат.
+// Ожидаются пути trades/<MARKET>/<PAIR>/<YYYYMMDD>_NNN.zip, kline|funding/<MARKET>/<PAIR>/<YYYYMMDD>.zip
+// и depth/<PAIR>/<CODE>/<YYYYMMDD>.zip. Файлы, уже лежащие на диске, считаются отдельно в Present.
+func PlanDownloads(baseURL string, urls []downloader.FileInfo) []MarketPlan {
+	plans := make(map[string]*MarketPlan)
+	for _, file := range urls {
+		parts := strings.Split(strings.TrimPrefix(file.URL, strings.TrimSuffix(baseURL, "/")+"/"), "/")
+		if len(parts) != 4 {
+			continue
+		}
+		marketCode := parts[2]
+		if parts[0] != "depth" {
+			marketCode = parts[1]
+		}
+		date := strings.Split(strings.TrimSuffix(parts[3], ".zip"), "_")[0]
+
+		plan, ok := plans[marketCode]
+		if !ok {
+			plan = &MarketPlan{MarketCode: marketCode}
+			plans[marketCode] = plan
+		}
+		if file.Exists {
+			plan.Present++
+			continue
+		}
+		plan.Files++
+		plan.Bytes += file.ContentLength
+		if plan.FirstDate == "" || date < plan.FirstDate {
+			plan.FirstDate = date
+		}
+		if date > plan.LastDate {
+			plan.LastDate = date
+		}
+	}
+
+	result := make([]MarketPlan, 0, len(plans))
+	for _, plan := range plans {
+		result = append(result, *plan)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].MarketCode < result[j].MarketCode })
+	return result
+}
+
+// PrintPlan выводит сводку --dry-run: число файлов, суммарный Content-Length и диапазон дат по рынкам,
+// а также число файлов, которые уже есть на диске.
+func PrintPlan(w io.Writer, pair string, plans []MarketPlan) {
+	fmt.Fprintf(w, "Dry run for %s:\n", pair)
+	fmt.Fprintf(w, "%-6s  %6s  %14s  %-10s  %-10s  %7s\n", "MARKET", "FILES", "BYTES", "FROM", "TO", "PRESENT")
+	var files, present int
+	var bytes int64
+	for _, p := range plans {
+		fmt.Fprintf(w, "%-6s  %6d  %14d  %-10s  %-10s  %7d\n", p.MarketCode, p.Files, p.Bytes, formatPlanDate(p.FirstDate), formatPlanDate(p.LastDate), p.Present)
+		files += p.Files
+		bytes += p.Bytes
+		present += p.Present
+	}
+	fmt.Fprintf(w, "%-6s  %6d  %14d  %-10s  %-10s  %7d\n", "TOTAL", files, bytes, "", "", present)
+}
+
+// formatPlanDate переводит YYYYMMDD в YYYY-MM-DD.
+func formatPlanDate(date string) string {
+	if len(date) != 8 {
+		return date
+	}
+	return date[:4] + "-" + date[4:6] + "-" + date[6:]
+}
@@ -0,0 +1,26 @@
+package cmdutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/magf/bitget-history/internal/downloader"
+)
+
+func TestPlanDownloadsSkipsPresentFiles(t *testing.T) {
+	base := "https://img.bitgetimg.com/online"
+	plans := PlanDownloads(base, []downloader.FileInfo{
+		{URL: base + "/trades/SPBL/BTCUSDT/20250101_001.zip", Exists: true},
+		{URL: base + "/trades/SPBL/BTCUSDT/20250102_001.zip", ContentLength: 100},
+		{URL: base + "/trades/SPBL/BTCUSDT/20250103_001.zip", ContentLength: 50},
+		{URL: base + "/trades/SPBL/BTCUSDT/20250104_001.zip", Exists: true},
+		{URL: base + "/depth/BTCUSDT/1/20250101.zip", Exists: true},
+	})
+	want := []MarketPlan{
+		{MarketCode: "1", Present: 1},
+		{MarketCode: "SPBL", Files: 2, Bytes: 150, FirstDate: "20250102", LastDate: "20250103", Present: 2},
+	}
+	if !reflect.DeepEqual(plans, want) {
+		t.Errorf("PlanDownloads = %+v, want %+v", plans, want)
+	}
+}
@@ -0,0 +1,40 @@
+package export
+
+// Candle — OHLCV-свеча для внешних потребителей (например, HTTP API).
+type Candle struct {
+	Time   int64   `json:"time"` // Начало свечи, Unix-секунды
+	Open   float64 `json:"open"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Close  float64 `json:"close"`
+	Volume float64 `json:"volume"`
+}
+
+// Aggregator собирает свечи заданного таймфрейма из потока тиков той же логикой, что и экспорт в MT5.
+type Aggregator struct {
+	builder *candleBuilder
+}
+
+// NewAggregator создаёт агрегатор для таймфрейма из SupportedTimeframes.
+func NewAggregator(timeframe string, fillGaps bool) (*Aggregator, error) {
+	b, err := newCandleBuilder(timeframe, fillGaps)
+	if err != nil {
+		return nil, err
+	}
+	return &Aggregator{builder: b}, nil
+}
+
+// Add добавляет тик: timestamp в секундах, цена и объём.
+func (a *Aggregator) Add(timestamp int64, price, volume float64) {
+	a.builder.add(timestamp, price, volume)
+}
+
+// Candles возвращает собранные свечи по возрастанию времени.
+func (a *Aggregator) Candles() []Candle {
+	candles := a.builder.result()
+	out := make([]Candle, len(candles))
+	for i, c := range candles {
+		out[i] = Candle{Time: c.Timestamp, Open: c.Open, High: c.High, Low: c.Low, Close: c.Close, Volume: c.Volume}
+	}
+	return out
+}
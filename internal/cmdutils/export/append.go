@@ -0,0 +1,212 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// tailBytes — сколько байт с конца CSV читается, чтобы найти две последние свечи.
+const tailBytes = 64 * 1024
+
+// candleTail — конец существующего CSV свечей для дописывания (Options.Append).
+type candleTail struct {
+	last       *candle // Последняя свеча; может быть неполной и пересчитывается заново
+	prev       *candle // Предыдущая: цена закрытия для FillGaps перед пересчитанной свечой
+	offset     int64   // Начало строки последней свечи (или конец файла без свечей) — с него файл дописывается
+	symbolCols bool    // В файле есть колонки Symbol и Market
+	sideVolume bool    // В файле есть колонки BuyVolume и SellVolume
+}
+
+// readCandleTail читает заголовок и две последние свечи CSV в формате MT5. Для отсутствующего
+// файла возвращает nil без ошибки.
+func readCandleTail(path string) (*candleTail, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV %s: %v", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat CSV %s: %v", path, err)
+	}
+
+	header, err := csv.NewReader(f).Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header from %s: %v", path, err)
+	}
+	tail := &candleTail{offset: info.Size(), symbolCols: len(header) > 0 && header[0] == "Symbol"}
+	for _, name := range header {
+		if name == "BuyVolume" {
+			tail.sideVolume = true
+		}
+	}
+
+	start := info.Size() - tailBytes
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	// Идём по строкам с конца; заголовок и пустые строки пропускаем
+	end := len(buf)
+	for end > 0 && tail.prev == nil {
+		lineStart := bytes.LastIndexByte(buf[:end-1], '\n') + 1
+		if lineStart == 0 && start > 0 {
+			break // Строка обрезана началом окна
+		}
+		line := strings.TrimSpace(string(buf[lineStart:end]))
+		if line != "" && !(start == 0 && lineStart == 0) { // Первая строка файла — заголовок
+			c, err := parseCandleRecord(strings.Split(line, ","), tail.symbolCols)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse candle in %s: %v", path, err)
+			}
+			if tail.last == nil {
+				tail.last = &c
+				tail.offset = start + int64(lineStart)
+			} else {
+				tail.prev = &c
+			}
+		}
+		end = lineStart
+	}
+	return tail, nil
+}
+
+// parseCandleRecord разбирает строку CSV свечей: Date, Time, Open, High, Low, Close, Volume,
+// при symbolCols — после колонок Symbol и Market. Колонки BuyVolume и SellVolume не читаются:
+// последняя свеча пересчитывается, а от предыдущей нужна только цена закрытия.
+func parseCandleRecord(row []string, symbolCols bool) (candle, error) {
+	if symbolCols {
+		if len(row) < 2 {
+			return candle{}, fmt.Errorf("short row %q", strings.Join(row, ","))
+		}
+		row = row[2:]
+	}
+	if len(row) < 7 {
+		return candle{}, fmt.Errorf("short row %q", strings.Join(row, ","))
+	}
+	ts, err := time.ParseInLocation("2006.01.02 15:04:05", row[0]+" "+row[1], time.Local) // newCandle пишет местное время
+	if err != nil {
+		return candle{}, fmt.Errorf("invalid candle time %s %s", row[0], row[1])
+	}
+	c := candle{Date: row[0], Time: row[1], Timestamp: ts.Unix()}
+	for i, v := range []*float64{&c.Open, &c.High, &c.Low, &c.Close, &c.Volume} {
+		if *v, err = strconv.ParseFloat(row[2+i], 64); err != nil {
+			return candle{}, fmt.Errorf("invalid value %s", row[2+i])
+		}
+	}
+	return c, nil
+}
+
+// resume готовит сборщики к дописыванию файлов, пути которых возвращает outputPath: читает
+// последние свечи, сеет сборщики предыдущей свечой и отбрасывает тики до начала последней.
+// Возвращает время, с которого нужно читать тики: самое раннее начало последней свечи или
+// defaultStart, если хоть одного файла ещё нет.
+func (bs *candleBuilders) resume(outputPath func(timeframe string) string, defaultStart time.Time) (time.Time, error) {
+	bs.tails = make([]*candleTail, len(bs.builders))
+	bs.from = make([]int64, len(bs.builders))
+	var from time.Time
+	for i, b := range bs.builders {
+		path := outputPath(bs.timeframes[i])
+		tail, err := readCandleTail(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		bs.tails[i] = tail
+		start := defaultStart
+		if tail != nil && tail.last != nil {
+			start = time.Unix(tail.last.Timestamp, 0)
+			if tail.prev != nil {
+				b.candles = []candle{*tail.prev}
+			}
+			logging.Infof("Appending %s candles to %s from %s", bs.timeframes[i], path, start.UTC().Format("2006-01-02 15:04"))
+		}
+		bs.from[i] = start.Unix()
+		if i == 0 || start.Before(from) {
+			from = start
+		}
+	}
+	return from, nil
+}
+
+// appendTo дописывает свечи каждого таймфрейма в файл: строка последней свечи заменяется
+// пересчитанной, новые добавляются следом. Файлы, которых ещё нет, создаются целиком.
+func (bs *candleBuilders) appendTo(outputPath func(timeframe string) string, symbolCols []string) ([]string, error) {
+	var outputFiles []string
+	for i, b := range bs.builders {
+		outputFile := outputPath(bs.timeframes[i])
+		candles := b.result()
+		tail := bs.tails[i]
+		if tail == nil {
+			if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+				return outputFiles, fmt.Errorf("failed to create directory for %s: %v", outputFile, err)
+			}
+			if err := writeCandlesCSV(outputFile, candles, symbolCols, bs.sideVolume); err != nil {
+				return outputFiles, err
+			}
+			logging.Infof("Wrote %d %s candles to %s", len(candles), bs.timeframes[i], outputFile)
+			outputFiles = append(outputFiles, outputFile)
+			continue
+		}
+		if tail.symbolCols != (symbolCols != nil) {
+			return outputFiles, fmt.Errorf("cannot append to %s: its Symbol/Market columns do not match --with-symbol", outputFile)
+		}
+		if tail.sideVolume != bs.sideVolume {
+			return outputFiles, fmt.Errorf("cannot append to %s: its BuyVolume/SellVolume columns do not match --side-volume", outputFile)
+		}
+		if tail.prev != nil && len(candles) > 0 && candles[0].Timestamp == tail.prev.Timestamp {
+			candles = candles[1:] // Затравка для FillGaps уже есть в файле
+		}
+		if len(candles) == 0 {
+			logging.Infof("No new %s candles for %s", bs.timeframes[i], outputFile)
+			continue
+		}
+		if err := appendCandlesCSV(outputFile, tail.offset, candles, symbolCols, bs.sideVolume); err != nil {
+			return outputFiles, err
+		}
+		logging.Infof("Appended %d %s candles to %s", len(candles), bs.timeframes[i], outputFile)
+		outputFiles = append(outputFiles, outputFile)
+	}
+	return outputFiles, nil
+}
+
+// appendCandlesCSV обрезает файл по offset и дописывает свечи.
+func appendCandlesCSV(csvPath string, offset int64, candles []candle, symbolCols []string, sideVolume bool) error {
+	f, err := os.OpenFile(csvPath, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV %s: %v", csvPath, err)
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate %s: %v", csvPath, err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in %s: %v", csvPath, err)
+	}
+	writer := csv.NewWriter(f)
+	for _, c := range candles {
+		if err := writer.Write(candleRecord(c, symbolCols, sideVolume)); err != nil {
+			return fmt.Errorf("failed to append candle %s %s to %s: %v", c.Date, c.Time, csvPath, err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to append candles to %s: %v", csvPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// candleBuilder собирает OHLCV-свечи из потока тиков, упорядоченного по времени.
+// Open — цена первого тика свечи, Close — последнего.
+type candleBuilder struct {
+	duration time.Duration
+	fillGaps bool
+	candles  []candle
+}
+
+// newCandleBuilder создаёт сборщик свечей для таймфрейма.
+// При fillGaps пустые интервалы между свечами заполняются свечами по цене предыдущего закрытия с нулевым объёмом.
+func newCandleBuilder(timeframe string, fillGaps bool) (*candleBuilder, error) {
+	duration, err := timeframeDuration(timeframe)
+	if err != nil {
+		return nil, err
+	}
+	return &candleBuilder{duration: duration, fillGaps: fillGaps}, nil
+}
+
+// add добавляет тик с ценой price и объёмом volume.
+func (b *candleBuilder) add(timestamp int64, price, volume float64) {
+	start := time.Unix(timestamp, 0).Truncate(b.duration)
+	startUnix := start.Unix()
+
+	n := len(b.candles)
+	if n > 0 {
+		last := &b.candles[n-1]
+		if last.Timestamp == startUnix {
+			last.High = max(last.High, price)
+			last.Low = min(last.Low, price)
+			last.Close = price
+			last.Volume += volume
+			return
+		}
+		if startUnix < last.Timestamp {
+			// Тик из уже закрытой свечи: учитываем экстремумы и объём, не трогая Open/Close
+			i := sort.Search(n, func(i int) bool { return b.candles[i].Timestamp >= startUnix })
+			if i < n && b.candles[i].Timestamp == startUnix {
+				c := &b.candles[i]
+				c.High = max(c.High, price)
+				c.Low = min(c.Low, price)
+				c.Volume += volume
+				return
+			}
+			b.candles = append(b.candles[:i], append([]candle{newCandle(start, price, volume)}, b.candles[i:]...)...)
+			return
+		}
+		if b.fillGaps {
+			for t := time.Unix(last.Timestamp, 0).Add(b.duration); t.Before(start); t = t.Add(b.duration) {
+				b.candles = append(b.candles, newCandle(t, b.candles[len(b.candles)-1].Close, 0))
+			}
+		}
+	}
+	b.candles = append(b.candles, newCandle(start, price, volume))
+}
+
+// addTrade добавляет сделку и учитывает её объём в BuyVolume или SellVolume свечи по стороне side.
+func (b *candleBuilder) addTrade(timestamp int64, price, volume float64, side string) {
+	b.add(timestamp, price, volume)
+	startUnix := time.Unix(timestamp, 0).Truncate(b.duration).Unix()
+	c := &b.candles[len(b.candles)-1]
+	if c.Timestamp != startUnix {
+		// Сделка из уже закрытой свечи
+		i := sort.Search(len(b.candles), func(i int) bool { return b.candles[i].Timestamp >= startUnix })
+		c = &b.candles[i]
+	}
+	switch side {
+	case "buy":
+		c.BuyVolume += volume
+	case "sell":
+		c.SellVolume += volume
+	}
+}
+
+// result возвращает собранные свечи.
+func (b *candleBuilder) result() []candle {
+	return b.candles
+}
+
+// newCandle создаёт свечу с началом start и одной ценой для всех уровней.
+func newCandle(start time.Time, price, volume float64) candle {
+	return candle{
+		Date:      start.Format("2006.01.02"),
+		Time:      start.Format("15:04:00"),
+		Open:      price,
+		High:      price,
+		Low:       price,
+		Close:     price,
+		Volume:    volume,
+		Timestamp: start.Unix(),
+	}
+}
+
+// SupportedTimeframes — таймфреймы, поддерживаемые экспортом.
+var SupportedTimeframes = []string{"m1", "m5", "m15", "m30", "h1", "h4", "d1"}
+
+// ParseTimeframes разбирает список таймфреймов через запятую, проверяет их и убирает повторы.
+func ParseTimeframes(spec string) ([]string, error) {
+	var timeframes []string
+	seen := make(map[string]bool)
+	for _, tf := range strings.Split(spec, ",") {
+		tf = strings.ToLower(strings.TrimSpace(tf))
+		if tf == "" || seen[tf] {
+			continue
+		}
+		if _, err := timeframeDuration(tf); err != nil {
+			return nil, fmt.Errorf("unsupported timeframe %s (expected one of %s)", tf, strings.Join(SupportedTimeframes, ", "))
+		}
+		seen[tf] = true
+		timeframes = append(timeframes, tf)
+	}
+	if len(timeframes) == 0 {
+		return nil, fmt.Errorf("no timeframes given")
+	}
+	return timeframes, nil
+}
+
+// candleBuilders собирает свечи сразу для нескольких таймфреймов из одного потока тиков.
+type candleBuilders struct {
+	timeframes []string
+	builders   []*candleBuilder
+	sideVolume bool // Писать колонки BuyVolume и SellVolume
+
+	// Дописывание файлов (resume): тики раньше from[i] сборщик i не получает
+	from  []int64
+	tails []*candleTail
+}
+
+// newCandleBuilders создаёт сборщики для всех таймфреймов.
+func newCandleBuilders(timeframes []string, fillGaps bool) (*candleBuilders, error) {
+	if len(timeframes) == 0 {
+		return nil, fmt.Errorf("no timeframes given")
+	}
+	bs := &candleBuilders{timeframes: timeframes}
+	for _, tf := range timeframes {
+		b, err := newCandleBuilder(tf, fillGaps)
+		if err != nil {
+			return nil, err
+		}
+		bs.builders = append(bs.builders, b)
+	}
+	return bs, nil
+}
+
+// add передаёт тик во все сборщики.
+func (bs *candleBuilders) add(timestamp int64, price, volume float64) {
+	for i, b := range bs.builders {
+		if bs.from != nil && timestamp < bs.from[i] {
+			continue
+		}
+		b.add(timestamp, price, volume)
+	}
+}
+
+// addTrade передаёт сделку во все сборщики.
+func (bs *candleBuilders) addTrade(timestamp int64, price, volume float64, side string) {
+	for i, b := range bs.builders {
+		if bs.from != nil && timestamp < bs.from[i] {
+			continue
+		}
+		b.addTrade(timestamp, price, volume, side)
+	}
+}
+
+// write записывает свечи каждого таймфрейма в файл, путь к которому возвращает outputPath.
+func (bs *candleBuilders) write(outputPath func(timeframe string) string, symbolCols []string) ([]string, error) {
+	var outputFiles []string
+	for i, b := range bs.builders {
+		outputFile := outputPath(bs.timeframes[i])
+		if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+			return outputFiles, fmt.Errorf("failed to create directory for %s: %v", outputFile, err)
+		}
+		candles := b.result()
+		if err := writeCandlesCSV(outputFile, candles, symbolCols, bs.sideVolume); err != nil {
+			return outputFiles, err
+		}
+		logging.Infof("Wrote %d %s candles to %s", len(candles), bs.timeframes[i], outputFile)
+		outputFiles = append(outputFiles, outputFile)
+	}
+	return outputFiles, nil
+}
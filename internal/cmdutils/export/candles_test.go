@@ -0,0 +1,73 @@
+package export
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCandleBuilderOHLC(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	b, err := newCandleBuilder("m1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticks := []struct {
+		ts            int64
+		price, volume float64
+	}{
+		{base + 5, 100, 1},
+		{base + 20, 105, 2},
+		{base + 40, 95, 1},
+		{base + 59, 101, 0.5},
+		{base + 60, 102, 3}, // Ровно на границе: открывает следующую свечу
+		{base + 90, 104, 1},
+		{base + 30, 110, 1}, // Опоздавший тик закрытой свечи: только экстремум и объём
+		{base + 245, 90, 2}, // После пропуска двух минут
+	}
+	for _, tick := range ticks {
+		b.add(tick.ts, tick.price, tick.volume)
+	}
+
+	want := []candle{
+		{Timestamp: base, Open: 100, High: 110, Low: 95, Close: 101, Volume: 5.5},
+		{Timestamp: base + 60, Open: 102, High: 104, Low: 102, Close: 104, Volume: 4},
+		{Timestamp: base + 120, Open: 104, High: 104, Low: 104, Close: 104, Volume: 0}, // FillGaps
+		{Timestamp: base + 180, Open: 104, High: 104, Low: 104, Close: 104, Volume: 0}, // FillGaps
+		{Timestamp: base + 240, Open: 90, High: 90, Low: 90, Close: 90, Volume: 2},
+	}
+	got := b.result()
+	if len(got) != len(want) {
+		t.Fatalf("got %d candles, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Timestamp != w.Timestamp || g.Open != w.Open || g.High != w.High || g.Low != w.Low || g.Close != w.Close || g.Volume != w.Volume {
+			t.Errorf("candle %d: got %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestCandleBuilderNoFillGaps(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	b, err := newCandleBuilder("m5", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.add(base+299, 10, 1)
+	b.add(base+300, 11, 1)
+	b.add(base+1200, 12, 1)
+
+	got := b.result()
+	wantStarts := []int64{base, base + 300, base + 1200}
+	if len(got) != len(wantStarts) {
+		t.Fatalf("got %d candles, want %d: %+v", len(got), len(wantStarts), got)
+	}
+	for i, start := range wantStarts {
+		if got[i].Timestamp != start {
+			t.Errorf("candle %d starts at %d, want %d", i, got[i].Timestamp, start)
+		}
+	}
+	if got[0].Close != 10 || got[1].Open != 11 {
+		t.Errorf("boundary tick went to the wrong candle: %+v", got)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
@@ -14,9 +13,85 @@ import (
 	"sync"
 	"time"
 
+	"github.com/magf/bitget-history/internal/logging"
 	_ "github.com/mattn/go-sqlite3" // Драйвер SQLite
 )
 
+// DefaultOutputDir — каталог экспорта, если он не задан в конфиге.
+const DefaultOutputDir = "/tmp/bitget-history/mt5"
+
+// Options задаёт параметры экспорта.
+type Options struct {
+	OutputDir  string // Каталог для выходных файлов (по умолчанию DefaultOutputDir)
+	JSONLines  bool   // Писать JSON построчно (NDJSON) вместо массива
+	FillGaps   bool   // Заполнять пустые интервалы свечами по цене предыдущего закрытия
+	WithSymbol bool   // Добавлять в начало строк колонки Symbol и Market
+	Append     bool   // Дописывать свечи MT5 в файлы без периода в имени вместо новых файлов за период
+	Volume     string // Единицы объёма свечей сделок: VolumeBase (по умолчанию) или VolumeQuote
+
+	SideVolume bool // Колонки BuyVolume и SellVolume в свечах сделок (в единицах Volume)
+
+	// Сверка дневного объёма сделок с эталоном (только для экспорта trades)
+	ExpectedVolumes DailyVolumes // Эталонные объёмы по дням; nil — сверка отключена
+	VolumeTolerance float64      // Допустимое относительное расхождение (по умолчанию DefaultVolumeTolerance)
+}
+
+// Единицы объёма свечей MT5 из сделок (Options.Volume). Колонка в CSV всегда называется Volume.
+const (
+	VolumeBase  = "base"  // Сумма size_base: объём в базовой валюте (BTC для BTCUSDT)
+	VolumeQuote = "quote" // Сумма volume_quote: оборот в валюте котировки (USDT для BTCUSDT)
+)
+
+// ParseVolume проверяет значение --volume; пустое — VolumeBase.
+func ParseVolume(volume string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(volume)) {
+	case "", VolumeBase:
+		return VolumeBase, nil
+	case VolumeQuote:
+		return VolumeQuote, nil
+	}
+	return "", fmt.Errorf("invalid volume %s (must be base or quote)", volume)
+}
+
+// symbolColumns возвращает значения колонок Symbol и Market или nil, если они отключены.
+func (o Options) symbolColumns(pair, market string) []string {
+	if !o.WithSymbol {
+		return nil
+	}
+	return []string{pair, marketName(market)}
+}
+
+// outputPath возвращает путь к выходному файлу в каталоге экспорта.
+func (o Options) outputPath(fileName string) string {
+	dir := o.OutputDir
+	if dir == "" {
+		dir = DefaultOutputDir
+	}
+	return filepath.Join(dir, fileName)
+}
+
+// isDepthMarket сообщает, является ли market таблицей depth ("1" или "2").
+// DepthAllMarket — псевдорынок depth для ExportToJSON: обе таблицы из представления depth_all с меткой рынка.
+const DepthAllMarket = "all"
+
+func isDepthMarket(market string) bool {
+	return market == "1" || market == "2"
+}
+
+// marketName возвращает имя рынка для имени выходного файла; для прочих кодов продуктов
+// (DMCBL и т.п.) — сам код в нижнем регистре.
+func marketName(market string) string {
+	switch market {
+	case "2", "UMCBL":
+		return "futures"
+	case DepthAllMarket:
+		return "all"
+	case "1", "SPBL", "":
+		return "spot"
+	}
+	return strings.ToLower(market)
+}
+
 // AppendTickToOHLC добавляет тиковые данные в OHLC-файл с заданным таймфреймом.
 func AppendTickToOHLC(tickData, csvPath, timeframe string, mu *sync.RWMutex) error {
 	// Парсим тиковые данные: timestamp,ask_price,bid_price,ask_volume,bid_volume
@@ -49,37 +124,15 @@ func AppendTickToOHLC(tickData, csvPath, timeframe string, mu *sync.RWMutex) err
 	tickTime := time.Unix(timestamp, 0)
 
 	// Определяем интервал свечи
-	var candleDuration time.Duration
-	switch timeframe {
-	case "m1":
-		candleDuration = time.Minute
-	case "m5":
-		candleDuration = 5 * time.Minute
-	case "m15":
-		candleDuration = 15 * time.Minute
-	case "m30":
-		candleDuration = 30 * time.Minute
-	case "h1":
-		candleDuration = time.Hour
-	case "h4":
-		candleDuration = 4 * time.Hour
-	case "d1":
-		candleDuration = 24 * time.Hour
-	default:
-		return fmt.Errorf("unsupported timeframe: %s", timeframe)
+	candleDuration, err := timeframeDuration(timeframe)
+	if err != nil {
+		return err
 	}
 
 	// Вычисляем начало свечи
 	candleStart := tickTime.Truncate(candleDuration)
 	candleKey := candleStart.Format("2006.01.02 15:04")
 
-	// Структура для свечи
-	type candle struct {
-		Date, Time                     string
-		Open, High, Low, Close, Volume float64
-		Timestamp                      int64
-	}
-
 	// Читаем существующие свечи
 	mu.Lock()
 	defer mu.Unlock()
@@ -105,7 +158,7 @@ func AppendTickToOHLC(tickData, csvPath, timeframe string, mu *sync.RWMutex) err
 				break
 			}
 			if err != nil {
-				log.Printf("Error reading %s: %v", csvPath, err)
+				logging.Errorf("Error reading %s: %v", csvPath, err)
 				continue
 			}
 			if len(row) < 7 {
@@ -131,80 +184,63 @@ func AppendTickToOHLC(tickData, csvPath, timeframe string, mu *sync.RWMutex) err
 		}
 	}
 
-	// Ищем свечу
-	candleIndex := -1
-	for i, c := range candles {
-		if c.Date+" "+strings.TrimSuffix(c.Time, ":00") == candleKey {
-			candleIndex = i
-			break
-		}
-	}
-
-	// Обновляем или создаём свечу
-	var prevClose float64
-	if candleIndex > 0 {
-		prevClose = candles[candleIndex-1].Close
-	} else if candleIndex == 0 && len(candles) > 1 {
-		// Для первой свечи ищем предыдущую
-		for _, c := range candles[1:] {
-			if c.Timestamp < candleStart.Unix() {
-				prevClose = c.Close
-			}
-		}
-	}
-
-	if candleIndex >= 0 {
-		// Обновляем свечу
-		c := &candles[candleIndex]
-		if c.Open == 0 {
-			c.Open = prevClose
-			if c.Open == 0 {
-				c.Open = midPrice
-			}
-		}
-		c.High = max(c.High, midPrice)
-		c.Low = min(c.Low, midPrice)
-		if c.Low == 0 {
-			c.Low = midPrice
-		}
-		c.Close = midPrice
-		c.Volume += volume
-	} else {
-		// Создаём новую свечу
-		openPrice := prevClose
-		if openPrice == 0 {
-			openPrice = midPrice
-		}
-		newCandle := candle{
-			Date:      candleStart.Format("2006.01.02"),
-			Time:      candleStart.Format("15:04:00"),
-			Open:      openPrice,
-			High:      midPrice,
-			Low:       midPrice,
-			Close:     midPrice,
-			Volume:    volume,
-			Timestamp: candleStart.Unix(),
-		}
-		candles = append(candles, newCandle)
-		candleIndex = len(candles) - 1
-	}
-
-	// Обновляем следующую свечу, если она есть
-	if candleIndex+1 < len(candles) {
-		nextCandle := &candles[candleIndex+1]
-		nextCandle.Open = candles[candleIndex].Close
-	}
-
-	// Сортируем свечи по времени
+	// Обновляем или создаём свечу тем же сборщиком, что и при полном экспорте:
+	// Open — цена первого тика свечи, соседние свечи не трогаем.
 	sort.Slice(candles, func(i, j int) bool {
 		return candles[i].Timestamp < candles[j].Timestamp
 	})
+	builder := &candleBuilder{duration: candleDuration, candles: candles}
+	builder.add(timestamp, midPrice, volume)
+	candles = builder.result()
 
 	// Переписываем CSV
 	if err := os.MkdirAll(filepath.Dir(csvPath), 0755); err != nil {
 		return fmt.Errorf("failed to create directory for %s: %v", csvPath, err)
 	}
-	f, err = os.Create(csvPath)
+	if err := writeCandlesCSV(csvPath, candles, nil, false); err != nil {
+		return err
+	}
+
+	logging.Infof("Appended tick to %s, candle %s", csvPath, candleKey)
+	return nil
+}
+
+// candle описывает одну OHLCV-свечу в формате MT5.
+type candle struct {
+	Date, Time                     string
+	Open, High, Low, Close, Volume float64
+	Timestamp                      int64
+
+	BuyVolume, SellVolume float64 // Объём покупок и продаж; только для свечей сделок
+}
+
+// timeframeDuration возвращает длительность свечи для таймфрейма.
+func timeframeDuration(timeframe string) (time.Duration, error) {
+	switch timeframe {
+	case "m1":
+		return time.Minute, nil
+	case "m5":
+		return 5 * time.Minute, nil
+	case "m15":
+		return 15 * time.Minute, nil
+	case "m30":
+		return 30 * time.Minute, nil
+	case "h1":
+		return time.Hour, nil
+	case "h4":
+		return 4 * time.Hour, nil
+	case "d1":
+		return 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("unsupported timeframe: %s", timeframe)
+	}
+}
+
+// writeCandlesCSV перезаписывает CSV-файл свечами в формате MT5.
+// Непустой symbolCols добавляется в начало каждой строки под заголовками Symbol и Market,
+// при sideVolume после Volume идут колонки BuyVolume и SellVolume.
+func writeCandlesCSV(csvPath string, candles []candle, symbolCols []string, sideVolume bool) error {
+	f, err := os.Create(csvPath)
 	if err != nil {
 		return fmt.Errorf("failed to create CSV %s: %v", csvPath, err)
 	}
@@ -212,27 +248,45 @@ func AppendTickToOHLC(tickData, csvPath, timeframe string, mu *sync.RWMutex) err
 	writer := csv.NewWriter(f)
 	defer writer.Flush()
 
-	if err := writer.Write([]string{"Date", "Time", "Open", "High", "Low", "Close", "Volume"}); err != nil {
+	header := []string{"Date", "Time", "Open", "High", "Low", "Close", "Volume"}
+	if symbolCols != nil {
+		header = append([]string{"Symbol", "Market"}, header...)
+	}
+	if sideVolume {
+		header = append(header, "BuyVolume", "SellVolume")
+	}
+	if err := writer.Write(header); err != nil {
 		return fmt.Errorf("failed to write header to %s: %v", csvPath, err)
 	}
 	for _, c := range candles {
-		if err := writer.Write([]string{
-			c.Date,
-			c.Time,
-			fmt.Sprintf("%.2f", c.Open),
-			fmt.Sprintf("%.2f", c.High),
-			fmt.Sprintf("%.2f", c.Low),
-			fmt.Sprintf("%.2f", c.Close),
-			fmt.Sprintf("%.6f", c.Volume),
-		}); err != nil {
-			log.Printf("Failed to write candle %s %s to %s: %v", c.Date, c.Time, csvPath, err)
+		if err := writer.Write(candleRecord(c, symbolCols, sideVolume)); err != nil {
+			logging.Errorf("Failed to write candle %s %s to %s: %v", c.Date, c.Time, csvPath, err)
 		}
 	}
-
-	log.Printf("Appended tick to %s, candle %s", csvPath, candleKey)
 	return nil
 }
 
+// candleRecord возвращает строку CSV свечи; непустой symbolCols добавляется в начало,
+// при sideVolume в конец добавляются объёмы покупок и продаж.
+func candleRecord(c candle, symbolCols []string, sideVolume bool) []string {
+	record := []string{
+		c.Date,
+		c.Time,
+		fmt.Sprintf("%.2f", c.Open),
+		fmt.Sprintf("%.2f", c.High),
+		fmt.Sprintf("%.2f", c.Low),
+		fmt.Sprintf("%.2f", c.Close),
+		fmt.Sprintf("%.6f", c.Volume),
+	}
+	if sideVolume {
+		record = append(record, fmt.Sprintf("%.6f", c.BuyVolume), fmt.Sprintf("%.6f", c.SellVolume))
+	}
+	if symbolCols != nil {
+		record = append(append([]string{}, symbolCols...), record...)
+	}
+	return record
+}
+
 // max возвращает максимум двух чисел.
 func max(a, b float64) float64 {
 	if a > b {
@@ -250,48 +304,63 @@ func min(a, b float64) float64 {
 }
 
 // ExportToMT5CSV экспортирует данные depth в CSV для MetaTrader 5.
-func ExportToMT5CSV(dbPath, pair, market, timeframe string, startDate, endDate time.Time) (string, error) {
+// Цена свечи — середина спреда, объём — сумма ask_volume + bid_volume строк стакана в базовой
+// валюте; Options.Volume на depth не влияет.
+// Все таймфреймы собираются за один проход по базе, по файлу на таймфрейм.
+func ExportToMT5CSV(dbPath, pair, market string, timeframes []string, startDate, endDate time.Time, opts Options) ([]string, error) {
 	startTotal := time.Now()
 
 	// Проверяем существование базы
 	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		log.Printf("Database %s does not exist, skipping export", dbPath)
-		return "", nil
+		logging.Infof("Database %s does not exist, skipping export", dbPath)
+		return nil, nil
 	}
 
-	// Формируем имя файла
-	startStr := startDate.Format("2006-01-02")
-	endStr := endDate.Format("2006-01-02")
-	marketName := "spot"
-	if market == "2" {
-		marketName = "futures"
+	builders, err := newCandleBuilders(timeframes, opts.FillGaps)
+	if err != nil {
+		return nil, err
 	}
-	outputFile := filepath.Join("/tmp/bitget-history/mt5", fmt.Sprintf("%s_%s_%s_%s-%s.csv", pair, marketName, timeframe, startStr, endStr))
-	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
-		return "", fmt.Errorf("failed to create directory for %s: %v", outputFile, err)
+	if opts.Volume == VolumeQuote {
+		logging.Infof("--volume quote applies to trades only; depth candle volume is ask_volume + bid_volume in base currency")
 	}
+	startStr := startDate.Format("2006-01-02")
+	endStr := endDate.Format("2006-01-02")
 
 	// Открываем базу
 	db, err := sql.Open("sqlite3", dbPath+"?mode=ro")
 	if err != nil {
-		return "", fmt.Errorf("failed to open database %s: %v", dbPath, err)
+		return nil, fmt.Errorf("failed to open database %s: %v", dbPath, err)
 	}
 	defer db.Close()
 
 	// Настраиваем SQLite
 	_, err = db.Exec("PRAGMA busy_timeout = 10000; PRAGMA cache_size = -100000; PRAGMA synchronous = OFF;")
 	if err != nil {
-		log.Printf("Failed to configure SQLite: %v", err)
+		logging.Errorf("Failed to configure SQLite: %v", err)
 	}
 
 	// Проверяем таблицу
 	var tableExists string
 	err = db.QueryRow(fmt.Sprintf(`SELECT name FROM sqlite_master WHERE type='table' AND name='%s'`, market)).Scan(&tableExists)
 	if err == sql.ErrNoRows {
-		log.Printf("Table %s does not exist, skipping", market)
-		return "", nil
+		logging.Infof("Table %s does not exist, skipping", market)
+		return nil, nil
 	} else if err != nil {
-		return "", fmt.Errorf("failed to check table %s: %v", market, err)
+		return nil, fmt.Errorf("failed to check table %s: %v", market, err)
+	}
+
+	// При дописывании читаем тики только с начала последней свечи в файлах
+	outputPath := func(timeframe string) string {
+		if opts.Append {
+			return opts.outputPath(fmt.Sprintf("%s_%s_%s.csv", pair, marketName(market), timeframe))
+		}
+		return opts.outputPath(fmt.Sprintf("%s_%s_%s_%s-%s.csv", pair, marketName(market), timeframe, startStr, endStr))
+	}
+	from := startDate
+	if opts.Append {
+		if from, err = builders.resume(outputPath, startDate); err != nil {
+			return nil, err
+		}
 	}
 
 	// Читаем тики
@@ -301,45 +370,158 @@ func ExportToMT5CSV(dbPath, pair, market, timeframe string, startDate, endDate t
 		WHERE timestamp >= ? AND timestamp <= ?
 		ORDER BY timestamp;
 	`, market)
-	rows, err := db.Query(query, startDate.Unix(), endDate.Unix())
+	rows, err := db.Query(query, from.Unix(), endDate.Unix())
 	if err != nil {
-		return "", fmt.Errorf("failed to query table %s: %v", market, err)
+		return nil, fmt.Errorf("failed to query table %s: %v", market, err)
 	}
 	defer rows.Close()
 
-	// Мьютекс для AppendTickToOHLC (хотя в однопоточном режиме он избыточен, оставляем для универсальности)
-	var mu sync.RWMutex
-
-	// Обрабатываем тики последовательно
+	// Тики отсортированы по времени, поэтому свечи собираются за один проход по всему диапазону
 	ticksProcessed := 0
-	hasData := false
 	for rows.Next() {
 		var timestamp int64
 		var askPrice, bidPrice, askVolume, bidVolume float64
 		if err := rows.Scan(&timestamp, &askPrice, &bidPrice, &askVolume, &bidVolume); err != nil {
-			log.Printf("Failed to scan row: %v", err)
+			logging.Errorf("Failed to scan row: %v", err)
 			continue
 		}
-		tickData := fmt.Sprintf("%d,%.2f,%.2f,%.6f,%.6f", timestamp, askPrice, bidPrice, askVolume, bidVolume)
-		if err := AppendTickToOHLC(tickData, outputFile, timeframe, &mu); err != nil {
-			log.Printf("Failed to append tick %d: %v", timestamp, err)
+		builders.add(timestamp, (askPrice+bidPrice)/2.0, askVolume+bidVolume)
+		ticksProcessed++
+		if ticksProcessed%100000 == 0 {
+			logging.Infof("Processed %d ticks", ticksProcessed)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	if ticksProcessed == 0 {
+		logging.Infof("No data found for table %s in %s for period %s to %s", market, dbPath, startStr, endStr)
+		return nil, nil
+	}
+
+	write := builders.write
+	if opts.Append {
+		write = builders.appendTo
+	}
+	outputFiles, err := write(outputPath, opts.symbolColumns(pair, market))
+	if err != nil {
+		return outputFiles, err
+	}
+
+	logging.Infof("Export completed to %s, processed %d ticks, total time %v", strings.Join(outputFiles, ", "), ticksProcessed, time.Since(startTotal))
+	return outputFiles, nil
+}
+
+// ExportTradesToMT5CSV экспортирует данные trades в CSV для MetaTrader 5.
+// Свечи строятся по фактическим ценам сделок, объём — сумма size_base или, при
+// Options.Volume = VolumeQuote, сумма volume_quote. При Options.SideVolume объём
+// дополнительно раскладывается по стороне сделки в колонки BuyVolume и SellVolume.
+// Все таймфреймы собираются за один проход по базе, по файлу на таймфрейм.
+func ExportTradesToMT5CSV(dbPath, pair, market string, timeframes []string, startDate, endDate time.Time, opts Options) ([]string, error) {
+	startTotal := time.Now()
+
+	// Проверяем существование базы
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		logging.Infof("Database %s does not exist, skipping export", dbPath)
+		return nil, nil
+	}
+
+	builders, err := newCandleBuilders(timeframes, opts.FillGaps)
+	if err != nil {
+		return nil, err
+	}
+	builders.sideVolume = opts.SideVolume
+	startStr := startDate.Format("2006-01-02")
+	endStr := endDate.Format("2006-01-02")
+
+	// Открываем базу
+	db, err := sql.Open("sqlite3", dbPath+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %v", dbPath, err)
+	}
+	defer db.Close()
+
+	// Настраиваем SQLite
+	_, err = db.Exec("PRAGMA busy_timeout = 10000; PRAGMA cache_size = -100000; PRAGMA synchronous = OFF;")
+	if err != nil {
+		logging.Errorf("Failed to configure SQLite: %v", err)
+	}
+
+	// При дописывании читаем сделки только с начала последней свечи в файлах
+	outputPath := func(timeframe string) string {
+		if opts.Append {
+			return opts.outputPath(fmt.Sprintf("%s_%s_trades_%s.csv", pair, marketName(market), timeframe))
+		}
+		return opts.outputPath(fmt.Sprintf("%s_%s_trades_%s_%s-%s.csv", pair, marketName(market), timeframe, startStr, endStr))
+	}
+	from := startDate
+	if opts.Append {
+		if from, err = builders.resume(outputPath, startDate); err != nil {
+			return nil, err
+		}
+	}
+
+	// Читаем сделки
+	rows, err := db.Query(`
+		SELECT timestamp, price, side, size_base, volume_quote
+		FROM trades
+		WHERE timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp;
+	`, from.Unix(), endDate.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trades in %s: %v", dbPath, err)
+	}
+	defer rows.Close()
+
+	// Сделки отсортированы по времени, поэтому свечи собираются за один проход
+	dailyVolumes := make(DailyVolumes)
+	tradesProcessed := 0
+	for rows.Next() {
+		var timestamp int64
+		var price, sizeBase, volumeQuote float64
+		var side sql.NullString
+		if err := rows.Scan(&timestamp, &price, &side, &sizeBase, &volumeQuote); err != nil {
+			logging.Errorf("Failed to scan row: %v", err)
 			continue
 		}
-		ticksProcessed++
-		hasData = true
-		if ticksProcessed%1000 == 0 {
-			log.Printf("Processed %d ticks", ticksProcessed)
+		volume := sizeBase
+		if opts.Volume == VolumeQuote {
+			volume = volumeQuote
+		}
+		builders.addTrade(timestamp, price, volume, side.String)
+		dailyVolumes.add(timestamp, sizeBase)
+		tradesProcessed++
+		if tradesProcessed%100000 == 0 {
+			logging.Infof("Processed %d trades", tradesProcessed)
 		}
 	}
 	if err := rows.Err(); err != nil {
-		return "", fmt.Errorf("error iterating rows: %v", err)
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	if opts.ExpectedVolumes != nil {
+		if opts.Append {
+			logging.Infof("Daily volume reconciliation is skipped when appending: only new trades are read")
+		} else {
+			reconcileVolumes(dailyVolumes, opts.ExpectedVolumes, startDate, endDate, opts.VolumeTolerance)
+		}
 	}
 
-	if !hasData {
-		log.Printf("No data found for table %s in %s for period %s to %s", market, dbPath, startStr, endStr)
-		return "", nil
+	if tradesProcessed == 0 {
+		logging.Infof("No trades found in %s for period %s to %s", dbPath, startStr, endStr)
+		return nil, nil
+	}
+
+	write := builders.write
+	if opts.Append {
+		write = builders.appendTo
+	}
+	outputFiles, err := write(outputPath, opts.symbolColumns(pair, market))
+	if err != nil {
+		return outputFiles, err
 	}
 
-	log.Printf("Export completed to %s, processed %d ticks, total time %v", outputFile, ticksProcessed, time.Since(startTotal))
-	return outputFile, nil
+	logging.Infof("Export completed to %s, processed %d trades, total time %v", strings.Join(outputFiles, ", "), tradesProcessed, time.Since(startTotal))
+	return outputFiles, nil
 }
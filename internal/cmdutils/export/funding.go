@@ -0,0 +1,95 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+	_ "github.com/mattn/go-sqlite3" // Драйвер SQLite
+)
+
+// ExportFundingToCSV выгружает ставки финансирования в CSV (Date, Time, Symbol, FundingRate).
+// Возвращает пустое имя файла, если базы нет или ставок за период не найдено.
+func ExportFundingToCSV(dbPath, pair string, startDate, endDate time.Time, opts Options) (string, error) {
+	// Проверяем существование базы
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		logging.Infof("Database %s does not exist, skipping export", dbPath)
+		return "", nil
+	}
+
+	db, err := sql.Open("sqlite3", dbPath+"?mode=ro")
+	if err != nil {
+		return "", fmt.Errorf("failed to open database %s: %v", dbPath, err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT timestamp, symbol, funding_rate
+		FROM funding
+		WHERE timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp, symbol;
+	`, startDate.Unix(), endDate.Unix())
+	if err != nil {
+		return "", fmt.Errorf("failed to query funding in %s: %v", dbPath, err)
+	}
+	defer rows.Close()
+
+	startStr := startDate.Format("2006-01-02")
+	endStr := endDate.Format("2006-01-02")
+	outputFile := opts.outputPath(fmt.Sprintf("%s_futures_funding_%s-%s.csv", pair, startStr, endStr))
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %v", outputFile, err)
+	}
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to create CSV %s: %v", outputFile, err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{"Date", "Time", "Symbol", "FundingRate"}); err != nil {
+		return "", fmt.Errorf("failed to write header to %s: %v", outputFile, err)
+	}
+	written := 0
+	for rows.Next() {
+		var timestamp int64
+		var symbol string
+		var rate float64
+		if err := rows.Scan(&timestamp, &symbol, &rate); err != nil {
+			logging.Errorf("Failed to scan row: %v", err)
+			continue
+		}
+		t := time.Unix(timestamp, 0).UTC()
+		record := []string{
+			t.Format("2006.01.02"),
+			t.Format("15:04:05"),
+			symbol,
+			strconv.FormatFloat(rate, 'f', -1, 64),
+		}
+		if err := writer.Write(record); err != nil {
+			return "", fmt.Errorf("failed to write row to %s: %v", outputFile, err)
+		}
+		written++
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("error iterating rows: %v", err)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", fmt.Errorf("failed to flush %s: %v", outputFile, err)
+	}
+
+	if written == 0 {
+		logging.Infof("No funding rates found in %s for period %s to %s", dbPath, startStr, endStr)
+		file.Close()
+		os.Remove(outputFile)
+		return "", nil
+	}
+	logging.Infof("Exported %d funding rates to %s", written, outputFile)
+	return outputFile, nil
+}
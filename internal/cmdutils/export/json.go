@@ -0,0 +1,178 @@
+package export
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+	_ "github.com/mattn/go-sqlite3" // Драйвер SQLite
+)
+
+// depthRow — строка таблицы depth в JSON-экспорте.
+type depthRow struct {
+	Market    string  `json:"market,omitempty"` // Только для DepthAllMarket
+	Timestamp int64   `json:"timestamp"`
+	AskPrice  float64 `json:"ask_price"`
+	BidPrice  float64 `json:"bid_price"`
+	AskVolume float64 `json:"ask_volume"`
+	BidVolume float64 `json:"bid_volume"`
+}
+
+// tradeRow — строка таблицы trades в JSON-экспорте.
+type tradeRow struct {
+	TradeID     string  `json:"trade_id"`
+	Timestamp   int64   `json:"timestamp"`
+	Price       float64 `json:"price"`
+	Side        string  `json:"side"`
+	VolumeQuote float64 `json:"volume_quote"`
+	SizeBase    float64 `json:"size_base"`
+}
+
+// ExportToJSON выгружает сырые строки depth или trades в JSON-файл.
+// Для depth market — таблица рынка ("1" или "2") или DepthAllMarket, для trades — код рынка ("SPBL" или "UMCBL").
+// При opts.JSONLines пишется newline-delimited JSON вместо массива.
+func ExportToJSON(dbPath, pair, market string, startDate, endDate time.Time, opts Options) (string, error) {
+	startTotal := time.Now()
+
+	// Проверяем существование базы
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		logging.Infof("Database %s does not exist, skipping export", dbPath)
+		return "", nil
+	}
+
+	// Формируем имя файла
+	startStr := startDate.Format("2006-01-02")
+	endStr := endDate.Format("2006-01-02")
+	dataType := "trades"
+	table := "trades"
+	compact := market == DepthAllMarket
+	if isDepthMarket(market) {
+		dataType = "depth"
+		table = market
+	} else if compact {
+		dataType = "depth"
+		table = "depth_all"
+	}
+	ext := "json"
+	if opts.JSONLines {
+		ext = "ndjson"
+	}
+	outputFile := opts.outputPath(fmt.Sprintf("%s_%s_%s_%s-%s.%s", pair, marketName(market), dataType, startStr, endStr, ext))
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %v", outputFile, err)
+	}
+
+	// Открываем базу
+	db, err := sql.Open("sqlite3", dbPath+"?mode=ro")
+	if err != nil {
+		return "", fmt.Errorf("failed to open database %s: %v", dbPath, err)
+	}
+	defer db.Close()
+
+	// Проверяем таблицу
+	var tableExists string
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type IN ('table', 'view') AND name=?`, table).Scan(&tableExists)
+	if err == sql.ErrNoRows {
+		logging.Infof("Table %s does not exist in %s, skipping", table, dbPath)
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to check table %s: %v", table, err)
+	}
+
+	var query string
+	if compact {
+		query = `
+			SELECT market, timestamp, ask_price, bid_price, ask_volume, bid_volume
+			FROM depth_all
+			WHERE timestamp >= ? AND timestamp <= ?
+			ORDER BY timestamp, market;
+		`
+	} else if dataType == "depth" {
+		query = fmt.Sprintf(`
+			SELECT timestamp, ask_price, bid_price, ask_volume, bid_volume
+			FROM "%s"
+			WHERE timestamp >= ? AND timestamp <= ?
+			ORDER BY timestamp;
+		`, table)
+	} else {
+		query = `
+			SELECT trade_id, timestamp, price, side, volume_quote, size_base
+			FROM trades
+			WHERE timestamp >= ? AND timestamp <= ?
+			ORDER BY timestamp;
+		`
+	}
+	rows, err := db.Query(query, startDate.Unix(), endDate.Unix())
+	if err != nil {
+		return "", fmt.Errorf("failed to query table %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to create JSON %s: %v", outputFile, err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	// Пишем строки по одной, не накапливая результат в памяти
+	if !opts.JSONLines {
+		w.WriteString("[\n")
+	}
+	rowsWritten := 0
+	for rows.Next() {
+		var rec interface{}
+		if compact {
+			var r depthRow
+			if err := rows.Scan(&r.Market, &r.Timestamp, &r.AskPrice, &r.BidPrice, &r.AskVolume, &r.BidVolume); err != nil {
+				logging.Errorf("Failed to scan row: %v", err)
+				continue
+			}
+			rec = r
+		} else if dataType == "depth" {
+			var r depthRow
+			if err := rows.Scan(&r.Timestamp, &r.AskPrice, &r.BidPrice, &r.AskVolume, &r.BidVolume); err != nil {
+				logging.Errorf("Failed to scan row: %v", err)
+				continue
+			}
+			rec = r
+		} else {
+			var r tradeRow
+			if err := rows.Scan(&r.TradeID, &r.Timestamp, &r.Price, &r.Side, &r.VolumeQuote, &r.SizeBase); err != nil {
+				logging.Errorf("Failed to scan row: %v", err)
+				continue
+			}
+			rec = r
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			logging.Errorf("Failed to encode row: %v", err)
+			continue
+		}
+		if rowsWritten > 0 && !opts.JSONLines {
+			w.WriteString(",\n")
+		}
+		w.Write(data)
+		if opts.JSONLines {
+			w.WriteString("\n")
+		}
+		rowsWritten++
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("error iterating rows: %v", err)
+	}
+	if !opts.JSONLines {
+		w.WriteString("\n]\n")
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("failed to write JSON %s: %v", outputFile, err)
+	}
+
+	logging.Infof("Export completed to %s, wrote %d rows, total time %v", outputFile, rowsWritten, time.Since(startTotal))
+	return outputFile, nil
+}
@@ -0,0 +1,178 @@
+package export
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+	_ "github.com/mattn/go-sqlite3" // Драйвер SQLite
+)
+
+// depthCursor последовательно читает строки depth, упорядоченные по времени, и держит
+// последнюю строку не позже момента сделки (prev) и первую строку после него (next).
+type depthCursor struct {
+	rows    *sql.Rows
+	prev    *depthRow
+	next    *depthRow
+	scanErr error
+}
+
+// read читает следующую строку depth в next; nil — строки закончились.
+func (c *depthCursor) read() {
+	c.next = nil
+	if !c.rows.Next() {
+		return
+	}
+	var r depthRow
+	if err := c.rows.Scan(&r.Timestamp, &r.AskPrice, &r.BidPrice, &r.AskVolume, &r.BidVolume); err != nil {
+		c.scanErr = err
+		return
+	}
+	c.next = &r
+}
+
+// nearest сдвигает курсор до момента ts и возвращает ближайшую по времени строку depth.
+// При равном расстоянии предпочитается строка до сделки — стакан, действовавший в момент сделки.
+func (c *depthCursor) nearest(ts int64) *depthRow {
+	for c.next != nil && c.next.Timestamp <= ts {
+		c.prev = c.next
+		c.read()
+	}
+	switch {
+	case c.prev == nil:
+		return c.next
+	case c.next == nil:
+		return c.prev
+	case c.next.Timestamp-ts < ts-c.prev.Timestamp:
+		return c.next
+	}
+	return c.prev
+}
+
+// ExportTradesWithDepth выгружает в CSV сделки рынка tradesMarket ("SPBL", "UMCBL") вместе
+// с ближайшей по времени строкой таблицы depth depthTable ("1" или "2") той же пары:
+// timestamp, trade_price, side, size, depth_mid, spread. Обе таблицы читаются одним проходом
+// по возрастанию времени (merge join), без отдельного запроса на каждую сделку.
+// Если строк depth за период нет, колонки depth_mid и spread пустые.
+// Возвращает пустое имя файла, если базы сделок нет или сделок за период не найдено.
+func ExportTradesWithDepth(tradesDBPath, depthDBPath, pair, tradesMarket, depthTable string, startDate, endDate time.Time, opts Options) (string, error) {
+	trades, ok, err := openReadOnly(tradesDBPath)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		logging.Infof("Database %s does not exist, skipping export", tradesDBPath)
+		return "", nil
+	}
+	defer trades.Close()
+
+	tradeRows, err := trades.Query(`
+		SELECT timestamp, price, side, size_base
+		FROM trades
+		WHERE timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp, rowid;
+	`, startDate.Unix(), endDate.Unix())
+	if err != nil {
+		return "", fmt.Errorf("failed to query trades in %s: %v", tradesDBPath, err)
+	}
+	defer tradeRows.Close()
+
+	// Курсор depth; без базы или таблицы сделки выгружаются с пустыми колонками depth
+	cursor := &depthCursor{}
+	if _, ok, err := LastTimestamp(depthDBPath, depthTable); err != nil {
+		return "", err
+	} else if ok {
+		depth, _, err := openReadOnly(depthDBPath)
+		if err != nil {
+			return "", err
+		}
+		defer depth.Close()
+		// Берём и строки до начала периода: ближайшая к первой сделке может быть раньше
+		cursor.rows, err = depth.Query(fmt.Sprintf(`
+			SELECT timestamp, ask_price, bid_price, ask_volume, bid_volume
+			FROM "%s"
+			WHERE timestamp >= ? AND timestamp <= ?
+			ORDER BY timestamp;
+		`, depthTable), startDate.Add(-24*time.Hour).Unix(), endDate.Add(24*time.Hour).Unix())
+		if err != nil {
+			return "", fmt.Errorf("failed to query table %s in %s: %v", depthTable, depthDBPath, err)
+		}
+		defer cursor.rows.Close()
+		cursor.read()
+	} else {
+		logging.Infof("No depth table %s in %s, depth columns will be empty", depthTable, depthDBPath)
+	}
+
+	startStr := startDate.Format("2006-01-02")
+	endStr := endDate.Format("2006-01-02")
+	outputFile := opts.outputPath(fmt.Sprintf("%s_%s_trades_depth_%s-%s.csv", pair, marketName(tradesMarket), startStr, endStr))
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %v", outputFile, err)
+	}
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to create CSV %s: %v", outputFile, err)
+	}
+	defer file.Close()
+
+	bw := bufio.NewWriter(file)
+	writer := csv.NewWriter(bw)
+	if err := writer.Write([]string{"timestamp", "trade_price", "side", "size", "depth_mid", "spread"}); err != nil {
+		return "", fmt.Errorf("failed to write header to %s: %v", outputFile, err)
+	}
+	formatFloat := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	written := 0
+	for tradeRows.Next() {
+		var timestamp int64
+		var price, size float64
+		var side string
+		if err := tradeRows.Scan(&timestamp, &price, &side, &size); err != nil {
+			logging.Errorf("Failed to scan row: %v", err)
+			continue
+		}
+		record := []string{strconv.FormatInt(timestamp, 10), formatFloat(price), side, formatFloat(size), "", ""}
+		if cursor.rows != nil {
+			if d := cursor.nearest(timestamp); d != nil {
+				record[4] = formatFloat((d.AskPrice + d.BidPrice) / 2)
+				record[5] = formatFloat(d.AskPrice - d.BidPrice)
+			}
+			if cursor.scanErr != nil {
+				return "", fmt.Errorf("failed to scan depth row: %v", cursor.scanErr)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return "", fmt.Errorf("failed to write row to %s: %v", outputFile, err)
+		}
+		written++
+	}
+	if err := tradeRows.Err(); err != nil {
+		return "", fmt.Errorf("error iterating rows: %v", err)
+	}
+	if cursor.rows != nil {
+		if err := cursor.rows.Err(); err != nil {
+			return "", fmt.Errorf("error iterating depth rows: %v", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", fmt.Errorf("failed to flush %s: %v", outputFile, err)
+	}
+	if err := bw.Flush(); err != nil {
+		return "", fmt.Errorf("failed to flush %s: %v", outputFile, err)
+	}
+
+	if written == 0 {
+		logging.Infof("No trades found in %s for period %s to %s", tradesDBPath, startStr, endStr)
+		file.Close()
+		os.Remove(outputFile)
+		return "", nil
+	}
+	logging.Infof("Exported %d trades with depth to %s", written, outputFile)
+	return outputFile, nil
+}
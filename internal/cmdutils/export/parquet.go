@@ -0,0 +1,168 @@
+package export
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+	_ "github.com/mattn/go-sqlite3" // Драйвер SQLite
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	parquetRowGroupSize = 64 * 1024 * 1024 // Размер группы строк: буфер писателя сбрасывается на диск по её заполнении
+	parquetPageSize     = 1024 * 1024
+)
+
+// parquetDepthRow — строка таблицы depth в Parquet; типы колонок совпадают с базой.
+type parquetDepthRow struct {
+	Timestamp int64   `parquet:"name=timestamp, type=INT64"`
+	AskPrice  float64 `parquet:"name=ask_price, type=DOUBLE"`
+	BidPrice  float64 `parquet:"name=bid_price, type=DOUBLE"`
+	AskVolume float64 `parquet:"name=ask_volume, type=DOUBLE"`
+	BidVolume float64 `parquet:"name=bid_volume, type=DOUBLE"`
+}
+
+// parquetTradeRow — строка таблицы trades в Parquet.
+type parquetTradeRow struct {
+	TradeID     string  `parquet:"name=trade_id, type=UTF8"`
+	Timestamp   int64   `parquet:"name=timestamp, type=INT64"`
+	Price       float64 `parquet:"name=price, type=DOUBLE"`
+	Side        string  `parquet:"name=side, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	VolumeQuote float64 `parquet:"name=volume_quote, type=DOUBLE"`
+	SizeBase    float64 `parquet:"name=size_base, type=DOUBLE"`
+}
+
+// ExportToParquet выгружает сырые строки depth или trades в Parquet-файл со сжатием Snappy.
+// Для depth market — таблица рынка ("1" или "2"), для trades — код рынка ("SPBL", "UMCBL", ...).
+// Строки читаются из базы потоком, а писатель сбрасывает их на диск группами по
+// parquetRowGroupSize байт, поэтому память не растёт с размером выгрузки.
+func ExportToParquet(dbPath, pair, market string, startDate, endDate time.Time, opts Options) (string, error) {
+	startTotal := time.Now()
+
+	db, ok, err := openReadOnly(dbPath)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		logging.Infof("Database %s does not exist, skipping export", dbPath)
+		return "", nil
+	}
+	defer db.Close()
+
+	dataType := "trades"
+	table := "trades"
+	if isDepthMarket(market) {
+		dataType = "depth"
+		table = market
+	}
+
+	// Проверяем таблицу
+	var tableExists string
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name=?`, table).Scan(&tableExists)
+	if err == sql.ErrNoRows {
+		logging.Infof("Table %s does not exist in %s, skipping", table, dbPath)
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to check table %s: %v", table, err)
+	}
+
+	var query string
+	var schema interface{}
+	if dataType == "depth" {
+		query = fmt.Sprintf(`
+			SELECT timestamp, ask_price, bid_price, ask_volume, bid_volume
+			FROM "%s"
+			WHERE timestamp >= ? AND timestamp <= ?
+			ORDER BY timestamp;
+		`, table)
+		schema = new(parquetDepthRow)
+	} else {
+		query = `
+			SELECT trade_id, timestamp, price, side, volume_quote, size_base
+			FROM trades
+			WHERE timestamp >= ? AND timestamp <= ?
+			ORDER BY timestamp;
+		`
+		schema = new(parquetTradeRow)
+	}
+	rows, err := db.Query(query, startDate.Unix(), endDate.Unix())
+	if err != nil {
+		return "", fmt.Errorf("failed to query table %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	startStr := startDate.Format("2006-01-02")
+	endStr := endDate.Format("2006-01-02")
+	outputFile := opts.outputPath(fmt.Sprintf("%s_%s_%s_%s-%s.parquet", pair, marketName(market), dataType, startStr, endStr))
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %v", outputFile, err)
+	}
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Parquet %s: %v", outputFile, err)
+	}
+	defer f.Close()
+	// Недописанный файл без футера не читается, поэтому при ошибке удаляем его
+	fail := func(err error) (string, error) {
+		f.Close()
+		os.Remove(outputFile)
+		return "", err
+	}
+
+	bw := bufio.NewWriter(f)
+	pw, err := writer.NewParquetWriterFromWriter(bw, schema, 1)
+	if err != nil {
+		return fail(fmt.Errorf("failed to create Parquet writer for %s: %v", outputFile, err))
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	pw.PageSize = parquetPageSize
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	rowsWritten := 0
+	for rows.Next() {
+		var rec interface{}
+		if dataType == "depth" {
+			var r parquetDepthRow
+			if err := rows.Scan(&r.Timestamp, &r.AskPrice, &r.BidPrice, &r.AskVolume, &r.BidVolume); err != nil {
+				logging.Errorf("Failed to scan row: %v", err)
+				continue
+			}
+			rec = r
+		} else {
+			var r parquetTradeRow
+			if err := rows.Scan(&r.TradeID, &r.Timestamp, &r.Price, &r.Side, &r.VolumeQuote, &r.SizeBase); err != nil {
+				logging.Errorf("Failed to scan row: %v", err)
+				continue
+			}
+			rec = r
+		}
+		if err := pw.Write(rec); err != nil {
+			return fail(fmt.Errorf("failed to write row to %s: %v", outputFile, err))
+		}
+		rowsWritten++
+	}
+	if err := rows.Err(); err != nil {
+		return fail(fmt.Errorf("error iterating rows: %v", err))
+	}
+	if rowsWritten == 0 {
+		logging.Infof("No %s found in %s for period %s to %s", dataType, dbPath, startStr, endStr)
+		f.Close()
+		os.Remove(outputFile)
+		return "", nil
+	}
+	if err := pw.WriteStop(); err != nil {
+		return fail(fmt.Errorf("failed to finish Parquet %s: %v", outputFile, err))
+	}
+	if err := bw.Flush(); err != nil {
+		return fail(fmt.Errorf("failed to write Parquet %s: %v", outputFile, err))
+	}
+
+	logging.Infof("Export completed to %s, wrote %d rows, total time %v", outputFile, rowsWritten, time.Since(startTotal))
+	return outputFile, nil
+}
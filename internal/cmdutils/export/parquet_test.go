@@ -0,0 +1,48 @@
+package export
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportToParquetEmptyPeriod(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "trades.db")
+	conn, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(`CREATE TABLE trades (trade_id TEXT, timestamp INTEGER, price REAL, side TEXT, volume_quote REAL, size_base REAL);
+		INSERT INTO trades VALUES ('1', 1735689600, 100, 'buy', 100, 1)`)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{OutputDir: filepath.Join(dir, "out")}
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	out, err := ExportToParquet(dbPath, "BTCUSDT", "SPBL", start, start.Add(24*time.Hour-time.Second), opts)
+	if err != nil {
+		t.Fatalf("ExportToParquet: %v", err)
+	}
+	if out != "" {
+		t.Errorf("output = %q, want empty for a period without rows", out)
+	}
+	entries, _ := os.ReadDir(opts.OutputDir)
+	if len(entries) != 0 {
+		t.Errorf("output directory has %d files, want none", len(entries))
+	}
+
+	// Период с данными по-прежнему выгружается
+	start = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	out, err = ExportToParquet(dbPath, "BTCUSDT", "SPBL", start, start.Add(24*time.Hour-time.Second), opts)
+	if err != nil {
+		t.Fatalf("ExportToParquet: %v", err)
+	}
+	if info, err := os.Stat(out); err != nil || info.Size() == 0 {
+		t.Errorf("expected a non-empty Parquet file, got %q: %v", out, err)
+	}
+}
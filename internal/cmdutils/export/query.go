@@ -0,0 +1,200 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // Драйвер SQLite
+)
+
+// Summary — сводка по строкам базы за период для --query.
+type Summary struct {
+	Rows       int64
+	FirstTime  int64   // Первая метка времени, секунды
+	LastTime   int64   // Последняя метка времени, секунды
+	FirstPrice float64 // Для depth — середина спреда
+	LastPrice  float64
+}
+
+// priceExpr возвращает SQL-выражение цены: цена сделки или середина спреда depth.
+func priceExpr(market string) string {
+	if isDepthMarket(market) {
+		return "(ask_price + bid_price) / 2"
+	}
+	return "price"
+}
+
+// sourceTable возвращает таблицу trades или таблицу рынка depth.
+func sourceTable(market string) string {
+	if isDepthMarket(market) {
+		return market
+	}
+	return "trades"
+}
+
+// openReadOnly открывает базу только для чтения; ok=false, если базы нет.
+func openReadOnly(dbPath string) (db *sql.DB, ok bool, err error) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	db, err = sql.Open("sqlite3", dbPath+"?mode=ro")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open database %s: %v", dbPath, err)
+	}
+	return db, true, nil
+}
+
+// QuerySummary считает строки, крайние метки времени и цены в базе за период.
+// Для depth market — таблица рынка ("1" или "2"), для trades — код рынка. Нет базы — пустая сводка.
+func QuerySummary(dbPath, market string, startDate, endDate time.Time) (Summary, error) {
+	var s Summary
+	db, ok, err := openReadOnly(dbPath)
+	if err != nil || !ok {
+		return s, err
+	}
+	defer db.Close()
+
+	table := sourceTable(market)
+	var first, last sql.NullInt64
+	err = db.QueryRow(fmt.Sprintf(`SELECT COUNT(*), MIN(timestamp), MAX(timestamp) FROM "%s" WHERE timestamp >= ? AND timestamp <= ?`, table),
+		startDate.Unix(), endDate.Unix()).Scan(&s.Rows, &first, &last)
+	if err != nil {
+		return s, fmt.Errorf("failed to query table %s in %s: %v", table, dbPath, err)
+	}
+	if s.Rows == 0 {
+		return s, nil
+	}
+	s.FirstTime, s.LastTime = first.Int64, last.Int64
+
+	priceQuery := fmt.Sprintf(`SELECT %s FROM "%s" WHERE timestamp = ? ORDER BY rowid %%s LIMIT 1`, priceExpr(market), table)
+	if err := db.QueryRow(fmt.Sprintf(priceQuery, "ASC"), s.FirstTime).Scan(&s.FirstPrice); err != nil {
+		return s, fmt.Errorf("failed to query first price in %s: %v", dbPath, err)
+	}
+	if err := db.QueryRow(fmt.Sprintf(priceQuery, "DESC"), s.LastTime).Scan(&s.LastPrice); err != nil {
+		return s, fmt.Errorf("failed to query last price in %s: %v", dbPath, err)
+	}
+	return s, nil
+}
+
+// LastTimestamp возвращает наибольший timestamp таблицы table. ok=false, если базы
+// или таблицы нет либо таблица пуста.
+func LastTimestamp(dbPath, table string) (last int64, ok bool, err error) {
+	db, exists, err := openReadOnly(dbPath)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+	defer db.Close()
+
+	var name string
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name=?`, table).Scan(&name)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to check table %s in %s: %v", table, dbPath, err)
+	}
+	var maxTs sql.NullInt64
+	if err := db.QueryRow(fmt.Sprintf(`SELECT MAX(timestamp) FROM "%s"`, table)).Scan(&maxTs); err != nil {
+		return 0, false, fmt.Errorf("failed to query table %s in %s: %v", table, dbPath, err)
+	}
+	return maxTs.Int64, maxTs.Valid, nil
+}
+
+// ImportedDays возвращает дни (YYYYMMDD по UTC), за которые в таблице table есть строки
+// с timestamp в полуинтервале [startDate, endDate). Нет базы или таблицы — пустой результат.
+func ImportedDays(dbPath, table string, startDate, endDate time.Time) (map[string]bool, error) {
+	days := make(map[string]bool)
+	db, ok, err := openReadOnly(dbPath)
+	if err != nil || !ok {
+		return days, err
+	}
+	defer db.Close()
+
+	var name string
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name=?`, table).Scan(&name)
+	if err == sql.ErrNoRows {
+		return days, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check table %s in %s: %v", table, dbPath, err)
+	}
+	rows, err := db.Query(fmt.Sprintf(`SELECT DISTINCT timestamp / 86400 FROM "%s" WHERE timestamp >= ? AND timestamp < ?`, table),
+		startDate.Unix(), endDate.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query table %s in %s: %v", table, dbPath, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day int64
+		if err := rows.Scan(&day); err != nil {
+			return nil, fmt.Errorf("failed to scan day from %s: %v", dbPath, err)
+		}
+		days[time.Unix(day*86400, 0).UTC().Format("20060102")] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+	return days, nil
+}
+
+// DumpCSV пишет строки базы за период в w как CSV с заголовком и возвращает их число.
+func DumpCSV(w io.Writer, dbPath, market string, startDate, endDate time.Time) (int, error) {
+	db, ok, err := openReadOnly(dbPath)
+	if err != nil || !ok {
+		return 0, err
+	}
+	defer db.Close()
+
+	depth := isDepthMarket(market)
+	var header []string
+	var query string
+	if depth {
+		header = []string{"timestamp", "ask_price", "bid_price", "ask_volume", "bid_volume"}
+		query = fmt.Sprintf(`SELECT timestamp, ask_price, bid_price, ask_volume, bid_volume FROM "%s" WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp`, market)
+	} else {
+		header = []string{"trade_id", "timestamp", "price", "side", "volume_quote", "size_base"}
+		query = `SELECT trade_id, timestamp, price, side, volume_quote, size_base FROM trades WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp`
+	}
+	rows, err := db.Query(query, startDate.Unix(), endDate.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to query %s: %v", dbPath, err)
+	}
+	defer rows.Close()
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return 0, err
+	}
+	written := 0
+	formatFloat := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for rows.Next() {
+		var record []string
+		if depth {
+			var r depthRow
+			if err := rows.Scan(&r.Timestamp, &r.AskPrice, &r.BidPrice, &r.AskVolume, &r.BidVolume); err != nil {
+				return written, fmt.Errorf("failed to scan row: %v", err)
+			}
+			record = []string{strconv.FormatInt(r.Timestamp, 10), formatFloat(r.AskPrice), formatFloat(r.BidPrice), formatFloat(r.AskVolume), formatFloat(r.BidVolume)}
+		} else {
+			var r tradeRow
+			if err := rows.Scan(&r.TradeID, &r.Timestamp, &r.Price, &r.Side, &r.VolumeQuote, &r.SizeBase); err != nil {
+				return written, fmt.Errorf("failed to scan row: %v", err)
+			}
+			record = []string{r.TradeID, strconv.FormatInt(r.Timestamp, 10), formatFloat(r.Price), r.Side, formatFloat(r.VolumeQuote), formatFloat(r.SizeBase)}
+		}
+		if err := writer.Write(record); err != nil {
+			return written, err
+		}
+		written++
+	}
+	if err := rows.Err(); err != nil {
+		return written, fmt.Errorf("error iterating rows: %v", err)
+	}
+	writer.Flush()
+	return written, writer.Error()
+}
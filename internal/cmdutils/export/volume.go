@@ -0,0 +1,115 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// DefaultVolumeTolerance — допустимое относительное расхождение дневного объёма по умолчанию (1%).
+const DefaultVolumeTolerance = 0.01
+
+// DailyVolumes — суммарный объём size_base по дням (ключ — дата YYYY-MM-DD в UTC).
+type DailyVolumes map[string]float64
+
+// LoadDailyVolumes читает эталонные дневные объёмы из CSV вида "date,volume".
+// Дата — YYYY-MM-DD или YYYYMMDD; строки, которые не удаётся разобрать (например, заголовок), пропускаются.
+func LoadDailyVolumes(path string) (DailyVolumes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open volume reference %s: %w", path, err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	volumes := make(DailyVolumes)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read volume reference %s at line %d: %w", path, line, err)
+		}
+		if len(record) < 2 {
+			continue
+		}
+		dateStr := strings.TrimSpace(record[0])
+		date, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			if date, err = time.Parse("20060102", dateStr); err != nil {
+				if line == 1 {
+					continue // Заголовок
+				}
+				logging.Warnf("Skipping volume reference line %d in %s: invalid date %s", line, path, dateStr)
+				continue
+			}
+		}
+		volume, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			logging.Warnf("Skipping volume reference line %d in %s: invalid volume %s", line, path, record[1])
+			continue
+		}
+		volumes[date.Format("2006-01-02")] = volume
+	}
+	if len(volumes) == 0 {
+		return nil, fmt.Errorf("no daily volumes found in %s", path)
+	}
+	return volumes, nil
+}
+
+// add прибавляет объём сделки к дню её timestamp (секунды, UTC).
+func (v DailyVolumes) add(timestamp int64, volume float64) {
+	v[time.Unix(timestamp, 0).UTC().Format("2006-01-02")] += volume
+}
+
+// reconcileVolumes сравнивает объёмы архива с эталоном и логирует дни, где расхождение
+// превышает tolerance (доля от эталона). Возвращает список таких дней по возрастанию.
+func reconcileVolumes(actual, expected DailyVolumes, startDate, endDate time.Time, tolerance float64) []string {
+	if tolerance <= 0 {
+		tolerance = DefaultVolumeTolerance
+	}
+	days := make([]string, 0, len(expected))
+	for day := range expected {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	var mismatched []string
+	checked := 0
+	for _, day := range days {
+		want := expected[day]
+		date, _ := time.Parse("2006-01-02", day)
+		if date.Before(startDate.Truncate(24 * time.Hour)) {
+			continue
+		}
+		// Выгрузка идёт до endDate включительно: день, попавший в неё не целиком, не сверяется
+		if date.AddDate(0, 0, 1).Add(-time.Second).After(endDate) {
+			continue
+		}
+		checked++
+		got := actual[day]
+		diff := math.Abs(got - want)
+		if want == 0 && got == 0 {
+			continue
+		}
+		if want == 0 || diff/want > tolerance {
+			mismatched = append(mismatched, day)
+			logging.Warnf("Volume mismatch on %s: archive %.8f, expected %.8f (diff %.8f), archive may be incomplete", day, got, want, got-want)
+		}
+	}
+	logging.Infof("Volume reconciliation: %d of %d days outside tolerance %.4f", len(mismatched), checked, tolerance)
+	if len(mismatched) > 0 {
+		logging.Infof("Days to re-fetch: %s", strings.Join(mismatched, ", "))
+	}
+	return mismatched
+}
@@ -0,0 +1,32 @@
+package export
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReconcileVolumesEndDay(t *testing.T) {
+	expected := DailyVolumes{"2025-01-01": 10, "2025-01-02": 20, "2025-01-03": 30}
+	actual := DailyVolumes{"2025-01-01": 10, "2025-01-02": 5, "2025-01-03": 1}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		end  time.Time
+		want []string
+	}{
+		// Полночь --end: сам день в выгрузку не попал
+		{"midnight", time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC), []string{"2025-01-02"}},
+		// Середина дня (--end не задан, time.Now()): день неполный
+		{"partial day", time.Date(2025, 1, 3, 15, 30, 0, 0, time.UTC), []string{"2025-01-02"}},
+		// Последняя секунда дня: день выгружен целиком
+		{"end of day", time.Date(2025, 1, 3, 23, 59, 59, 0, time.UTC), []string{"2025-01-02", "2025-01-03"}},
+	}
+	for _, tt := range tests {
+		got := reconcileVolumes(actual, expected, start, tt.end, 0.01)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: mismatched = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
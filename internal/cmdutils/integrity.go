@@ -0,0 +1,129 @@
+package cmdutils
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/magf/bitget-history/internal/logging"
+	_ "github.com/mattn/go-sqlite3" // Драйвер SQLite
+)
+
+// ValidationResult — проблемы, найденные в одной базе при --validate.
+type ValidationResult struct {
+	Path     string   `json:"path"`
+	Problems []string `json:"problems"`
+}
+
+// CheckIntegrity открывает базу только для чтения и выполняет PRAGMA integrity_check.
+// Возвращает ошибку со списком найденных проблем, если база повреждена или не открывается.
+func CheckIntegrity(dbPath string) error {
+	conn, err := sql.Open("sqlite3", dbPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	problems, err := integrityProblems(conn)
+	if err != nil {
+		return fmt.Errorf("failed to check integrity of %s: %w", dbPath, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check of %s failed: %s", dbPath, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ValidateDatabase выполняет PRAGMA integrity_check и PRAGMA foreign_key_check на базе,
+// открытой только для чтения. Пустой список — база в порядке.
+func ValidateDatabase(dbPath string) ([]string, error) {
+	conn, err := sql.Open("sqlite3", dbPath+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	problems, err := integrityProblems(conn)
+	if err != nil {
+		return nil, fmt.Errorf("integrity_check: %w", err)
+	}
+	rows, err := conn.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, fmt.Errorf("foreign_key_check: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return nil, fmt.Errorf("foreign_key_check: %w", err)
+		}
+		problems = append(problems, fmt.Sprintf("foreign key violation in %s rowid %d: missing row in %s (constraint %d)", table, rowid.Int64, parent, fkid))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("foreign_key_check: %w", err)
+	}
+	return problems, nil
+}
+
+// ValidateDatabases проверяет все файлы .db под root и возвращает базы с проблемами
+// (отсортированные по пути) и число проверенных баз. Ничего не изменяет.
+func ValidateDatabases(root string) ([]ValidationResult, int, error) {
+	var failed []ValidationResult
+	checked := 0
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if !os.IsNotExist(err) { // -wal и -shm могут исчезнуть при закрытии соседней базы
+				logging.Errorf("Error accessing path %s: %v", path, err)
+			}
+			return nil
+		}
+		if info.IsDir() || !strings.HasSuffix(strings.ToLower(info.Name()), ".db") {
+			return nil
+		}
+		checked++
+		logging.Debugf("Validating database: %s", path)
+		problems, err := ValidateDatabase(path)
+		if err != nil {
+			problems = []string{err.Error()}
+		}
+		if len(problems) > 0 {
+			logging.Errorf("Database %s failed validation: %s", path, strings.Join(problems, "; "))
+			failed = append(failed, ValidationResult{Path: path, Problems: problems})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, checked, fmt.Errorf("failed to walk %s: %w", root, err)
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Path < failed[j].Path })
+	return failed, checked, nil
+}
+
+// integrityProblems возвращает строки PRAGMA integrity_check, кроме единственного "ok".
+func integrityProblems(conn *sql.DB) ([]string, error) {
+	rows, err := conn.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 1 && out[0] == "ok" {
+		return nil, nil
+	}
+	return out, nil
+}
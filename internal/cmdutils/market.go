@@ -0,0 +1,75 @@
+package cmdutils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// productCodeRe — код продукта Bitget в путях архивов: SPBL, UMCBL, DMCBL, CMCBL и т.п.
+var productCodeRe = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,15}$`)
+
+// IsProductCode сообщает, что market — код продукта Bitget, а не spot, futures или all.
+func IsProductCode(market string) bool {
+	return market != "spot" && market != "futures" && market != "all" && productCodeRe.MatchString(market)
+}
+
+// NormalizeMarket приводит значение --market к виду, который понимает MarketCodes: spot, futures
+// и all остаются как есть, код продукта (dmcbl, DMCBL) приводится к верхнему регистру.
+func NormalizeMarket(market string) (string, error) {
+	switch market {
+	case "spot", "futures", "all":
+		return market, nil
+	}
+	code := strings.ToUpper(market)
+	if !productCodeRe.MatchString(code) {
+		return "", fmt.Errorf("invalid market: %s (must be spot, futures, all or a Bitget product code such as DMCBL)", market)
+	}
+	return code, nil
+}
+
+// ValidateMarket проверяет, что для типа данных есть архивы рынка: ставки финансирования есть
+// только у фьючерсов, а depth хранится по номерам рынков 1 и 2, поэтому из кодов продуктов
+// для depth подходят только SPBL и UMCBL.
+func ValidateMarket(dataType, market string) error {
+	if dataType == "funding" && (market == "spot" || market == "SPBL") {
+		return fmt.Errorf("type funding is available for futures only")
+	}
+	if dataType == "depth" && IsProductCode(market) && len(MarketCodes(dataType, market)) == 0 {
+		return fmt.Errorf("market %s is not available for type depth (use spot, futures, all, SPBL or UMCBL)", market)
+	}
+	return nil
+}
+
+// MarketCodes возвращает коды рынков Bitget для типа данных и значения --market: каталоги
+// trades, kline и funding (SPBL, UMCBL или заданный код продукта) либо таблицы depth (1 — spot,
+// 2 — futures). Для кода продукта без таблицы depth возвращает nil.
+func MarketCodes(dataType, market string) []string {
+	if dataType == "depth" {
+		switch market {
+		case "futures", "UMCBL":
+			return []string{"2"}
+		case "all":
+			return []string{"1", "2"}
+		case "spot", "SPBL":
+			return []string{"1"}
+		}
+		if IsProductCode(market) {
+			return nil
+		}
+		return []string{"1"}
+	}
+	if IsProductCode(market) {
+		return []string{market}
+	}
+	if dataType == "funding" {
+		return []string{"UMCBL"} // Ставки финансирования есть только у фьючерсов
+	}
+	switch market {
+	case "futures":
+		return []string{"UMCBL"}
+	case "all":
+		return []string{"SPBL", "UMCBL"}
+	}
+	return []string{"SPBL"}
+}
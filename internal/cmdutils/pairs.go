@@ -0,0 +1,55 @@
+package cmdutils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParsePairs возвращает список пар из --pair (через запятую) или из файла --pairs-file
+// (по одной паре в строке, пустые строки и строки с # пропускаются). Если указан файл,
+// --pair игнорируется. Пары приводятся к верхнему регистру, дубликаты отбрасываются.
+func ParsePairs(pairList, pairsFile string) ([]string, error) {
+	var raw []string
+	if pairsFile != "" {
+		file, err := os.Open(pairsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open pairs file %s: %w", pairsFile, err)
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			raw = append(raw, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read pairs file %s: %w", pairsFile, err)
+		}
+	} else {
+		raw = strings.Split(pairList, ",")
+	}
+
+	var pairs []string
+	seen := make(map[string]bool)
+	for _, p := range raw {
+		p = strings.ToUpper(strings.TrimSpace(p))
+		if p == "" || seen[p] {
+			continue
+		}
+		for _, r := range p {
+			if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+				return nil, fmt.Errorf("invalid pair %q", p)
+			}
+		}
+		seen[p] = true
+		pairs = append(pairs, p)
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no pairs given")
+	}
+	return pairs, nil
+}
@@ -0,0 +1,58 @@
+package cmdutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// RunSummary описывает итог запуска для уведомлений и отчёта --report.
+type RunSummary struct {
+	Status          string    `json:"status"` // success или failed
+	Error           string    `json:"error,omitempty"`
+	Pair            string    `json:"pair"`
+	Type            string    `json:"type"`
+	Market          string    `json:"market"`
+	StartDate       string    `json:"start_date,omitempty"`
+	EndDate         string    `json:"end_date,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	URLs            int       `json:"urls"`
+
+	Downloaded         int              `json:"downloaded"`
+	SkippedExisting    int              `json:"skipped_existing"`
+	Failed             int              `json:"failed"`
+	BrokenRedownloaded int              `json:"broken_redownloaded"` // Перекачаны поверх битых или устаревших локальных файлов
+	FailedURLs         []string         `json:"failed_urls"`
+	InsertedRows       map[string]int64 `json:"inserted_rows"` // База → вставлено строк
+}
+
+// Finish фиксирует статус и время завершения запуска.
+func (s *RunSummary) Finish(err error) {
+	s.FinishedAt = time.Now()
+	s.DurationSeconds = s.FinishedAt.Sub(s.StartedAt).Seconds()
+	s.Status = "success"
+	if err != nil {
+		s.Status = "failed"
+		s.Error = err.Error()
+	}
+}
+
+// WriteFile сохраняет сводку в JSON-файл path.
+func (s *RunSummary) WriteFile(path string) error {
+	data, err := s.JSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode run summary: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write run report %s: %w", path, err)
+	}
+	return nil
+}
+
+// JSON возвращает сводку в формате JSON.
+func (s *RunSummary) JSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
@@ -2,36 +2,38 @@ package cmdutils
 
 import (
 	"bufio"
+	"context"
+	"database/sql"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/magf/bitget-history/internal/downloader"
+	"github.com/magf/bitget-history/internal/logging"
+	_ "github.com/mattn/go-sqlite3" // Драйвер SQLite
 	"gopkg.in/yaml.v3"
 )
 
-// GenerateURLs генерирует список URL-ов на основе параметров.
-func GenerateURLs(dl *downloader.Downloader, market, pair, dataType string, startDate, endDate time.Time, debug, skipIfExists, skipDownload bool, outputDir string) ([]downloader.FileInfo, error) {
+// GenerateURLs генерирует список URL-ов на основе параметров. При отмене ctx возвращает ошибку.
+// При placeholders для отсутствующих на сервере (403/404) файлов depth создаются пустые файлы-заглушки.
+func GenerateURLs(ctx context.Context, dl *downloader.Downloader, market, pair, dataType string, startDate, endDate time.Time, debug, skipIfExists, skipDownload, placeholders bool, outputDir string) ([]downloader.FileInfo, error) {
 	var urls []downloader.FileInfo
 	var mu sync.Mutex
 	var wg sync.WaitGroup
+	sem := make(chan struct{}, dl.CheckConcurrency()) // Не более стольких проверок одновременно
 
 	if dataType == "trades" {
-		marketCodes := []string{"SPBL"} // spot по умолчанию
-		if market == "futures" {
-			marketCodes = []string{"UMCBL"}
-		} else if market == "all" {
-			marketCodes = []string{"SPBL", "UMCBL"}
-		}
-		for _, marketCode := range marketCodes {
-			for d := startDate; !d.After(endDate); d = d.AddDate(0, 0, 1) {
+		for _, marketCode := range MarketCodes(dataType, market) {
+			for d := startDate; !d.After(endDate) && ctx.Err() == nil; d = d.AddDate(0, 0, 1) {
 				dateStr := d.Format("20060102")
-				// Проверяем файлы пачками по 10
+				// Проверяем файлы пачками по 10. Нумерация бывает с пропусками, поэтому дата
+				// заканчивается только после dl.TradesMissRun() отсутствующих подряд частей
+				missRun := 0
 				for startNum := 1; startNum <= 999; startNum += 10 {
 					endNum := startNum + 9
 					if endNum > 999 {
@@ -46,12 +48,19 @@ func GenerateURLs(dl *downloader.Downloader, market, pair, dataType string, star
 						batchPaths = append(batchPaths, path)
 					}
 
-					// Параллельная проверка пачки
-					stopBatch := false
+					// Параллельная проверка пачки; found[i] — есть ли часть, nil — проверка не удалась
+					found := make([]*bool, len(batchURLs))
+					setFound := func(i int, ok bool) {
+						mu.Lock()
+						found[i] = &ok
+						mu.Unlock()
+					}
 					for i, url := range batchURLs {
 						wg.Add(1)
-						go func(url, path string) {
+						sem <- struct{}{}
+						go func(i int, url, path string) {
 							defer wg.Done()
+							defer func() { <-sem }()
 
 							// Пропускаем скачивание, если установлен --skip-download
 							if skipDownload {
@@ -65,74 +74,122 @@ func GenerateURLs(dl *downloader.Downloader, market, pair, dataType string, star
 							if skipIfExists {
 								localPath := filepath.Join(outputDir, path)
 								if _, err := os.Stat(localPath); err == nil {
-									if debug {
-										log.Printf("Skipping %s: file already exists locally", url)
-									}
+									logging.Debugf("Skipping %s: file already exists locally", url)
+									setFound(i, true)
 									mu.Lock()
-									urls = append(urls, downloader.FileInfo{URL: url, ContentLength: 0})
+									urls = append(urls, downloader.FileInfo{URL: url, ContentLength: 0, Exists: true})
 									mu.Unlock()
 									return
 								}
 							}
 
 							// Проверяем доступность URL
-							statusCode, contentLength, err := dl.CheckFileOnline(url, debug)
+							statusCode, contentLength, err := dl.CheckFileOnline(ctx, url, debug)
 							if err != nil {
-								if debug {
-									log.Printf("Error checking %s: %v", url, err)
-								}
+								logging.Debugf("Error checking %s: %v", url, err)
 								return
 							}
 							if statusCode != 200 {
-								if debug {
-									log.Printf("Skipping %s: status code %d", url, statusCode)
-								}
-								mu.Lock()
-								stopBatch = true
-								mu.Unlock()
+								logging.Debugf("Skipping %s: status code %d", url, statusCode)
+								setFound(i, false)
 								return
 							}
+							setFound(i, true)
 							mu.Lock()
 							urls = append(urls, downloader.FileInfo{URL: url, ContentLength: contentLength})
 							if debug {
-								log.Printf("Generated URL: %s (Content-Length: %d)", url, contentLength)
-							} else {
+								logging.Debugf("Generated URL: %s (Content-Length: %d)", url, contentLength)
+							} else if logging.Pretty() {
 								fmt.Fprintf(os.Stdout, "\r  Generated URL: %-90s (Content-Length: %d)                    \r", url, contentLength)
 							}
 							mu.Unlock()
-						}(url, batchPaths[i])
+						}(i, url, batchPaths[i])
 					}
 					wg.Wait()
-					if stopBatch {
+					for _, ok := range found {
+						if ok == nil {
+							continue
+						}
+						if *ok {
+							missRun = 0
+						} else {
+							missRun++
+						}
+					}
+					if missRun >= dl.TradesMissRun() {
 						break // Прерываем цикл для этой даты
 					}
 				}
 			}
 		}
-	} else { // depth
-		// Выбираем marketCodes в зависимости от --market
-		marketCodes := []string{"1"} // spot по умолчанию
-		if market == "futures" {
-			marketCodes = []string{"2"}
-		} else if market == "all" {
-			marketCodes = []string{"1", "2"}
+	} else if dataType == "kline" || dataType == "funding" {
+		// Архивы kline и funding: один файл на дату, <type>/<MARKET>/<PAIR>/<YYYYMMDD>.zip
+		for _, marketCode := range MarketCodes(dataType, market) {
+			for d := startDate; !d.After(endDate); d = d.AddDate(0, 0, 1) {
+				path := fmt.Sprintf("%s/%s/%s/%s.zip", dataType, marketCode, pair, d.Format("20060102"))
+				url := fmt.Sprintf("%s/%s", strings.TrimSuffix(dl.BaseURL, "/"), path)
+
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(url, path string) {
+					defer wg.Done()
+					defer func() { <-sem }()
+
+					// Пропускаем проверку при --skip-download и уже скачанные файлы при --skip-exists
+					if skipDownload {
+						mu.Lock()
+						urls = append(urls, downloader.FileInfo{URL: url, ContentLength: 0})
+						mu.Unlock()
+						return
+					}
+					if skipIfExists {
+						if _, err := os.Stat(filepath.Join(outputDir, path)); err == nil {
+							logging.Debugf("Skipping %s: file already exists locally", url)
+							mu.Lock()
+							urls = append(urls, downloader.FileInfo{URL: url, ContentLength: 0})
+							mu.Unlock()
+							return
+						}
+					}
+
+					// Проверяем доступность URL
+					statusCode, contentLength, err := dl.CheckFileOnline(ctx, url, debug)
+					if err != nil {
+						logging.Debugf("Error checking %s: %v", url, err)
+						return
+					}
+					if statusCode != 200 {
+						logging.Debugf("Skipping %s: status code %d", url, statusCode)
+						return
+					}
+					mu.Lock()
+					urls = append(urls, downloader.FileInfo{URL: url, ContentLength: contentLength})
+					if debug {
+						logging.Debugf("Generated URL: %s (Content-Length: %d)", url, contentLength)
+					} else if logging.Pretty() {
+						fmt.Fprintf(os.Stdout, "\r  Generated URL: %-90s (Content-Length: %d)                    \r", url, contentLength)
+					}
+					mu.Unlock()
+				}(url, path)
+			}
 		}
-		for _, marketCode := range marketCodes {
+	} else { // depth
+		for _, marketCode := range MarketCodes(dataType, market) {
 			for d := startDate; !d.After(endDate); d = d.AddDate(0, 0, 1) {
 				path := fmt.Sprintf("depth/%s/%s/%s.zip", pair, marketCode, d.Format("20060102"))
 				url := fmt.Sprintf("%s/%s", strings.TrimSuffix(dl.BaseURL, "/"), path)
 
 				wg.Add(1)
+				sem <- struct{}{}
 				go func(url, path string) {
 					defer wg.Done()
+					defer func() { <-sem }()
 
 					// Проверяем, существует ли файл локально, если установлен --skip-exists
 					if skipIfExists {
 						localPath := filepath.Join(outputDir, path)
 						if _, err := os.Stat(localPath); err == nil {
-							if debug {
-								log.Printf("Skipping %s: file already exists locally", url)
-							}
+							logging.Debugf("Skipping %s: file already exists locally", url)
 							mu.Lock()
 							urls = append(urls, downloader.FileInfo{URL: url, ContentLength: 0})
 							mu.Unlock()
@@ -149,42 +206,34 @@ func GenerateURLs(dl *downloader.Downloader, market, pair, dataType string, star
 					}
 
 					// Проверяем доступность URL
-					statusCode, contentLength, err := dl.CheckFileOnline(url, debug)
+					statusCode, contentLength, err := dl.CheckFileOnline(ctx, url, debug)
 					if err != nil {
-						if debug {
-							log.Printf("Error checking %s: %v", url, err)
-						}
+						logging.Debugf("Error checking %s: %v", url, err)
 						return
 					}
 					if statusCode != 200 {
-						if statusCode == 403 || statusCode == 404 {
+						if placeholders && (statusCode == 403 || statusCode == 404) {
 							// Создаём пустой файл для depth
 							localPath := filepath.Join(outputDir, path)
 							if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
-								if debug {
-									log.Printf("Failed to create directory for %s: %v", localPath, err)
-								}
+								logging.Debugf("Failed to create directory for %s: %v", localPath, err)
 								return
 							}
 							if err := os.WriteFile(localPath, []byte{}, 0644); err != nil {
-								if debug {
-									log.Printf("Failed to create empty file %s: %v", localPath, err)
-								}
+								logging.Debugf("Failed to create empty file %s: %v", localPath, err)
 								return
 							}
-							if debug {
-								log.Printf("Created empty file %s for status %d", localPath, statusCode)
-							}
+							logging.Debugf("Created empty file %s for status %d", localPath, statusCode)
 						} else if debug {
-							log.Printf("Skipping %s: status code %d", url, statusCode)
+							logging.Warnf("Skipping %s: status code %d", url, statusCode)
 						}
 						return
 					}
 					mu.Lock()
 					urls = append(urls, downloader.FileInfo{URL: url, ContentLength: contentLength})
 					if debug {
-						log.Printf("Generated URL: %s (Content-Length: %d)", url, contentLength)
-					} else {
+						logging.Debugf("Generated URL: %s (Content-Length: %d)", url, contentLength)
+					} else if logging.Pretty() {
 						fmt.Fprintf(os.Stdout, "\r  Generated URL: %-90s (Content-Length: %d)                    \r", url, contentLength)
 					}
 					mu.Unlock()
@@ -194,10 +243,45 @@ func GenerateURLs(dl *downloader.Downloader, market, pair, dataType string, star
 	}
 
 	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("URL generation interrupted: %w", ctx.Err())
+	}
 
 	return urls, nil
 }
 
+// PrintProxyTest выводит число рабочих прокси и до sample самых быстрых из них со временем
+// проверки; прокси без измеренного времени идут в конце с прочерком.
+func PrintProxyTest(w io.Writer, proxies []string, latency func(proxyURL string) (time.Duration, bool), sample int) {
+	type measured struct {
+		proxy string
+		d     time.Duration
+		ok    bool
+	}
+	list := make([]measured, len(proxies))
+	for i, p := range proxies {
+		d, ok := latency(p)
+		list[i] = measured{p, d, ok}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].ok != list[j].ok {
+			return list[i].ok
+		}
+		return list[i].d < list[j].d
+	})
+	fmt.Fprintf(w, "Working proxies: %d\n", len(proxies))
+	if len(list) > sample {
+		list = list[:sample]
+	}
+	for _, m := range list {
+		if m.ok {
+			fmt.Fprintf(w, "  %-50s %8d ms\n", m.proxy, m.d.Milliseconds())
+		} else {
+			fmt.Fprintf(w, "  %-50s %8s\n", m.proxy, "-")
+		}
+	}
+}
+
 // ReadProxyCount читает количество прокси из working_file.
 func ReadProxyCount() (int, error) {
 	cfg := struct {
@@ -230,68 +314,266 @@ func ReadProxyCount() (int, error) {
 	return count, nil
 }
 
+// MoveOptions задаёт проверки перед заменой базы.
+type MoveOptions struct {
+	NoShrink   bool // Не заменять базу, если во временной базе меньше строк
+	Force      bool // Заменять базу даже при срабатывании NoShrink
+	BackupKeep int  // Сколько копий хранить (.1 — самая свежая); 0 или 1 — одна копия без номера
+}
+
 // MoveTempDatabase переименовывает существующую базу в файл с указанным расширением и перемещает временную базу на её место.
-func MoveTempDatabase(TempDbPath, dbPath, BackupSuffix string, debug bool) error {
+// Временная база удаляется только после того, как копия прошла PRAGMA integrity_check. До этого
+// прежняя база лежит под промежуточным именем, а копии не сдвигаются, поэтому при ошибке
+// восстанавливается прежняя база и не теряется ни одно поколение копий.
+func MoveTempDatabase(TempDbPath, dbPath, BackupSuffix string, opts MoveOptions, debug bool) error {
+	if opts.NoShrink {
+		if err := checkNoShrink(TempDbPath, dbPath, opts.Force, debug); err != nil {
+			return err
+		}
+	}
 	backupPath := dbPath + BackupSuffix
+	if opts.BackupKeep > 1 {
+		backupPath = rotatedBackupPath(dbPath+BackupSuffix, 1)
+	}
+	stagedPath := dbPath + BackupSuffix + ".tmp" // Прежняя база до успешной замены
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for database %s: %w", dbPath, err)
+	}
+	backedUp := false // Прежняя база перенесена в stagedPath
 	if _, err := os.Stat(dbPath); err == nil {
-		if err := os.Rename(dbPath, backupPath); err != nil {
-			return fmt.Errorf("failed to backup database %s to %s: %w", dbPath, backupPath, err)
+		if err := os.Rename(dbPath, stagedPath); err != nil {
+			return fmt.Errorf("failed to backup database %s to %s: %w", dbPath, stagedPath, err)
 		}
-		if debug {
-			log.Printf("Backed up database to %s", backupPath)
+		backedUp = true
+		logging.Debugf("Moved database aside to %s", stagedPath)
+	}
+	// restore возвращает прежнюю базу на место; без неё удаляет неполную копию
+	restore := func() {
+		if !backedUp {
+			os.Remove(dbPath)
+			return
+		}
+		if err := os.Rename(stagedPath, dbPath); err != nil {
+			logging.Errorf("failed to restore database %s from %s: %v", dbPath, stagedPath, err)
 		}
 	}
 	srcFile, err := os.Open(TempDbPath)
 	if err != nil {
-		if _, err := os.Stat(backupPath); err == nil {
-			os.Rename(backupPath, dbPath)
-		}
+		restore()
 		return fmt.Errorf("failed to open temporary database %s: %w", TempDbPath, err)
 	}
 	defer srcFile.Close()
 	dstFile, err := os.Create(dbPath)
 	if err != nil {
-		if _, err := os.Stat(backupPath); err == nil {
-			os.Rename(backupPath, dbPath)
-		}
+		restore()
 		return fmt.Errorf("failed to create database %s: %w", dbPath, err)
 	}
 	defer dstFile.Close()
 	if _, err := io.Copy(dstFile, srcFile); err != nil {
-		if _, err := os.Stat(backupPath); err == nil {
-			os.Rename(backupPath, dbPath)
-		}
+		restore()
 		return fmt.Errorf("failed to copy temporary database %s to %s: %w", TempDbPath, dbPath, err)
 	}
 	if err := dstFile.Sync(); err != nil {
-		if _, err := os.Stat(backupPath); err == nil {
-			os.Rename(backupPath, dbPath)
-		}
+		restore()
 		return fmt.Errorf("failed to sync database %s: %w", dbPath, err)
 	}
-	if debug {
-		log.Printf("Copied temporary database to %s", dbPath)
+	if err := dstFile.Close(); err != nil {
+		restore()
+		return fmt.Errorf("failed to close database %s: %w", dbPath, err)
+	}
+	// Убеждаемся, что копия открывается, прежде чем удалять временную базу
+	if err := CheckIntegrity(dbPath); err != nil {
+		restore()
+		return fmt.Errorf("database %s is not valid after copy, kept temporary database %s: %w", dbPath, TempDbPath, err)
+	}
+	logging.Debugf("Copied temporary database to %s", dbPath)
+	if backedUp {
+		if opts.BackupKeep > 1 {
+			if err := rotateBackups(dbPath+BackupSuffix, opts.BackupKeep); err != nil {
+				return fmt.Errorf("database %s replaced, previous database kept at %s: %w", dbPath, stagedPath, err)
+			}
+		}
+		if err := os.Rename(stagedPath, backupPath); err != nil {
+			return fmt.Errorf("database %s replaced, failed to move previous database %s to %s: %w", dbPath, stagedPath, backupPath, err)
+		}
+		logging.Debugf("Backed up database to %s", backupPath)
 	}
 	if err := os.Remove(TempDbPath); err != nil {
-		log.Printf("Warning: failed to remove temporary database %s: %v", TempDbPath, err)
+		logging.Warnf("failed to remove temporary database %s: %v", TempDbPath, err)
 	}
 	return nil
 }
 
+// rotatedBackupPath возвращает имя n-й копии базы: base.1, base.2, ...
+func rotatedBackupPath(base string, n int) string {
+	return fmt.Sprintf("%s.%d", base, n)
+}
+
+// rotateBackups сдвигает копии base.1 … base.(keep-1) на номер вперёд, удаляя base.keep,
+// чтобы освободить место для новой base.1.
+func rotateBackups(base string, keep int) error {
+	oldest := rotatedBackupPath(base, keep)
+	if err := os.Remove(oldest); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old backup %s: %w", oldest, err)
+	}
+	for n := keep - 1; n >= 1; n-- {
+		from, to := rotatedBackupPath(base, n), rotatedBackupPath(base, n+1)
+		if err := os.Rename(from, to); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to rotate backup %s to %s: %w", from, to, err)
+		}
+		logging.Debugf("Rotated backup %s to %s", from, to)
+	}
+	return nil
+}
+
+// checkNoShrink отказывает в замене базы, если во временной базе меньше строк, чем в текущей.
+func checkNoShrink(TempDbPath, dbPath string, force, debug bool) error {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return nil
+	}
+	oldRows, err := CountRows(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to count rows in %s: %w", dbPath, err)
+	}
+	newRows, err := CountRows(TempDbPath)
+	if err != nil {
+		return fmt.Errorf("failed to count rows in %s: %w", TempDbPath, err)
+	}
+	logging.Debugf("Row count check: %s has %d rows, %s has %d rows", dbPath, oldRows, TempDbPath, newRows)
+	if newRows >= oldRows {
+		return nil
+	}
+	if force {
+		logging.Warnf("replacing %s (%d rows) with %s (%d rows) because of --force", dbPath, oldRows, TempDbPath, newRows)
+		return nil
+	}
+	return fmt.Errorf("refusing to replace %s (%d rows) with %s (%d rows): new database is smaller, use --force to override", dbPath, oldRows, TempDbPath, newRows)
+}
+
+// CountRows возвращает суммарное число строк во всех пользовательских таблицах базы.
+func CountRows(dbPath string) (int64, error) {
+	conn, err := sql.Open("sqlite3", dbPath+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query(`SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return 0, err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, table := range tables {
+		var count int64
+		if err := conn.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, table)).Scan(&count); err != nil {
+			return 0, fmt.Errorf("failed to count table %s: %w", table, err)
+		}
+		total += count
+	}
+	return total, nil
+}
+
 // PrintHelp выводит справку по флагам.
 func PrintHelp() {
 	fmt.Println("Usage: bitget-history [options]")
 	fmt.Println("Options:")
 	fmt.Println("  -h, --help            Show this help message")
-	fmt.Println("  -p, --pair string     Trading pair (e.g., BTCUSDT) (default: BTCUSDT)")
-	fmt.Println("  -t, --type string     Data type: trades or depth (required)")
-	fmt.Println("  -m, --market string   Market type: spot, futures, or all (default: all)")
-	fmt.Println("  -s, --start string    Start date (YYYY-MM-DD) (default: 1 year ago)")
-	fmt.Println("  -e, --end string      End date (YYYY-MM-DD) (default: today)")
-	fmt.Println("  -T, --timeout int     Proxy check timeout in seconds (default: 3)")
+	fmt.Println("  --config path         Config file (default: $BITGET_HISTORY_CONFIG or config/config.yaml); relative paths in it resolve against its directory")
+	fmt.Println("                        Every config field can be overridden by BITGET_<SECTION>_<KEY>, e.g. BITGET_DATABASE_PATH")
+	fmt.Println("  -p, --pair string     Trading pair or comma-separated pairs (e.g., BTCUSDT,ETHUSDT) (default: BTCUSDT)")
+	fmt.Println("  --pairs-file path     File with one trading pair per line (overrides --pair)")
+	fmt.Println("  -t, --type string     Data type: trades, depth, kline or funding (futures only) (required)")
+	fmt.Println("  -m, --market string   Market type: spot, futures, all or a Bitget product code such as DMCBL (default: all)")
+	fmt.Println("  -s, --start string    Start date (YYYY-MM-DD or YYYYMMDD) (default: 1 year ago)")
+	fmt.Println("  -e, --end string      End date (YYYY-MM-DD or YYYYMMDD) (default: today)")
+	fmt.Println("  --date-format layout  Go layout for --start/--end instead of the default forms (e.g., 02.01.2006)")
+	fmt.Println("  -T, --timeout int     Proxy check timeout per attempt in seconds (default: 3)")
 	fmt.Println("  -d, --debug           Enable debug logging")
+	fmt.Println("  --proxy-file path     Use this proxy list as is, without downloading and checking free proxies")
+	fmt.Println("  --proxy-file-check    With --proxy-file, drop proxies that do not accept a TCP connection")
 	fmt.Println("  -X, --skip-exists 	 Skip downloading if file exists locally")
 	fmt.Println("  -S, --skip-download   Skip downloading and reimport existing local files")
 	fmt.Println("  -r, --repeat          Repeat process until all files are downloaded (for -S, --skip-exists only)")
 	fmt.Println("  -R, --recheck-exists  Recheck existing non-zero archives for corruptio")
+	fmt.Println("  --proxy-test          Check proxies, print the working count and the 10 fastest with latency, then exit")
+	fmt.Println("  --recheck-only        Recheck archives and report broken ones without redownloading")
+	fmt.Println("  --report path         Write a JSON run summary (downloaded/skipped/failed files, inserted rows per database,")
+	fmt.Println("                        failed URLs, timing); with a recheck or --validate, broken archives or failed databases")
+	fmt.Println("  --validate            Run integrity_check and foreign_key_check on every database, read-only")
+	fmt.Println("  --clean               Remove temporary databases, extracted archive data and backups beyond backup_keep (asks first)")
+	fmt.Println("  --yes                 With --clean, do not ask for confirmation")
+	fmt.Println("  --rebuild             Drop and rebuild depth tables of the selected market instead of importing incrementally")
+	fmt.Println("  --vacuum              Run VACUUM and ANALYZE on the database after import")
+	fmt.Println("  --kline-timeframe tf  Timeframe of imported --type kline candles: m1,m5,m15,m30,h1,h4,d1 (1m, 1H, 1D also accepted);")
+	fmt.Println("                        default: taken from the archive name (e.g. 20250101_1H.zip) or its directory, else m1")
+	fmt.Println("  --strict              Fail the import, keeping the working database, when a CSV has more or fewer rows")
+	fmt.Println("                        than were inserted and skipped (otherwise the mismatch is only logged)")
+	fmt.Println("  --no-shrink           Refuse to replace a database with one that has fewer rows")
+	fmt.Println("  --force               Replace the database even when --no-shrink would refuse")
+	fmt.Println("  --query               Print row count, time range and first/last price for --type/--pair/--market/--start/--end")
+	fmt.Println("  --query-csv           With --query, also dump the rows as CSV to stdout")
+	fmt.Println("  --list-missing        Print dates of the period without local archives or imported rows (one per line) and exit")
+	fmt.Println("  --prune-before date   Delete rows older than the date from the pair databases of --type, VACUUM and exit")
+	fmt.Println("  --normalize-timestamps Convert millisecond (and microsecond) timestamps in the pair databases of --type")
+	fmt.Println("                        to seconds and exit; rows duplicating existing ones are dropped, reruns change nothing")
+	fmt.Println("  --concurrency int     Limit concurrent URL checks, downloads and proxy checks (overrides the *_concurrency config values)")
+	fmt.Println("  --max-bps int         Limit total download speed in bytes/s (overrides downloader.max_bytes_per_sec; 0 disables)")
+	fmt.Println("  --quiet               Log only download progress and errors, not every file and attempt")
+	fmt.Println("  --compact             With --export-json --type depth, write spot and futures to one file with a market field")
+	fmt.Println("  --since-last          Start from the day of the last imported row for the pair (overrides --start)")
+	fmt.Println("  --dry-run             Report files, bytes and dates per market that would be downloaded; no download or import;")
+	fmt.Println("                        uses the existing working proxy list as is (build it with --proxy-test)")
+	fmt.Println("  --no-placeholders     Do not create empty placeholder files for missing depth archives")
+	fmt.Println("  --no-cache            Ignore cached URL checks in checked_urls and send HEAD requests (detects archives changed on the server)")
+	fmt.Println("  --head-only-check     Report live HTTP status per date/part without downloading or writing anything;")
+	fmt.Println("                        uses the existing working proxy list as is (build it with --proxy-test)")
+	fmt.Println("  --pair-discovery      List pairs with --type data on the server from its directory listing; without a listing,")
+	fmt.Println("                        probe the --pair/--pairs-file candidates on the --end date (default: yesterday)")
+	fmt.Println("  --server              Run HTTP server on :8080 (web UI is embedded; set BITGET_HISTORY_STATIC_DIR to serve it from disk);")
+	fmt.Println("                        every request is logged unless server.access_log is off (json for JSON lines)")
+	fmt.Println("  --log-file path       Also write logs to this file with rotation")
+	fmt.Println("  --log-max-size int    Rotate --log-file when it exceeds this size in MB (default: 100, 0 disables)")
+	fmt.Println("  --log-rotate-every d  Rotate --log-file when it is older than this duration (default: 24h, 0 disables)")
+	fmt.Println("  --log-max-backups int Number of rotated --log-file copies to keep (default: 7, 0 keeps all)")
+	fmt.Println("  --log-level level     Log level: error, warn, info or debug (overrides log.level)")
+	fmt.Println("  --log-format format   Log format: pretty, text or json (overrides log.format)")
+	fmt.Println("  --export-mt5          Export candles to MT5 CSV (depth mid-prices, or trade prices with --type trades);")
+	fmt.Println("                        with --type funding writes a plain funding-rate CSV")
+	fmt.Println("  --timeframes list     Comma-separated candle timeframes for --export-mt5 (default: m1; m1,m5,m15,m30,h1,h4,d1);")
+	fmt.Println("                        --timeframe is an alias")
+	fmt.Println("  --fill-gaps           Fill empty intervals in --export-mt5 with flat candles at the previous close")
+	fmt.Println("  --with-symbol         Prepend Symbol and Market columns to --export-mt5 output")
+	fmt.Println("  --append              With --export-mt5, append new candles to <pair>_<market>_<tf>.csv (no period in the name);")
+	fmt.Println("                        the last candle of the file is recomputed, earlier ones are kept as is")
+	fmt.Println("  --volume base|quote   Volume column of --export-mt5 trades candles: summed size_base (default) or volume_quote;")
+	fmt.Println("                        depth candles always use ask_volume + bid_volume in base currency")
+	fmt.Println("  --side-volume         Add BuyVolume and SellVolume columns (in --volume units) to --export-mt5 trades candles;")
+	fmt.Println("                        the default 7-column output stays MT5-compatible")
+	fmt.Println("  --volume-reference path  Reconcile daily trade volume in --export-mt5 against a date,volume CSV")
+	fmt.Println("  --volume-tolerance float Allowed relative daily volume difference (default: 0.01)")
+	fmt.Println("  --export-json         Export raw depth or trades rows to JSON")
+	fmt.Println("  --json-lines          Write newline-delimited JSON for --export-json")
+	fmt.Println("  --export-parquet      Export raw depth or trades rows to Parquet (Snappy-compressed, typed columns)")
+	fmt.Println("  --export-trades-depth Export trades with the nearest depth mid and spread (timestamp,trade_price,side,size,depth_mid,spread) to CSV")
+	fmt.Println("  --import-mapped path  Import a CSV with a custom column layout into the pair database")
+	fmt.Println("  --mapping string      Column mapping for --import-mapped (e.g., ts=0,price=2,side=3,size=4)")
+	fmt.Println("  --import-dir path     Import every zip under a directory (recursively) into the pair database of")
+	fmt.Println("                        --type, --market spot|futures and --pair, ignoring the Bitget layout")
 }
@@ -0,0 +1,102 @@
+package cmdutils
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSQLite создаёт базу SQLite с одной таблицей, чтобы копия проходила integrity_check.
+func writeSQLite(t *testing.T, path string) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec("CREATE TABLE t (v TEXT); INSERT INTO t VALUES ('new')"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertContent проверяет содержимое файла; пустое want — файла быть не должно.
+func assertContent(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if want == "" {
+		if !os.IsNotExist(err) {
+			t.Errorf("%s should not exist, err = %v", filepath.Base(path), err)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("%s: %v", filepath.Base(path), err)
+		return
+	}
+	if string(data) != want {
+		t.Errorf("%s = %q, want %q", filepath.Base(path), data, want)
+	}
+}
+
+func TestMoveTempDatabaseRotatesAfterSuccess(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "trades.db")
+	tempPath := filepath.Join(dir, "trades.db.new")
+	writeSQLite(t, tempPath)
+	writeFile(t, dbPath, "old")
+	writeFile(t, dbPath+".bak.1", "b1")
+	writeFile(t, dbPath+".bak.2", "b2")
+
+	if err := MoveTempDatabase(tempPath, dbPath, ".bak", MoveOptions{BackupKeep: 3}, false); err != nil {
+		t.Fatalf("MoveTempDatabase: %v", err)
+	}
+	if err := CheckIntegrity(dbPath); err != nil {
+		t.Errorf("replaced database: %v", err)
+	}
+	assertContent(t, dbPath+".bak.1", "old")
+	assertContent(t, dbPath+".bak.2", "b1")
+	assertContent(t, dbPath+".bak.3", "b2")
+	assertContent(t, dbPath+".bak.tmp", "")
+	assertContent(t, tempPath, "")
+}
+
+func TestMoveTempDatabaseKeepsBackupsOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "trades.db")
+	tempPath := filepath.Join(dir, "trades.db.new")
+	writeFile(t, tempPath, "not a database")
+	writeFile(t, dbPath, "old")
+	writeFile(t, dbPath+".bak.1", "b1")
+	writeFile(t, dbPath+".bak.2", "b2")
+
+	if err := MoveTempDatabase(tempPath, dbPath, ".bak", MoveOptions{BackupKeep: 3}, false); err == nil {
+		t.Fatal("MoveTempDatabase succeeded with a corrupt temporary database")
+	}
+	assertContent(t, dbPath, "old")
+	assertContent(t, dbPath+".bak.1", "b1")
+	assertContent(t, dbPath+".bak.2", "b2")
+	assertContent(t, dbPath+".bak.3", "")
+	assertContent(t, dbPath+".bak.tmp", "")
+	assertContent(t, tempPath, "not a database")
+}
+
+func TestMoveTempDatabaseLeavesStaleBackup(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "trades.db")
+	writeFile(t, dbPath+".bak", "stale")
+
+	// Базы ещё нет: копия от прошлого запуска не должна занять её место
+	if err := MoveTempDatabase(filepath.Join(dir, "missing.db"), dbPath, ".bak", MoveOptions{}, false); err == nil {
+		t.Fatal("MoveTempDatabase succeeded without a temporary database")
+	}
+	assertContent(t, dbPath, "")
+	assertContent(t, dbPath+".bak", "stale")
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// DefaultImportBatchSize — число строк в одной транзакции импорта, если оно не задано в конфиге.
+const DefaultImportBatchSize = 50000
+
+// batchInserter выполняет вставки порциями: каждые size строк транзакция коммитится
+// и открывается новая с заново подготовленным запросом.
+type batchInserter struct {
+	conn    *sql.DB
+	path    string // Для сообщений об ошибках
+	query   string
+	size    int
+	tx      *sql.Tx
+	stmt    *sql.Stmt
+	pending int
+	done    int // Строк в уже зафиксированных порциях
+}
+
+// newBatchInserter создаёт вставщик для запроса query с размером порции size.
+func newBatchInserter(conn *sql.DB, path, query string, size int) *batchInserter {
+	if size <= 0 {
+		size = DefaultImportBatchSize
+	}
+	return &batchInserter{conn: conn, path: path, query: query, size: size}
+}
+
+// exec вставляет строку, открывая транзакцию при необходимости.
+func (b *batchInserter) exec(args ...interface{}) (sql.Result, error) {
+	if b.tx == nil {
+		tx, err := b.conn.Begin()
+		if err != nil {
+			return nil, fmt.Errorf("failed to begin transaction in %s: %w", b.path, err)
+		}
+		stmt, err := tx.Prepare(b.query)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to prepare statement in %s: %w", b.path, err)
+		}
+		b.tx, b.stmt = tx, stmt
+	}
+	result, err := b.stmt.Exec(args...)
+	if err != nil {
+		return nil, err
+	}
+	b.pending++
+	return result, nil
+}
+
+// commitIfFull фиксирует порцию, если в ней набралось size строк.
+func (b *batchInserter) commitIfFull() error {
+	if b.pending < b.size {
+		return nil
+	}
+	return b.commit()
+}
+
+// commit фиксирует текущую порцию, если она есть.
+func (b *batchInserter) commit() error {
+	if b.tx == nil {
+		return nil
+	}
+	b.stmt.Close()
+	err := b.tx.Commit()
+	if err != nil {
+		b.tx.Rollback()
+	} else {
+		b.done += b.pending
+	}
+	b.tx, b.stmt, b.pending = nil, nil, 0
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction in %s: %w", b.path, err)
+	}
+	return nil
+}
+
+// rollback откатывает незафиксированную порцию.
+func (b *batchInserter) rollback() {
+	if b.tx == nil {
+		return
+	}
+	b.stmt.Close()
+	b.tx.Rollback()
+	b.tx, b.stmt, b.pending = nil, nil, 0
+}
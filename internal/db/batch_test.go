@@ -0,0 +1,47 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchInserterCountsCommittedBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batch.db")
+	conn, err := sql.Open("sqlite3", path+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	// Отложенная проверка внешнего ключа срывает коммит второй порции
+	if _, err := conn.Exec(`CREATE TABLE parent (id INTEGER PRIMARY KEY);
+		INSERT INTO parent VALUES (1);
+		CREATE TABLE child (v INTEGER, p INTEGER REFERENCES parent(id) DEFERRABLE INITIALLY DEFERRED)`); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := newBatchInserter(conn, path, "INSERT INTO child (v, p) VALUES (?, ?)", 2)
+	defer batch.rollback()
+	for v, p := range []int{1, 1, 1, 2} {
+		if err := batch.commitIfFull(); err != nil {
+			break
+		}
+		if _, err := batch.exec(v, p); err != nil {
+			t.Fatalf("exec: %v", err)
+		}
+	}
+	if err := batch.commit(); err == nil {
+		t.Fatal("commit succeeded despite a foreign key violation")
+	}
+	if batch.done != 2 {
+		t.Errorf("done = %d, want 2 rows of the first committed batch", batch.done)
+	}
+	var stored int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM child").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != batch.done {
+		t.Errorf("stored %d rows, batch reports %d", stored, batch.done)
+	}
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// csvDelimiters — поддерживаемые разделители в порядке предпочтения при равном счёте.
+var csvDelimiters = []rune{',', ';', '\t'}
+
+// newCSVReader создаёт csv.Reader с разделителем, определённым по первой строке r.
+func newCSVReader(r io.Reader) *csv.Reader {
+	buffered := bufio.NewReader(r)
+	// BOM в начале файла (выгрузки из Excel) иначе попадает в первое поле
+	if bom, _ := buffered.Peek(3); bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		buffered.Discard(3)
+	}
+	reader := csv.NewReader(buffered)
+	reader.Comma = detectDelimiter(buffered)
+	reader.FieldsPerRecord = -1 // Разрешить разное количество полей
+	return reader
+}
+
+// detectDelimiter выбирает разделитель, чаще всего встречающийся в первой строке.
+// Без подсказок (пустой файл, одна колонка) возвращает запятую.
+func detectDelimiter(r *bufio.Reader) rune {
+	// Peek не сдвигает позицию: строка останется для csv.Reader
+	peek, _ := r.Peek(4096)
+	if i := bytes.IndexByte(peek, '\n'); i >= 0 {
+		peek = peek[:i]
+	}
+	best, bestCount := ',', 0
+	for _, d := range csvDelimiters {
+		if n := bytes.Count(peek, []byte(string(d))); n > bestCount {
+			best, bestCount = d, n
+		}
+	}
+	return best
+}
+
+// isHeaderRow сообщает, является ли строка заголовком: в колонке timestamp не целое число.
+func isHeaderRow(record []string, timestampColumn int) bool {
+	if timestampColumn >= len(record) {
+		return true
+	}
+	_, err := strconv.ParseInt(strings.TrimSpace(record[timestampColumn]), 10, 64)
+	return err != nil
+}
+
+// headerColumns сопоставляет поля fields с колонками заголовка по имени без учёта регистра
+// и оформления ("Volume(Quote)" — это volume_quote), понимая короткие имена из fieldAliases.
+// Возвращает номер колонки для каждого поля (-1, если колонки нет) или nil, если заголовок
+// не называет хоть одно поле из required — тогда строки разбираются по позициям.
+func headerColumns(header []string, fields, required []string) []int {
+	byName := make(map[string]int, len(header))
+	for i, name := range header {
+		name = normalizeHeaderName(name)
+		if alias, ok := fieldAliases[name]; ok {
+			name = alias
+		}
+		if _, dup := byName[name]; !dup {
+			byName[name] = i
+		}
+	}
+	columns := make([]int, len(fields))
+	for i, field := range fields {
+		idx, ok := byName[field]
+		if !ok {
+			idx = -1
+		}
+		columns[i] = idx
+	}
+	for _, field := range required {
+		if _, ok := byName[field]; !ok {
+			return nil
+		}
+	}
+	return columns
+}
+
+// normalizeHeaderName приводит имя колонки к виду имени поля: нижний регистр, всё кроме букв
+// и цифр заменено одним подчёркиванием.
+func normalizeHeaderName(name string) string {
+	var b strings.Builder
+	sep := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if sep && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			sep = false
+		} else {
+			sep = true
+		}
+	}
+	return b.String()
+}
+
+// reorderRecord раскладывает значения строки в порядке полей по columns из headerColumns.
+// Отсутствующие колонки заполняются missing. При nil columns строка возвращается как есть.
+func reorderRecord(record []string, columns []int, missing string) []string {
+	if columns == nil {
+		return record
+	}
+	ordered := make([]string, len(columns))
+	for i, idx := range columns {
+		if idx >= 0 && idx < len(record) {
+			ordered[i] = record[idx]
+		} else {
+			ordered[i] = missing
+		}
+	}
+	return ordered
+}
+
+// lineCounter считает непустые строки, прочитанные через него, — исходные строки CSV для сверки
+// с результатом разбора. Поля в кавычках с переводом строки посчитаются несколькими строками.
+type lineCounter struct {
+	r      io.Reader
+	lines  int
+	inLine bool
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for _, b := range p[:n] {
+		switch b {
+		case '\n':
+			c.inLine = false
+		case '\r':
+		default:
+			if !c.inLine {
+				c.lines++
+				c.inLine = true
+			}
+		}
+	}
+	return n, err
+}
+
+// ErrRowCountMismatch — строк CSV больше или меньше, чем вставлено и пропущено при разборе.
+var ErrRowCountMismatch = errors.New("row count mismatch")
+
+// checkRowCount сверяет число строк данных CSV с суммой вставленных и пропущенных. Расхождение
+// значит, что строки потерялись до разбора; оно логируется, а при strict возвращается ошибкой.
+func checkRowCount(zipPath string, sourceRows, inserted, skipped int, strict bool) error {
+	if inserted+skipped == sourceRows {
+		return nil
+	}
+	err := fmt.Errorf("%w in %s: %d source rows, %d inserted, %d skipped", ErrRowCountMismatch, zipPath, sourceRows, inserted, skipped)
+	if strict {
+		return err
+	}
+	logging.Warnf("%v", err)
+	return nil
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestDetectDelimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  rune
+	}{
+		{"comma", "trade_id,timestamp,price,side\n1,1700000000,100,buy\n", ','},
+		{"semicolon", "trade_id;timestamp;price;side\n1;1700000000;100,5;buy\n", ';'},
+		{"tab", "trade_id\ttimestamp\tprice\n1\t1700000000\t100\n", '\t'},
+		{"headerless comma", "1,1700000000,100,buy,100,1\n", ','},
+		{"headerless semicolon", "1;1700000000;100;buy;100;1\n", ';'},
+		{"single column", "1700000000\n", ','},
+		{"empty", "", ','},
+	}
+	for _, tt := range tests {
+		if got := detectDelimiter(bufio.NewReader(strings.NewReader(tt.input))); got != tt.want {
+			t.Errorf("%s: detectDelimiter = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsHeaderRow(t *testing.T) {
+	tests := []struct {
+		name   string
+		record []string
+		column int
+		want   bool
+	}{
+		{"header", []string{"trade_id", "timestamp", "price"}, 1, true},
+		{"data", []string{"1", "1700000000", "100"}, 1, false},
+		{"data with spaces", []string{"1", " 1700000000 ", "100"}, 1, false},
+		{"milliseconds", []string{"1700000000123", "100"}, 0, false},
+		{"short row", []string{"1"}, 1, true},
+		{"fractional timestamp", []string{"1", "1700000000.5"}, 1, true},
+	}
+	for _, tt := range tests {
+		if got := isHeaderRow(tt.record, tt.column); got != tt.want {
+			t.Errorf("%s: isHeaderRow(%q, %d) = %v, want %v", tt.name, tt.record, tt.column, got, tt.want)
+		}
+	}
+}
+
+func TestNewCSVReaderDelimiters(t *testing.T) {
+	fixtures := map[string]string{
+		"comma":      "trade_id,timestamp,price\n1,1700000000,100\n",
+		"semicolon":  "trade_id;timestamp;price\n1;1700000000;100\n",
+		"headerless": "1,1700000000,100\n2,1700000001,101\n",
+		"bom":        "\xEF\xBB\xBFtrade_id,timestamp,price\n1,1700000000,100\n",
+	}
+	for name, content := range fixtures {
+		records, err := newCSVReader(strings.NewReader(content)).ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(records[0]) != 3 {
+			t.Errorf("%s: first row split into %d fields, want 3: %q", name, len(records[0]), records[0])
+		}
+		header := isHeaderRow(records[0], 1)
+		if header != (name != "headerless") {
+			t.Errorf("%s: isHeaderRow of first row = %v", name, header)
+		}
+		if name == "bom" && records[0][0] != "trade_id" {
+			t.Errorf("bom: first field %q, want trade_id", records[0][0])
+		}
+	}
+}
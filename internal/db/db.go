@@ -2,203 +2,331 @@ package db
 
 import (
 	"archive/zip"
+	"compress/gzip"
+	"context"
 	"database/sql"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/magf/bitget-history/internal/logging"
 	_ "github.com/mattn/go-sqlite3" // Драйвер SQLite
 	"github.com/tealeg/xlsx/v3"
 )
 
-// DB управляет подключением к SQLite и выгрузкой данных.
+// DB импортирует архивы в хранилище Store: SQLite или PostgreSQL.
 type DB struct {
-	conn     *sql.DB
-	path     string // Для логирования
-	dataType string // trades или depth
+	store    Store
+	conn     *sql.DB // Подключение SQLite для kline, funding, VACUUM и --rebuild; nil для PostgreSQL
+	path     string  // Для логирования
+	dataType string  // trades, depth, kline или funding
+	inserted int64   // Строк вставлено за время жизни подключения
+}
+
+// SchemaOptions — необязательные части схемы, включаемые конфигом.
+type SchemaOptions struct {
+	// PriceIndex создаёт индексы по цене (trades.price, ask_price/bid_price в depth).
+	// Ускоряют выборки по диапазону цен, но замедляют вставку при импорте.
+	PriceIndex bool
+
+	// BusyTimeout — сколько ждать снятия блокировки базы другим подключением; 0 — по умолчанию драйвера (5 с).
+	BusyTimeout time.Duration
+	// Synchronous — режим PRAGMA synchronous (FULL, NORMAL или OFF); пусто — по умолчанию SQLite (FULL).
+	Synchronous string
+}
+
+// ParseSynchronous проверяет режим PRAGMA synchronous и приводит его к верхнему регистру.
+// Пустая строка допустима и оставляет режим SQLite по умолчанию.
+func ParseSynchronous(mode string) (string, error) {
+	mode = strings.ToUpper(strings.TrimSpace(mode))
+	switch mode {
+	case "", "FULL", "NORMAL", "OFF":
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid synchronous mode: %s (must be FULL, NORMAL or OFF)", mode)
 }
 
 // NewDB создаёт новое подключение к SQLite и инициализирует схему.
-func NewDB(TempDbPath, dataType string) (*DB, error) {
+func NewDB(TempDbPath, dataType string, schema SchemaOptions) (*DB, error) {
 	// Проверяем, что путь не содержит шаблонов
 	if strings.Contains(TempDbPath, "%s") {
 		return nil, fmt.Errorf("invalid database path: %s contains placeholder %%s", TempDbPath)
 	}
-	if dataType != "trades" && dataType != "depth" {
-		return nil, fmt.Errorf("invalid data type: %s (must be trades or depth)", dataType)
+	if dataType != "trades" && dataType != "depth" && dataType != "kline" && dataType != "funding" {
+		return nil, fmt.Errorf("invalid data type: %s (must be trades, depth, kline or funding)", dataType)
 	}
-	log.Printf("Opening database: %s for %s", TempDbPath, dataType)
-	conn, err := sql.Open("sqlite3", TempDbPath+"?_journal_mode=WAL&cache=shared")
+	logging.Infof("Opening database: %s for %s", TempDbPath, dataType)
+	store, err := openSQLiteStore(TempDbPath, dataType, schema)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database %s: %w", TempDbPath, err)
+		return nil, err
 	}
+	if err := store.EnsureSchema(); err != nil {
+		store.conn.Close()
+		return nil, err
+	}
+	return &DB{store: store, conn: store.conn, path: TempDbPath, dataType: dataType}, nil
+}
 
-	_, err = conn.Exec("PRAGMA journal_mode=WAL;")
+// NewPostgresDB подключается к PostgreSQL по dsn и создаёт общие таблицы trades и depth.
+// Поддерживаются trades и depth; для trades market — код рынка (SPBL, UMCBL, ...), для depth
+// рынок берётся из пути архива. Строки пары пишутся с колонками pair и market.
+func NewPostgresDB(dsn, dataType, pair, market string, schema SchemaOptions) (*DB, error) {
+	if dataType != "trades" && dataType != "depth" {
+		return nil, fmt.Errorf("data type %s is not supported with PostgreSQL (only trades and depth)", dataType)
+	}
+	path := fmt.Sprintf("postgres %s %s", dataType, pair) // Без DSN: в нём может быть пароль
+	if dataType == "trades" {
+		path = fmt.Sprintf("postgres trades %s %s", market, pair)
+	}
+	logging.Infof("Opening database: %s", path)
+	store, err := openPostgresStore(dsn, pair, market, schema)
 	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("failed to set WAL mode for %s: %w", TempDbPath, err)
+		return nil, err
 	}
+	if err := store.EnsureSchema(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return &DB{store: store, path: path, dataType: dataType}, nil
+}
 
-	if dataType == "trades" {
-		_, err = conn.Exec(`
-			CREATE TABLE IF NOT EXISTS trades (
-				trade_id TEXT PRIMARY KEY,
-				timestamp INTEGER,
-				price REAL,
-				side TEXT,
-				volume_quote REAL,
-				size_base REAL
-			);
-			CREATE INDEX IF NOT EXISTS idx_trades_timestamp ON trades(timestamp);
-		`)
-		if err != nil {
-			conn.Close()
-			return nil, fmt.Errorf("failed to create trades schema in %s: %w", TempDbPath, err)
+// Inserted возвращает число строк, вставленных за время жизни подключения.
+func (db *DB) Inserted() int64 {
+	return db.inserted
+}
+
+// Name возвращает путь файла SQLite или описание базы PostgreSQL (без DSN).
+func (db *DB) Name() string {
+	return db.path
+}
+
+// requireSQLite возвращает ошибку, если операция op недоступна для хранилища базы.
+func (db *DB) requireSQLite(op string) error {
+	if db.conn == nil {
+		return fmt.Errorf("%s is supported for SQLite databases only, not %s", op, db.path)
+	}
+	return nil
+}
+
+// depthTables — таблицы depth по кодам рынков: "1" (spot) и "2" (futures).
+var depthTables = []string{"1", "2"}
+
+// depthTableSchema возвращает DDL таблицы depth и её индекса по timestamp.
+func depthTableSchema(table string) string {
+	return fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS "%[1]s" (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp INTEGER,
+			ask_price REAL,
+			bid_price REAL,
+			ask_volume REAL,
+			bid_volume REAL,
+			source_file TEXT
+		);
+		CREATE INDEX IF NOT EXISTS idx_%[1]s_timestamp ON "%[1]s"(timestamp);
+	`, table)
+}
+
+// EnsureColumn добавляет колонку в таблицу, если её нет (база создана старой версией).
+func EnsureColumn(conn *sql.DB, path, table, column, columnType string) error {
+	rows, err := conn.Query(fmt.Sprintf(`PRAGMA table_info("%s")`, table))
+	if err != nil {
+		return fmt.Errorf("failed to read columns of table %s in %s: %w", table, path, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return fmt.Errorf("failed to read columns of table %s in %s: %w", table, path, err)
 		}
-		log.Printf("Initialized trades schema in %s", TempDbPath)
-	} else {
-		_, err = conn.Exec(`
-			CREATE TABLE IF NOT EXISTS "1" (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				timestamp INTEGER,
-				ask_price REAL,
-				bid_price REAL,
-				ask_volume REAL,
-				bid_volume REAL
-			);
-			CREATE TABLE IF NOT EXISTS "2" (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				timestamp INTEGER,
-				ask_price REAL,
-				bid_price REAL,
-				ask_volume REAL,
-				bid_volume REAL
-			);
-			CREATE INDEX IF NOT EXISTS idx_1_timestamp ON "1"(timestamp);
-			CREATE INDEX IF NOT EXISTS idx_2_timestamp ON "2"(timestamp);
-		`)
-		if err != nil {
-			conn.Close()
-			return nil, fmt.Errorf("failed to create depth schema in %s: %w", TempDbPath, err)
+		if name == column {
+			return nil
 		}
-		log.Printf("Initialized depth schema in %s", TempDbPath)
 	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read columns of table %s in %s: %w", table, path, err)
+	}
+	rows.Close()
 
-	return &DB{conn: conn, path: TempDbPath, dataType: dataType}, nil
+	if _, err := conn.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN %s %s`, table, column, columnType)); err != nil {
+		return fmt.Errorf("failed to add column %s to table %s in %s: %w", column, table, path, err)
+	}
+	logging.Infof("Added column %s to table %s in %s", column, table, path)
+	return nil
 }
 
-// Close закрывает подключение к базе и синкает WAL.
-func (db *DB) Close() error {
-	log.Printf("Closing database: %s", db.path)
-	if db.conn != nil {
-		// Выполняем чекпоинт WAL
-		_, err := db.conn.Exec("PRAGMA wal_checkpoint(FULL);")
-		if err != nil {
-			log.Printf("Failed to perform WAL checkpoint for %s: %v", db.path, err)
-		} else {
-			log.Printf("WAL checkpoint successful for %s", db.path)
+// depthUniqueIndex возвращает DDL уникального индекса, по которому INSERT OR IGNORE отсекает повторы.
+func depthUniqueIndex(table string) string {
+	return fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS idx_%[1]s_unique ON "%[1]s"(timestamp, ask_price, bid_price, ask_volume, bid_volume)`, table)
+}
+
+// ensureDepthUnique создаёт уникальный индекс таблицы depth. Если индекса ещё нет
+// (база создана старой версией), сначала удаляет дубликаты, оставляя первую строку.
+func ensureDepthUnique(conn *sql.DB, path, table string) error {
+	var name string
+	err := conn.QueryRow(`SELECT name FROM sqlite_master WHERE type='index' AND name=?`, "idx_"+table+"_unique").Scan(&name)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check unique index on table %s in %s: %w", table, path, err)
+	}
+
+	result, err := conn.Exec(fmt.Sprintf(`
+		DELETE FROM "%[1]s" WHERE id NOT IN (
+			SELECT MIN(id) FROM "%[1]s"
+			GROUP BY timestamp, ask_price, bid_price, ask_volume, bid_volume
+		)
+	`, table))
+	if err != nil {
+		return fmt.Errorf("failed to remove duplicate rows from table %s in %s: %w", table, path, err)
+	}
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		logging.Infof("Removed %d duplicate rows from table %s in %s", removed, table, path)
+	}
+	if _, err := conn.Exec(depthUniqueIndex(table)); err != nil {
+		return fmt.Errorf("failed to create unique index on table %s in %s: %w", table, path, err)
+	}
+	return nil
+}
+
+// ResetDepthTables удаляет и заново создаёт указанные таблицы depth (для --rebuild).
+func (db *DB) ResetDepthTables(tables ...string) error {
+	if db.dataType != "depth" {
+		return fmt.Errorf("cannot reset depth tables in %s database %s", db.dataType, db.path)
+	}
+	if err := db.requireSQLite("rebuilding depth tables"); err != nil {
+		return err
+	}
+	for _, table := range tables {
+		logging.Infof("Dropping depth table %s in %s", table, db.path)
+		if _, err := db.conn.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, table)); err != nil {
+			return fmt.Errorf("failed to drop table %s in %s: %w", table, db.path, err)
+		}
+		if _, err := db.conn.Exec(depthTableSchema(table)); err != nil {
+			return fmt.Errorf("failed to recreate table %s in %s: %w", table, db.path, err)
+		}
+		if _, err := db.conn.Exec(depthUniqueIndex(table)); err != nil {
+			return fmt.Errorf("failed to create unique index on table %s in %s: %w", table, db.path, err)
 		}
-		err = db.conn.Close()
-		db.conn = nil
+		logging.Infof("Recreated table %s in %s", table, db.path)
+	}
+	return nil
+}
+
+// Close закрывает хранилище; для SQLite перед этим синкает WAL.
+func (db *DB) Close() error {
+	logging.Infof("Closing database: %s", db.path)
+	if db.store != nil {
+		err := db.store.Close()
+		db.store, db.conn = nil, nil
 		if err != nil {
-			return fmt.Errorf("failed to close database %s: %w", db.path, err)
+			return err
 		}
 	}
-	log.Printf("Database %s closed successfully", db.path)
+	logging.Infof("Database %s closed successfully", db.path)
 	return nil
 }
 
+// DefaultTmpRawDir — каталог для распакованных CSV, если он не задан в конфиге.
+const DefaultTmpRawDir = "/tmp/bitget-history/raw"
+
+// ImportOptions задаёт параметры импорта Zip-файлов.
+type ImportOptions struct {
+	TmpRawDir       string        // Каталог, в котором создаётся временный каталог запуска для CSV (по умолчанию DefaultTmpRawDir)
+	Vacuum          bool          // Выполнить VACUUM и ANALYZE после импорта
+	VacuumThreshold int64         // Автоматический VACUUM, если вставлено не меньше строк (0 — отключено)
+	BatchSize       int           // Строк в одной транзакции (по умолчанию DefaultImportBatchSize)
+	Schema          SchemaOptions // Необязательные индексы, создаваемые при открытии базы
+	Workers         int           // Горутин распаковки архивов; больше 1 — распаковка параллельно с импортом
+	MarketCode      string        // Код рынка всех архивов вместо определяемого по пути (архивы вне структуры каталогов Bitget)
+	Strict          bool          // Прерывать импорт, если строк CSV не столько, сколько вставлено и пропущено (ErrRowCountMismatch)
+
+	KlineTimeframe string // Таймфрейм свечей kline (см. ParseKlineTimeframe); пусто — из пути архива или DefaultKlineTimeframe
+}
+
 // ProcessZipFiles обрабатывает Zip-файлы и выгружает данные в SQLite.
-func (db *DB) ProcessZipFiles(zipFiles []string, debug bool) error {
-	tmpRawDataDir := "/tmp/bitget-history/raw"
-	// Очищаем /tmp/bitget-history/raw
-	log.Printf("Cleaning temporary directory: %s", tmpRawDataDir)
-	if err := os.RemoveAll(tmpRawDataDir); err != nil {
-		return fmt.Errorf("failed to clean %s: %w", tmpRawDataDir, err)
+// CSV распаковываются во временный подкаталог TmpRawDir, который удаляется по завершении (кроме режима debug).
+// При отмене ctx обработка останавливается между файлами и возвращается ошибка.
+func (db *DB) ProcessZipFiles(ctx context.Context, zipFiles []string, opts ImportOptions, debug bool) error {
+	tmpRawBase := opts.TmpRawDir
+	if tmpRawBase == "" {
+		tmpRawBase = DefaultTmpRawDir
 	}
-	if err := os.MkdirAll(tmpRawDataDir, 0755); err != nil {
-		return fmt.Errorf("failed to create %s: %w", tmpRawDataDir, err)
+	// Каждый запуск распаковывает в свой каталог, чтобы параллельные импорты не мешали друг другу
+	if err := os.MkdirAll(tmpRawBase, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpRawBase, err)
 	}
-
-	// Дропаем таблицы перед обработкой (depth only)
-	if db.dataType == "depth" {
-		log.Printf("Dropping depth tables in %s", db.path)
-		_, err := db.conn.Exec(`DROP TABLE IF EXISTS "1"`)
-		if err != nil {
-			return fmt.Errorf("failed to drop table 1 in %s: %w", db.path, err)
+	tmpRawDataDir, err := os.MkdirTemp(tmpRawBase, "run-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory in %s: %w", tmpRawBase, err)
+	}
+	logging.Debugf("Extracting archives to %s", tmpRawDataDir)
+	defer func() {
+		if debug {
+			logging.Debugf("Leaving temporary directory %s for debugging", tmpRawDataDir)
+			return
 		}
-		_, err = db.conn.Exec(`DROP TABLE IF EXISTS "2"`)
-		if err != nil {
-			return fmt.Errorf("failed to drop table 2 in %s: %w", db.path, err)
-		}
-		// Пересоздаём таблицы
-		_, err = db.conn.Exec(`
-			CREATE TABLE "1" (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				timestamp INTEGER,
-				ask_price REAL,
-				bid_price REAL,
-				ask_volume REAL,
-				bid_volume REAL
-			)
-		`)
-		if err != nil {
-			return fmt.Errorf("failed to recreate table 1 in %s: %w", db.path, err)
-		}
-		log.Printf("Recreated table 1 in %s", db.path)
-		_, err = db.conn.Exec(`
-			CREATE TABLE "2" (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				timestamp INTEGER,
-				ask_price REAL,
-				bid_price REAL,
-				ask_volume REAL,
-				bid_volume REAL
-			)
-		`)
-		if err != nil {
-			return fmt.Errorf("failed to recreate table 2 in %s: %w", db.path, err)
+		if err := os.RemoveAll(tmpRawDataDir); err != nil {
+			logging.Errorf("Failed to remove temporary directory %s: %v", tmpRawDataDir, err)
 		}
-		log.Printf("Recreated table 2 in %s", db.path)
-		// Пересоздаём индексы
-		_, err = db.conn.Exec(`CREATE INDEX idx_1_timestamp ON "1"(timestamp)`)
-		if err != nil {
-			return fmt.Errorf("failed to recreate index idx_1_timestamp in %s: %w", db.path, err)
+	}()
+
+	if opts.Workers > 1 {
+		if err := db.processZipsParallel(ctx, zipFiles, tmpRawDataDir, opts, debug); err != nil {
+			return err
 		}
-		log.Printf("Recreated index idx_1_timestamp in %s", db.path)
-		_, err = db.conn.Exec(`CREATE INDEX idx_2_timestamp ON "2"(timestamp)`)
-		if err != nil {
-			return fmt.Errorf("failed to recreate index idx_2_timestamp in %s: %w", db.path, err)
+	} else if err := db.processZipsSerial(ctx, zipFiles, tmpRawDataDir, opts, debug); err != nil {
+		return err
+	}
+
+	if db.conn != nil && (opts.Vacuum || (opts.VacuumThreshold > 0 && db.inserted >= opts.VacuumThreshold)) {
+		if err := db.Vacuum(); err != nil {
+			logging.Errorf("Failed to vacuum %s: %v", db.path, err)
 		}
-		log.Printf("Recreated index idx_2_timestamp in %s", db.path)
 	}
+	return nil
+}
 
+// processZipsSerial распаковывает и импортирует архивы по одному.
+func (db *DB) processZipsSerial(ctx context.Context, zipFiles []string, tmpRawDataDir string, opts ImportOptions, debug bool) error {
 	for _, zipPath := range zipFiles {
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stdout)
+			return fmt.Errorf("import into %s interrupted: %w", db.path, ctx.Err())
+		}
+
 		// Проверяем размер файла
 		fileInfo, err := os.Stat(zipPath)
 		if err != nil {
 			return fmt.Errorf("failed to stat file %s: %w", zipPath, err)
 		}
 		if fileInfo.Size() == 0 {
-			if debug {
-				log.Printf("Skipping empty file %s (0 bytes)", zipPath)
-			}
+			logging.Debugf("Skipping empty file %s (0 bytes)", zipPath)
 			continue // Пропускаем пустой файл
 		}
 
 		if debug {
-			log.Printf("Processing zip file: %s", zipPath)
-		} else {
+			logging.Debugf("Processing zip file: %s", zipPath)
+		} else if logging.Pretty() {
 			fmt.Fprintf(os.Stdout, "\r  Processing zip file: %-70s                    \r", zipPath)
 		}
 
-		if err := db.processSingleZip(zipPath, tmpRawDataDir, debug); err != nil {
-			log.Printf("Failed to process %s: %v", zipPath, err)
+		if err := db.processSingleZip(zipPath, tmpRawDataDir, opts, debug); err != nil {
+			if errors.Is(err, ErrRowCountMismatch) {
+				fmt.Fprintln(os.Stdout)
+				return err // Только при opts.Strict
+			}
+			logging.Errorf("Failed to process %s: %v", zipPath, err)
 			continue // Продолжаем с другими файлами
 		}
 	}
@@ -207,12 +335,120 @@ func (db *DB) ProcessZipFiles(zipFiles []string, debug bool) error {
 	return nil
 }
 
+// Vacuum переносит WAL в основной файл, выполняет VACUUM и ANALYZE и логирует изменение размера.
+func (db *DB) Vacuum() error {
+	if err := db.requireSQLite("VACUUM"); err != nil {
+		return err
+	}
+	if _, err := db.conn.Exec("PRAGMA wal_checkpoint(TRUNCATE);"); err != nil {
+		return fmt.Errorf("failed to checkpoint WAL for %s: %w", db.path, err)
+	}
+	before := fileSize(db.path)
+	logging.Infof("Running VACUUM on %s (%d bytes, %d rows inserted)", db.path, before, db.inserted)
+	start := time.Now()
+	if _, err := db.conn.Exec("VACUUM;"); err != nil {
+		return fmt.Errorf("failed to vacuum %s: %w", db.path, err)
+	}
+	if _, err := db.conn.Exec("ANALYZE;"); err != nil {
+		return fmt.Errorf("failed to analyze %s: %w", db.path, err)
+	}
+	after := fileSize(db.path)
+	logging.Infof("VACUUM and ANALYZE completed for %s in %v: %d -> %d bytes (reduced by %d bytes)", db.path, time.Since(start), before, after, before-after)
+	return nil
+}
+
+// DeleteBefore удаляет из таблиц tables строки с timestamp раньше cutoff (секунды) одной
+// транзакцией и возвращает число удалённых строк. Место в файле освобождает Vacuum.
+func (db *DB) DeleteBefore(cutoff int64, tables ...string) (int64, error) {
+	if err := db.requireSQLite("pruning"); err != nil {
+		return 0, err
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction for %s: %w", db.path, err)
+	}
+	defer tx.Rollback() // После Commit ничего не делает
+	var deleted int64
+	for _, table := range tables {
+		result, err := tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE timestamp < ?`, table), cutoff)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete old rows from %s in %s: %w", table, db.path, err)
+		}
+		n, _ := result.RowsAffected()
+		logging.Debugf("Deleted %d rows older than %d from %s in %s", n, cutoff, table, db.path)
+		deleted += n
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit deletion in %s: %w", db.path, err)
+	}
+	return deleted, nil
+}
+
+// NormalizeTimestamps переводит в секунды timestamp строк таблиц tables, записанные в
+// миллисекундах или микросекундах (по порогам NormalizeTimestamp), одной транзакцией.
+// Строки, которые после перевода совпали бы с уже записанными в секундах, удаляются.
+// Возвращает число переведённых и удалённых строк; повторный вызов ничего не меняет.
+func (db *DB) NormalizeTimestamps(tables ...string) (converted, dropped int64, err error) {
+	if err := db.requireSQLite("timestamp normalization"); err != nil {
+		return 0, 0, err
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction for %s: %w", db.path, err)
+	}
+	defer tx.Rollback() // После Commit ничего не делает
+	for _, table := range tables {
+		// Целочисленное деление в SQLite, как и в Go, отбрасывает дробную часть к нулю
+		result, err := tx.Exec(fmt.Sprintf(`UPDATE OR IGNORE "%s"
+			SET timestamp = CASE WHEN abs(timestamp) >= ? THEN timestamp / 1000000 ELSE timestamp / 1000 END
+			WHERE abs(timestamp) >= ?`, table), int64(microsecondThreshold), int64(millisecondThreshold))
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to normalize timestamps in %s of %s: %w", table, db.path, err)
+		}
+		n, _ := result.RowsAffected()
+		converted += n
+
+		// Остались только строки, пропущенные из-за уникального ключа: это повторы строк в секундах
+		result, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE abs(timestamp) >= ?`, table), int64(millisecondThreshold))
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to delete duplicate rows from %s in %s: %w", table, db.path, err)
+		}
+		d, _ := result.RowsAffected()
+		dropped += d
+		logging.Debugf("Normalized %d timestamps in %s of %s, dropped %d duplicates", n, table, db.path, d)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit timestamp normalization in %s: %w", db.path, err)
+	}
+	return converted, dropped, nil
+}
+
+// fileSize возвращает размер файла или 0, если его нет.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
 // processSingleZip обрабатывает один Zip-файл.
-func (db *DB) processSingleZip(zipPath, tmpRawDataDir string, debug bool) error {
+func (db *DB) processSingleZip(zipPath, tmpRawDataDir string, opts ImportOptions, debug bool) error {
+	csvPaths, marketCode, err := extractZip(zipPath, tmpRawDataDir, opts, debug)
+	if err != nil {
+		return err
+	}
+	return db.importExtracted(zipPath, csvPaths, marketCode, opts, debug)
+}
+
+// extractZip распаковывает все CSV из Zip-файла (конвертируя gzip и XLSX) в tmpRawDataDir
+// и возвращает пути к CSV в порядке имён записей архива и код рынка. Не обращается к базе,
+// поэтому безопасна для параллельного вызова.
+func extractZip(zipPath, tmpRawDataDir string, opts ImportOptions, debug bool) (csvPaths []string, marketCode string, err error) {
 	// Открываем Zip
 	zipReader, err := zip.OpenReader(zipPath)
 	if err != nil {
-		return fmt.Errorf("failed to open zip %s: %w", zipPath, err)
+		return nil, "", fmt.Errorf("failed to open zip %s: %w", zipPath, err)
 	}
 	defer zipReader.Close()
 
@@ -220,72 +456,113 @@ func (db *DB) processSingleZip(zipPath, tmpRawDataDir string, debug bool) error
 	for _, f := range zipReader.File {
 		rc, err := f.Open()
 		if err != nil {
-			return fmt.Errorf("corrupted zip %s: failed to open file %s: %w", zipPath, f.Name, err)
+			return nil, "", fmt.Errorf("corrupted zip %s: failed to open file %s: %w", zipPath, f.Name, err)
 		}
 		rc.Close()
 	}
 
-	// Ищем CSV или XLSX
-	var csvFile *zip.File
-	var xlsxFile *zip.File
+	// Собираем CSV, сжатые gzip CSV и XLSX; архив может быть разбит на несколько файлов (например, по часам)
+	var entries []*zip.File
 	for _, f := range zipReader.File {
-		if strings.HasSuffix(strings.ToLower(f.Name), ".csv") {
-			csvFile = f
-			break
-		}
-		if strings.HasSuffix(strings.ToLower(f.Name), ".xlsx") {
-			xlsxFile = f
-			break
+		name := strings.ToLower(f.Name)
+		if strings.HasSuffix(name, ".csv") || strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".xlsx") {
+			entries = append(entries, f)
 		}
 	}
+	if len(entries) == 0 {
+		return nil, "", fmt.Errorf("no CSV file found in %s (and no .gz or XLSX to convert)", zipPath)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
 
 	// Формируем путь для CSV
 	zipBase := filepath.Base(zipPath)             // Например, "20250502_001.zip"
 	zipBase = strings.TrimSuffix(zipBase, ".zip") // "20250502_001"
-	pathParts := strings.Split(zipPath, string(os.PathSeparator))
-	marketCode := "unknown"
-	for i, part := range pathParts {
-		if part == "BTCUSDT" && i+1 < len(pathParts) {
-			marketCode = pathParts[i+1] // "1", "2", "SPBL", "UMCBL"
-			break
+	marketCode = opts.MarketCode
+	if marketCode == "" {
+		marketCode = marketCodeFromPath(zipPath) // "1", "2", "SPBL", "UMCBL"
+	}
+	if marketCode == "" {
+		return nil, "", fmt.Errorf("cannot determine market code from path %s (expected trades|kline|funding/<MARKET>/<PAIR>/ or depth/<PAIR>/<CODE>/)", zipPath)
+	}
+
+	for i, f := range entries {
+		csvFileName := fmt.Sprintf("%s_%s.csv", marketCode, zipBase)
+		if len(entries) > 1 {
+			csvFileName = fmt.Sprintf("%s_%s_%03d.csv", marketCode, zipBase, i)
+		}
+		csvPath := filepath.Join(tmpRawDataDir, csvFileName)
+		if err := extractEntry(f, zipPath, csvPath, tmpRawDataDir, debug); err != nil {
+			return nil, "", err
 		}
+		csvPaths = append(csvPaths, csvPath)
+	}
+	if len(entries) > 1 {
+		logging.Debugf("Extracted %d CSV entries from %s", len(entries), zipPath)
 	}
-	csvFileName := fmt.Sprintf("%s_%s.csv", marketCode, zipBase)
-	csvPath := filepath.Join(tmpRawDataDir, csvFileName)
+	return csvPaths, marketCode, nil
+}
 
-	// Если CSV найден, извлекаем его
-	if csvFile != nil {
-		if err := extractFile(csvFile, csvPath); err != nil {
-			return fmt.Errorf("failed to extract CSV from %s: %w", zipPath, err)
+// extractEntry распаковывает одну запись архива в csvPath: CSV как есть, gzip — распаковывая,
+// XLSX — конвертируя в CSV.
+func extractEntry(f *zip.File, zipPath, csvPath, tmpRawDataDir string, debug bool) error {
+	switch name := strings.ToLower(f.Name); {
+	case strings.HasSuffix(name, ".csv"):
+		if err := extractFile(f, csvPath); err != nil {
+			return fmt.Errorf("failed to extract CSV %s from %s: %w", f.Name, zipPath, err)
 		}
-		if debug {
-			log.Printf("Extracted CSV: %s", csvPath)
+		logging.Debugf("Extracted CSV: %s", csvPath)
+	case strings.HasSuffix(name, ".gz"):
+		// Распаковываем gzip прямо в CSV
+		if err := extractGzipFile(f, csvPath); err != nil {
+			return fmt.Errorf("failed to extract gzip CSV %s from %s: %w", f.Name, zipPath, err)
 		}
-	} else if xlsxFile != nil {
-		// Извлекаем XLSX
-		xlsxPath := filepath.Join(tmpRawDataDir, xlsxFile.Name)
-		if err := extractFile(xlsxFile, xlsxPath); err != nil {
-			return fmt.Errorf("failed to extract XLSX from %s: %w", zipPath, err)
+		logging.Debugf("Extracted gzip CSV: %s", csvPath)
+	default:
+		// Извлекаем XLSX; имя с префиксом CSV, чтобы параллельные распаковки не пересекались
+		xlsxPath := strings.TrimSuffix(csvPath, ".csv") + "_" + filepath.Base(f.Name)
+		if err := extractFile(f, xlsxPath); err != nil {
+			return fmt.Errorf("failed to extract XLSX %s from %s: %w", f.Name, zipPath, err)
 		}
 		// Конвертируем XLSX в CSV и удаляем XLSX
 		if err := convertXLSXtoCSV(xlsxPath, csvPath, debug); err != nil {
 			return fmt.Errorf("failed to convert XLSX to CSV for %s: %w", zipPath, err)
 		}
-		if debug {
-			log.Printf("Converted XLSX to CSV: %s", csvPath)
+		logging.Debugf("Converted XLSX to CSV: %s", csvPath)
+	}
+	return nil
+}
+
+// importExtracted импортирует распакованные CSV архива по порядку в таблицу, соответствующую типу базы.
+func (db *DB) importExtracted(zipPath string, csvPaths []string, marketCode string, opts ImportOptions, debug bool) error {
+	for _, csvPath := range csvPaths {
+		if err := db.importCSV(zipPath, csvPath, marketCode, opts, debug); err != nil {
+			return err
 		}
-	} else {
-		return fmt.Errorf("no CSV file found in %s (and no XLSX to convert)", zipPath)
 	}
+	if len(csvPaths) > 1 {
+		logging.Infof("Imported %d CSV entries from %s", len(csvPaths), zipPath)
+	}
+	return nil
+}
 
+// importCSV импортирует один распакованный CSV в таблицу, соответствующую типу базы.
+func (db *DB) importCSV(zipPath, csvPath, marketCode string, opts ImportOptions, debug bool) error {
 	// Обрабатываем CSV
 	if db.dataType == "depth" {
 		tableName := marketCode // "1" или "2"
-		if err := db.importCSVtoDepth(zipPath, csvPath, tableName, debug); err != nil {
+		if err := db.importCSVtoDepth(zipPath, csvPath, tableName, opts.BatchSize, opts.Strict, debug); err != nil {
 			return fmt.Errorf("failed to import CSV to depth for %s: %w", zipPath, err)
 		}
+	} else if db.dataType == "kline" {
+		if err := db.importCSVtoKline(zipPath, csvPath, klineTimeframe(zipPath, opts.KlineTimeframe), opts.BatchSize, opts.Strict, debug); err != nil {
+			return fmt.Errorf("failed to import CSV to kline for %s: %w", zipPath, err)
+		}
+	} else if db.dataType == "funding" {
+		if err := db.importCSVtoFunding(zipPath, csvPath, opts.BatchSize, opts.Strict, debug); err != nil {
+			return fmt.Errorf("failed to import CSV to funding for %s: %w", zipPath, err)
+		}
 	} else {
-		if err := db.importCSVtoTrades(zipPath, csvPath, debug); err != nil {
+		if err := db.importCSVtoTrades(zipPath, csvPath, opts.BatchSize, opts.Strict, debug); err != nil {
 			return fmt.Errorf("failed to import CSV to trades for %s: %w", zipPath, err)
 		}
 	}
@@ -293,6 +570,24 @@ func (db *DB) processSingleZip(zipPath, tmpRawDataDir string, debug bool) error
 	return nil
 }
 
+// marketCodeFromPath определяет код рынка по структуре каталогов архива:
+// trades|kline|funding/<MARKET>/<PAIR>/<file>.zip → MARKET, depth/<PAIR>/<CODE>/<file>.zip → CODE.
+// Возвращает пустую строку, если путь не соответствует структуре.
+func marketCodeFromPath(zipPath string) string {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(zipPath)), "/")
+	n := len(parts)
+	if n < 4 {
+		return ""
+	}
+	switch parts[n-4] {
+	case "trades", "kline", "funding":
+		return parts[n-3]
+	case "depth":
+		return parts[n-2]
+	}
+	return ""
+}
+
 // extractFile извлекает файл из Zip в указанный путь.
 func extractFile(file *zip.File, destPath string) error {
 	fileReader, err := file.Open()
@@ -315,20 +610,52 @@ func extractFile(file *zip.File, destPath string) error {
 	return err
 }
 
-// convertXLSXtoCSV конвертирует XLSX в CSV и удаляет исходный XLSX-файл.
+// extractGzipFile распаковывает сжатый gzip файл из Zip в указанный путь.
+func extractGzipFile(file *zip.File, destPath string) error {
+	fileReader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer fileReader.Close()
+
+	gzReader, err := gzip.NewReader(fileReader)
+	if err != nil {
+		return fmt.Errorf("invalid gzip data in %s: %w", file.Name, err)
+	}
+	defer gzReader.Close()
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+
+	outFile, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	_, err = io.Copy(outFile, gzReader)
+	return err
+}
+
+// convertXLSXtoCSV конвертирует XLSX в CSV и удаляет исходный XLSX-файл. Ячейки книги хранятся
+// на диске, а строки обходятся по одной, поэтому большие книги не занимают память целиком.
+// Строка с нечитаемой ячейкой пропускается, остальные конвертируются.
 func convertXLSXtoCSV(xlsxPath, csvPath string, debug bool) error {
-	// Читаем XLSX в трёхмерный слайс
-	rows, err := xlsx.FileToSlice(xlsxPath)
+	workbook, err := xlsx.OpenFile(xlsxPath, xlsx.UseDiskVCellStore)
 	if err != nil {
 		return fmt.Errorf("failed to read XLSX %s: %w", xlsxPath, err)
 	}
-	if len(rows) == 0 {
+	if len(workbook.Sheets) == 0 {
 		return fmt.Errorf("no sheets found in XLSX %s", xlsxPath)
 	}
+	for _, sheet := range workbook.Sheets {
+		defer sheet.Close() // Удаляет дисковое хранилище ячеек
+	}
 
 	// Берём первый лист
-	sheetRows := rows[0]
-	if len(sheetRows) == 0 {
+	sheet := workbook.Sheets[0]
+	if sheet.MaxRow == 0 {
 		return fmt.Errorf("no rows found in first sheet of XLSX %s", xlsxPath)
 	}
 
@@ -340,14 +667,17 @@ func convertXLSXtoCSV(xlsxPath, csvPath string, debug bool) error {
 	defer csvFile.Close()
 
 	writer := csv.NewWriter(csvFile)
-	defer writer.Flush()
 
 	// Пишем заголовок в зависимости от типа данных
 	isDepth := strings.Contains(strings.ToLower(xlsxPath), "depth")
+	isKline := strings.Contains(strings.ToLower(xlsxPath), "kline")
 	var header []string
 	numColumns := 5
 	if isDepth {
 		header = []string{"timestamp", "ask_price", "bid_price", "ask_volume", "bid_volume"}
+	} else if isKline {
+		header = []string{"timestamp", "open", "high", "low", "close", "volume"}
+		numColumns = 6
 	} else {
 		header = []string{"trade_id", "timestamp", "price", "side", "volume_quote", "size_base"}
 		numColumns = 6
@@ -357,9 +687,31 @@ func convertXLSXtoCSV(xlsxPath, csvPath string, debug bool) error {
 	}
 
 	// Обрабатываем строки (пропускаем заголовок)
-	for rowIdx, row := range sheetRows {
+	rowIdx := -1
+	skipped := 0
+	err = sheet.ForEachRow(func(r *xlsx.Row) error {
+		rowIdx++
 		if rowIdx == 0 {
-			continue // Пропускаем заголовок
+			return nil // Пропускаем заголовок
+		}
+
+		// Читаем только нужные столбцы
+		row := make([]string, 0, numColumns)
+		cellErr := r.ForEachCell(func(c *xlsx.Cell) error {
+			if len(row) >= numColumns {
+				return nil
+			}
+			value, err := c.FormattedValue()
+			if err != nil {
+				return err
+			}
+			row = append(row, value)
+			return nil
+		})
+		if cellErr != nil {
+			logging.Warnf("Skipping row %d in XLSX %s: %v", rowIdx+1, xlsxPath, cellErr)
+			skipped++
+			return nil
 		}
 
 		// Убедимся, что строка имеет достаточно столбцов
@@ -372,28 +724,32 @@ func convertXLSXtoCSV(xlsxPath, csvPath string, debug bool) error {
 		for colIdx := 0; colIdx < numColumns; colIdx++ {
 			cellValue := strings.TrimSpace(row[colIdx])
 			// Исправляем числовые поля
-			if (isDepth && colIdx > 0) || (!isDepth && (colIdx == 2 || colIdx == 4 || colIdx == 5)) {
-				if strings.HasSuffix(cellValue, ".") {
-					cellValue += "0"
-				}
-				if cellValue == "" {
-					cellValue = "0.0"
-				}
+			if ((isDepth || isKline) && colIdx > 0) || (!isDepth && !isKline && (colIdx == 2 || colIdx == 4 || colIdx == 5)) {
+				cellValue = normalizeNumeric(cellValue)
 			}
 			record[colIdx] = cellValue
 		}
 
 		// Пропускаем пустые строки
 		if strings.Join(record, "") == "" {
-			continue
+			return nil
 		}
 
 		// Записываем строку в CSV
 		if err := writer.Write(record); err != nil {
-			log.Printf("Failed to write row %d to CSV %s: %v", rowIdx+1, csvPath, err)
-			continue
+			logging.Errorf("Failed to write row %d to CSV %s: %v", rowIdx+1, csvPath, err)
+			skipped++
 		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read rows of XLSX %s: %w", xlsxPath, err)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV %s: %w", csvPath, err)
 	}
+	logging.Debugf("Converted %d rows of XLSX %s, skipped %d", rowIdx, xlsxPath, skipped)
 
 	// Удаляем XLSX-файл после успешной конвертации
 	removeFile(xlsxPath, debug)
@@ -401,8 +757,9 @@ func convertXLSXtoCSV(xlsxPath, csvPath string, debug bool) error {
 	return nil
 }
 
-// importCSVtoTrades импортирует CSV в таблицу trades и удаляет CSV-файл.
-func (db *DB) importCSVtoTrades(zipPath, csvPath string, debug bool) error {
+// importCSVtoTrades импортирует CSV в таблицу trades и удаляет CSV-файл. Число строк CSV сверяется
+// с вставленными и пропущенными (checkRowCount).
+func (db *DB) importCSVtoTrades(zipPath, csvPath string, batchSize int, strict, debug bool) error {
 	csvFile, err := os.Open(csvPath)
 	if err != nil {
 		return fmt.Errorf("failed to open CSV %s: %w", csvPath, err)
@@ -410,43 +767,69 @@ func (db *DB) importCSVtoTrades(zipPath, csvPath string, debug bool) error {
 	defer csvFile.Close()
 	removeFile(csvPath, debug)
 
-	reader := csv.NewReader(csvFile)
-	reader.FieldsPerRecord = -1 // Разрешить разное количество полей
-	records, err := reader.ReadAll()
-	if err != nil {
-		return fmt.Errorf("failed to read CSV %s: %w", csvPath, err)
-	}
-
-	if debug {
-		log.Printf("Processed %d rows from CSV: %s", len(records)-1, csvPath)
+	source := &lineCounter{r: csvFile} // Строки CSV для сверки с результатом разбора
+	reader := newCSVReader(source)     // Разделитель определяется по первой строке
+	// Читаем CSV построчно и вставляем порциями по batchSize строк
+	if batchSize <= 0 {
+		batchSize = DefaultImportBatchSize
 	}
+	rows := make([]TradeRow, 0, batchSize)
+	sourceFile := filepath.Base(zipPath) // Архив, из которого пришли строки
 
-	tx, err := db.conn.Begin()
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction in %s: %w", db.path, err)
-	}
-	stmt, err := tx.Prepare("INSERT OR IGNORE INTO trades (trade_id, timestamp, price, side, volume_quote, size_base) VALUES (?, ?, ?, ?, ?, ?)")
-	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to prepare statement in %s: %w", db.path, err)
-	}
-	defer stmt.Close()
+	tradeFields, _ := schemaFields("trades")
+	var columns []int // Номера колонок полей по заголовку; nil — позиционный разбор
 
 	inserted := 0
 	skipped := 0
-	for i, record := range records {
-		if i == 0 {
-			continue // Пропускаем заголовок
+	headerRows := 0
+	// flush вставляет накопленную порцию; повторы trade_id считаются пропущенными
+	flush := func() error {
+		n, err := db.store.InsertTrades(rows)
+		if err != nil {
+			return err
+		}
+		inserted += int(n)
+		db.inserted += n // Порция уже зафиксирована, даже если следующая не удастся
+		skipped += len(rows) - int(n)
+		rows = rows[:0]
+		return nil
+	}
+	for i := 0; ; i++ {
+		if len(rows) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
 		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return fmt.Errorf("failed to read CSV %s: %w", csvPath, err)
+			}
+			logging.Warnf("Skipping unreadable record in %s at line %d: %v", zipPath, i+1, err)
+			skipped++
+			continue
+		}
+		if i == 0 && isHeaderRow(record, 1) {
+			// Колонки узнаваемого заголовка сопоставляются по имени, иначе — по позиции
+			if columns = headerColumns(record, tradeFields, tradeFields); columns != nil {
+				logging.Debugf("Mapping columns of %s by header: %v", zipPath, record)
+			}
+			headerRows = 1
+			continue // Файлы без заголовка начинаются сразу с данных
+		}
+		record = reorderRecord(record, columns, "")
 		if len(record) < 6 {
-			log.Printf("Skipping invalid record in %s at line %d: %v", zipPath, i+1, record)
+			logging.Warnf("Skipping invalid record in %s at line %d: %v", zipPath, i+1, record)
 			skipped++
 			continue
 		}
 
 		tradeID := strings.TrimSpace(record[0])
 		if tradeID == "" {
-			log.Printf("Skipping record in %s at line %d: empty trade_id", zipPath, i+1)
+			logging.Warnf("Skipping record in %s at line %d: empty trade_id", zipPath, i+1)
 			skipped++
 			continue
 		}
@@ -454,84 +837,81 @@ func (db *DB) importCSVtoTrades(zipPath, csvPath string, debug bool) error {
 		timestampStr := strings.TrimSpace(record[1])
 		timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
 		if err != nil {
-			log.Printf("Skipping record in %s at line %d: invalid timestamp %s", zipPath, i+1, timestampStr)
+			logging.Warnf("Skipping record in %s at line %d: invalid timestamp %s", zipPath, i+1, timestampStr)
 			skipped++
 			continue
 		}
+		timestamp = NormalizeTimestamp(timestamp) // Миллисекунды и микросекунды приводим к секундам
 
 		priceStr := strings.TrimSpace(record[2])
-		price, err := strconv.ParseFloat(priceStr, 64)
+		price, err := parseNumeric(priceStr)
 		if err != nil {
-			log.Printf("Skipping record in %s at line %d: invalid price %s", zipPath, i+1, priceStr)
+			logging.Warnf("Skipping record in %s at line %d: invalid price %s", zipPath, i+1, priceStr)
 			skipped++
 			continue
 		}
 
-		side := strings.TrimSpace(record[3])
-		if side != "buy" && side != "sell" {
-			log.Printf("Skipping record in %s at line %d: invalid side %s", zipPath, i+1, side)
+		side, ok := normalizeSide(record[3])
+		if !ok {
+			logging.Warnf("Skipping record in %s at line %d: invalid side %s", zipPath, i+1, record[3])
 			skipped++
 			continue
 		}
 
 		volumeQuoteStr := strings.TrimSpace(record[4])
-		volumeQuote, err := strconv.ParseFloat(volumeQuoteStr, 64)
+		volumeQuote, err := parseNumeric(volumeQuoteStr)
 		if err != nil {
-			log.Printf("Skipping record in %s at line %d: invalid volume_quote %s", zipPath, i+1, volumeQuoteStr)
+			logging.Warnf("Skipping record in %s at line %d: invalid volume_quote %s", zipPath, i+1, volumeQuoteStr)
 			skipped++
 			continue
 		}
 
 		sizeBaseStr := strings.TrimSpace(record[5])
-		sizeBase, err := strconv.ParseFloat(sizeBaseStr, 64)
+		sizeBase, err := parseNumeric(sizeBaseStr)
 		if err != nil {
-			log.Printf("Skipping record in %s at line %d: invalid size_base %s", zipPath, i+1, sizeBaseStr)
+			logging.Warnf("Skipping record in %s at line %d: invalid size_base %s", zipPath, i+1, sizeBaseStr)
 			skipped++
 			continue
 		}
 
-		result, err := stmt.Exec(tradeID, timestamp, price, side, volumeQuote, sizeBase)
-		if err != nil {
-			log.Printf("Failed to insert record in %s at line %d: %v", zipPath, i+1, err)
-			skipped++
-			continue
-		}
-		affected, _ := result.RowsAffected()
-		if affected == 0 {
-			if debug {
-				log.Printf("Skipped record in %s at line %d: duplicate trade_id %s", zipPath, i+1, tradeID)
-				// } else {
-				// 	fmt.Fprintf(os.Stdout, "\rSkipped record in %s at line %d: duplicate trade_id %s", zipPath, i+1, tradeID)
-			}
-			skipped++
-		} else {
-			inserted++
-		}
+		rows = append(rows, TradeRow{tradeID, timestamp, price, side, volumeQuote, sizeBase, sourceFile})
 	}
 
-	if err := tx.Commit(); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to commit transaction in %s: %w", db.path, err)
+	if err := flush(); err != nil {
+		return err
 	}
-	if debug {
-		log.Printf("Committed transaction for trades CSV %s in %s, inserted %d rows, skipped %d rows", csvPath, db.path, inserted, skipped)
+	sourceRows := source.lines - headerRows
+	logging.Debugf("Committed transaction for trades CSV %s in %s, %d source rows, inserted %d rows, skipped %d rows", csvPath, db.path, sourceRows, inserted, skipped)
+	if err := checkRowCount(zipPath, sourceRows, inserted, skipped, strict); err != nil {
+		return err
 	}
 
 	// Выполняем чекпоинт WAL
-	_, err = db.conn.Exec("PRAGMA wal_checkpoint(TRUNCATE);")
-	if err != nil {
-		log.Printf("Failed to perform WAL checkpoint after trades CSV %s: %v", csvPath, err)
-	} else {
-		if debug {
-			log.Printf("WAL checkpoint successful after trades CSV %s", csvPath)
+	if db.conn != nil {
+		_, err = db.conn.Exec("PRAGMA wal_checkpoint(TRUNCATE);")
+		if err != nil {
+			logging.Errorf("Failed to perform WAL checkpoint after trades CSV %s: %v", csvPath, err)
+		} else {
+			logging.Debugf("WAL checkpoint successful after trades CSV %s", csvPath)
 		}
 	}
 
 	return nil
 }
 
-// importCSVtoDepth импортирует CSV в таблицу depth и удаляет CSV-файл.
-func (db *DB) importCSVtoDepth(zipPath, csvPath, tableName string, debug bool) error {
+// normalizeSide приводит сторону сделки к каноническому виду "buy" или "sell" без учёта регистра
+// и пробелов (Buy, SELL и т. п.). Второй результат false, если сторона неизвестна.
+func normalizeSide(side string) (string, bool) {
+	side = strings.ToLower(strings.TrimSpace(side))
+	if side != "buy" && side != "sell" {
+		return "", false
+	}
+	return side, true
+}
+
+// importCSVtoDepth импортирует CSV в таблицу depth и удаляет CSV-файл. Число строк CSV сверяется
+// с вставленными и пропущенными (checkRowCount).
+func (db *DB) importCSVtoDepth(zipPath, csvPath, tableName string, batchSize int, strict, debug bool) error {
 	csvFile, err := os.Open(csvPath)
 	if err != nil {
 		return fmt.Errorf("failed to open CSV %s: %w", csvPath, err)
@@ -539,34 +919,60 @@ func (db *DB) importCSVtoDepth(zipPath, csvPath, tableName string, debug bool) e
 	defer csvFile.Close()
 	removeFile(csvPath, debug)
 
-	reader := csv.NewReader(csvFile)
-	reader.FieldsPerRecord = -1 // Разрешить разное количество полей
-	records, err := reader.ReadAll()
-	if err != nil {
-		return fmt.Errorf("failed to read CSV %s: %w", csvPath, err)
-	}
-
-	if debug {
-		log.Printf("Processed %d rows from CSV: %s", len(records)-1, csvPath)
+	source := &lineCounter{r: csvFile} // Строки CSV для сверки с результатом разбора
+	reader := newCSVReader(source)     // Разделитель определяется по первой строке
+	// Читаем CSV построчно и вставляем порциями по batchSize строк
+	if batchSize <= 0 {
+		batchSize = DefaultImportBatchSize
 	}
+	rows := make([]DepthRow, 0, batchSize)
+	sourceFile := filepath.Base(zipPath) // Архив, из которого пришли строки
 
-	tx, err := db.conn.Begin()
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction in %s: %w", db.path, err)
-	}
-	stmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s" (timestamp, ask_price, bid_price, ask_volume, bid_volume) VALUES (?, ?, ?, ?, ?)`, tableName))
-	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to prepare statement for table %s in %s: %w", tableName, db.path, err)
-	}
-	defer stmt.Close()
+	depthFields, depthRequired := schemaFields("depth")
+	var columns []int // Номера колонок полей по заголовку; nil — позиционный разбор
 
 	inserted := 0
 	skipped := 0
-	for i, record := range records {
-		if i == 0 {
-			continue // Пропускаем заголовок
+	headerRows := 0
+	// flush вставляет накопленную порцию; повторяющиеся строки считаются пропущенными
+	flush := func() error {
+		n, err := db.store.InsertDepth(tableName, rows)
+		if err != nil {
+			return err
+		}
+		inserted += int(n)
+		db.inserted += n // Порция уже зафиксирована, даже если следующая не удастся
+		skipped += len(rows) - int(n)
+		rows = rows[:0]
+		return nil
+	}
+	for i := 0; ; i++ {
+		if len(rows) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return fmt.Errorf("failed to read CSV %s: %w", csvPath, err)
+			}
+			logging.Warnf("Skipping unreadable record in %s at line %d: %v", zipPath, i+1, err)
+			skipped++
+			continue
+		}
+		if i == 0 && isHeaderRow(record, 0) {
+			// Колонки узнаваемого заголовка сопоставляются по имени, иначе — по позиции
+			if columns = headerColumns(record, depthFields, depthRequired); columns != nil {
+				logging.Debugf("Mapping columns of %s by header: %v", zipPath, record)
+			}
+			headerRows = 1
+			continue // Файлы без заголовка начинаются сразу с данных
 		}
+		record = reorderRecord(record, columns, "0.0")
 
 		for len(record) < 5 {
 			record = append(record, "0.0")
@@ -575,72 +981,63 @@ func (db *DB) importCSVtoDepth(zipPath, csvPath, tableName string, debug bool) e
 		timestampStr := strings.TrimSpace(record[0])
 		timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
 		if err != nil {
-			log.Printf("Skipping record in %s at line %d: invalid timestamp %s: %v", zipPath, i+1, timestampStr, record)
+			logging.Warnf("Skipping record in %s at line %d: invalid timestamp %s: %v", zipPath, i+1, timestampStr, record)
 			skipped++
 			continue
 		}
+		timestamp = NormalizeTimestamp(timestamp) // Миллисекунды и микросекунды приводим к секундам
 
 		askPriceStr := strings.TrimSpace(record[1])
-		askPrice, err := strconv.ParseFloat(askPriceStr, 64)
+		askPrice, err := parseNumeric(askPriceStr)
 		if err != nil {
-			log.Printf("Skipping record in %s at line %d: invalid ask_price %s: %v", zipPath, i+1, askPriceStr, record)
+			logging.Warnf("Skipping record in %s at line %d: invalid ask_price %s: %v", zipPath, i+1, askPriceStr, record)
 			skipped++
 			continue
 		}
 
 		bidPriceStr := strings.TrimSpace(record[2])
-		bidPrice, err := strconv.ParseFloat(bidPriceStr, 64)
+		bidPrice, err := parseNumeric(bidPriceStr)
 		if err != nil {
-			log.Printf("Skipping record in %s at line %d: invalid bid_price %s: %v", zipPath, i+1, bidPriceStr, record)
+			logging.Warnf("Skipping record in %s at line %d: invalid bid_price %s: %v", zipPath, i+1, bidPriceStr, record)
 			skipped++
 			continue
 		}
 
 		askVolumeStr := strings.TrimSpace(record[3])
-		askVolume, err := strconv.ParseFloat(askVolumeStr, 64)
+		askVolume, err := parseNumeric(askVolumeStr)
 		if err != nil {
-			log.Printf("Skipping record in %s at line %d: invalid ask_volume %s: %v", zipPath, i+1, askVolumeStr, record)
+			logging.Warnf("Skipping record in %s at line %d: invalid ask_volume %s: %v", zipPath, i+1, askVolumeStr, record)
 			skipped++
 			continue
 		}
 
 		bidVolumeStr := strings.TrimSpace(record[4])
-		bidVolume, err := strconv.ParseFloat(bidVolumeStr, 64)
+		bidVolume, err := parseNumeric(bidVolumeStr)
 		if err != nil {
-			log.Printf("Skipping record in %s at line %d: invalid bid_volume %s: %v", zipPath, i+1, bidVolumeStr, record)
+			logging.Warnf("Skipping record in %s at line %d: invalid bid_volume %s: %v", zipPath, i+1, bidVolumeStr, record)
 			skipped++
 			continue
 		}
 
-		result, err := stmt.Exec(timestamp, askPrice, bidPrice, askVolume, bidVolume)
-		if err != nil {
-			log.Printf("Failed to insert record in %s at line %d: %v", zipPath, i+1, err)
-			skipped++
-			continue
-		}
-		affected, _ := result.RowsAffected()
-		if affected > 0 {
-			inserted++
-		} else {
-			skipped++
-		}
+		rows = append(rows, DepthRow{timestamp, askPrice, bidPrice, askVolume, bidVolume, sourceFile})
 	}
 
-	if err := tx.Commit(); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to commit transaction for table %s in %s: %w", tableName, db.path, err)
+	if err := flush(); err != nil {
+		return err
 	}
-	if debug {
-		log.Printf("Committed transaction for depth CSV %s in %s (table %s), inserted %d rows, skipped %d rows", csvPath, db.path, tableName, inserted, skipped)
+	sourceRows := source.lines - headerRows
+	logging.Debugf("Committed transaction for depth CSV %s in %s (table %s), %d source rows, inserted %d rows, skipped %d rows", csvPath, db.path, tableName, sourceRows, inserted, skipped)
+	if err := checkRowCount(zipPath, sourceRows, inserted, skipped, strict); err != nil {
+		return err
 	}
 
 	// Выполняем чекпоинт WAL
-	_, err = db.conn.Exec("PRAGMA wal_checkpoint(TRUNCATE);")
-	if err != nil {
-		log.Printf("Failed to perform WAL checkpoint after depth CSV %s (table %s): %v", csvPath, tableName, err)
-	} else {
-		if debug {
-			log.Printf("WAL checkpoint successful after depth CSV %s (table %s)", csvPath, tableName)
+	if db.conn != nil {
+		_, err = db.conn.Exec("PRAGMA wal_checkpoint(TRUNCATE);")
+		if err != nil {
+			logging.Errorf("Failed to perform WAL checkpoint after depth CSV %s (table %s): %v", csvPath, tableName, err)
+		} else {
+			logging.Debugf("WAL checkpoint successful after depth CSV %s (table %s)", csvPath, tableName)
 		}
 	}
 
@@ -649,9 +1046,9 @@ func (db *DB) importCSVtoDepth(zipPath, csvPath, tableName string, debug bool) e
 
 func removeFile(fileName string, debug bool) error {
 	if err := os.Remove(fileName); err != nil {
-		log.Printf("Warning: failed to remove file %s: %v", fileName, err)
+		logging.Warnf("failed to remove file %s: %v", fileName, err)
 	} else if debug {
-		log.Printf("File %s removed", fileName)
+		logging.Infof("File %s removed", fileName)
 	}
 	return nil
 }
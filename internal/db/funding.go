@@ -0,0 +1,109 @@
+package db
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// fundingSchema — таблица ставок финансирования фьючерсов; одна ставка на символ и время.
+const fundingSchema = `
+	CREATE TABLE IF NOT EXISTS funding (
+		timestamp INTEGER NOT NULL,
+		symbol TEXT NOT NULL,
+		funding_rate REAL,
+		PRIMARY KEY (symbol, timestamp)
+	);
+	CREATE INDEX IF NOT EXISTS idx_funding_timestamp ON funding(timestamp);
+`
+
+// importCSVtoFunding импортирует CSV со ставками финансирования (timestamp, symbol, funding_rate)
+// в таблицу funding и удаляет CSV-файл. Повторно импортированные ставки заменяют прежние.
+func (db *DB) importCSVtoFunding(zipPath, csvPath string, batchSize int, strict, debug bool) error {
+	csvFile, err := os.Open(csvPath)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV %s: %w", csvPath, err)
+	}
+	defer csvFile.Close()
+	removeFile(csvPath, debug)
+
+	source := &lineCounter{r: csvFile} // Строки CSV для сверки с результатом разбора
+	reader := newCSVReader(source)     // Разделитель определяется по первой строке
+	// Читаем CSV построчно и коммитим порциями по batchSize строк
+	batch := newBatchInserter(db.conn, db.path, "INSERT OR REPLACE INTO funding (timestamp, symbol, funding_rate) VALUES (?, ?, ?)", batchSize)
+	defer batch.rollback()
+	// Зафиксированные порции учитываются и при ошибке в следующих
+	defer func() { db.inserted += int64(batch.done) }()
+
+	skipped := 0
+	headerRows := 0
+	for i := 0; ; i++ {
+		if err := batch.commitIfFull(); err != nil {
+			return err
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return fmt.Errorf("failed to read CSV %s: %w", csvPath, err)
+			}
+			logging.Warnf("Skipping unreadable record in %s at line %d: %v", zipPath, i+1, err)
+			skipped++
+			continue
+		}
+		if i == 0 && isHeaderRow(record, 0) {
+			headerRows = 1
+			continue // Файлы без заголовка начинаются сразу с данных
+		}
+		if len(record) < 3 {
+			logging.Warnf("Skipping invalid record in %s at line %d: %v", zipPath, i+1, record)
+			skipped++
+			continue
+		}
+
+		timestampStr := strings.TrimSpace(record[0])
+		timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+		if err != nil {
+			logging.Warnf("Skipping record in %s at line %d: invalid timestamp %s", zipPath, i+1, timestampStr)
+			skipped++
+			continue
+		}
+		timestamp = NormalizeTimestamp(timestamp) // Миллисекунды и микросекунды приводим к секундам
+
+		symbol := strings.ToUpper(strings.TrimSpace(record[1]))
+		if symbol == "" {
+			logging.Warnf("Skipping record in %s at line %d: empty symbol", zipPath, i+1)
+			skipped++
+			continue
+		}
+
+		rateStr := strings.TrimSpace(record[2])
+		rate, err := parseNumeric(rateStr)
+		if err != nil {
+			logging.Warnf("Skipping record in %s at line %d: invalid funding_rate %s", zipPath, i+1, rateStr)
+			skipped++
+			continue
+		}
+
+		if _, err := batch.exec(timestamp, symbol, rate); err != nil {
+			logging.Errorf("Failed to insert record in %s at line %d: %v", zipPath, i+1, err)
+			skipped++
+			continue
+		}
+	}
+
+	if err := batch.commit(); err != nil {
+		return err
+	}
+	inserted := batch.done
+	sourceRows := source.lines - headerRows
+	logging.Debugf("Committed transaction for funding CSV %s in %s, %d source rows, inserted %d rows, skipped %d rows", csvPath, db.path, sourceRows, inserted, skipped)
+	return checkRowCount(zipPath, sourceRows, inserted, skipped, strict)
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// DefaultKlineTimeframe — таймфрейм свечей kline, если он не задан и не указан в пути архива.
+const DefaultKlineTimeframe = "m1"
+
+// klineTimeframes — написания таймфреймов в параметрах и путях архивов и их имена в таблице kline.
+var klineTimeframes = map[string]string{
+	"m1": "m1", "1m": "m1", "1min": "m1",
+	"m5": "m5", "5m": "m5", "5min": "m5",
+	"m15": "m15", "15m": "m15", "15min": "m15",
+	"m30": "m30", "30m": "m30", "30min": "m30",
+	"h1": "h1", "1h": "h1", "60m": "h1",
+	"h4": "h4", "4h": "h4",
+	"d1": "d1", "1d": "d1", "1day": "d1",
+}
+
+// ParseKlineTimeframe приводит таймфрейм (m1, 1m, 1H, 1D и т.п.) к имени в таблице kline.
+func ParseKlineTimeframe(timeframe string) (string, error) {
+	if tf, ok := klineTimeframes[strings.ToLower(strings.TrimSpace(timeframe))]; ok {
+		return tf, nil
+	}
+	return "", fmt.Errorf("invalid kline timeframe %s (expected m1, m5, m15, m30, h1, h4 or d1)", timeframe)
+}
+
+// klineTimeframe возвращает таймфрейм свечей архива: заданный явно, иначе указанный в имени
+// архива (20250101_1H.zip) или именем его каталога (5m/20250101.zip у --import-dir), иначе
+// DefaultKlineTimeframe. Каталоги выше не смотрятся: их задаёт пользователь в datafiles.path.
+func klineTimeframe(zipPath, timeframe string) string {
+	if timeframe != "" {
+		return timeframe
+	}
+	base := filepath.Base(zipPath)
+	parts := strings.FieldsFunc(strings.TrimSuffix(base, filepath.Ext(base)), func(r rune) bool { return r == '_' || r == '-' || r == '.' })
+	for i := len(parts) - 1; i >= 0; i-- {
+		if tf, err := ParseKlineTimeframe(parts[i]); err == nil {
+			return tf
+		}
+	}
+	if tf, err := ParseKlineTimeframe(filepath.Base(filepath.Dir(zipPath))); err == nil {
+		return tf
+	}
+	return DefaultKlineTimeframe
+}
+
+// klineSchema — таблица свечей; одна свеча на таймфрейм и время открытия.
+const klineSchema = `
+	CREATE TABLE IF NOT EXISTS kline (
+		timeframe TEXT NOT NULL,
+		timestamp INTEGER NOT NULL,
+		open REAL,
+		high REAL,
+		low REAL,
+		close REAL,
+		volume REAL,
+		PRIMARY KEY (timeframe, timestamp)
+	);
+	CREATE INDEX IF NOT EXISTS idx_kline_timestamp ON kline(timestamp);
+`
+
+// importCSVtoKline импортирует CSV со свечами (timestamp, open, high, low, close, volume)
+// таймфрейма timeframe в таблицу kline и удаляет CSV-файл. Повторно импортированные свечи
+// того же таймфрейма заменяют прежние.
+func (db *DB) importCSVtoKline(zipPath, csvPath, timeframe string, batchSize int, strict, debug bool) error {
+	csvFile, err := os.Open(csvPath)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV %s: %w", csvPath, err)
+	}
+	defer csvFile.Close()
+	removeFile(csvPath, debug)
+
+	source := &lineCounter{r: csvFile} // Строки CSV для сверки с результатом разбора
+	reader := newCSVReader(source)     // Разделитель определяется по первой строке
+	// Читаем CSV построчно и коммитим порциями по batchSize строк
+	batch := newBatchInserter(db.conn, db.path, "INSERT OR REPLACE INTO kline (timeframe, timestamp, open, high, low, close, volume) VALUES (?, ?, ?, ?, ?, ?, ?)", batchSize)
+	defer batch.rollback()
+	// Зафиксированные порции учитываются и при ошибке в следующих
+	defer func() { db.inserted += int64(batch.done) }()
+
+	skipped := 0
+	headerRows := 0
+	for i := 0; ; i++ {
+		if err := batch.commitIfFull(); err != nil {
+			return err
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return fmt.Errorf("failed to read CSV %s: %w", csvPath, err)
+			}
+			logging.Warnf("Skipping unreadable record in %s at line %d: %v", zipPath, i+1, err)
+			skipped++
+			continue
+		}
+		if i == 0 && isHeaderRow(record, 0) {
+			headerRows = 1
+			continue // Файлы без заголовка начинаются сразу с данных
+		}
+		if len(record) < 6 {
+			logging.Warnf("Skipping invalid record in %s at line %d: %v", zipPath, i+1, record)
+			skipped++
+			continue
+		}
+
+		timestampStr := strings.TrimSpace(record[0])
+		timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+		if err != nil {
+			logging.Warnf("Skipping record in %s at line %d: invalid timestamp %s", zipPath, i+1, timestampStr)
+			skipped++
+			continue
+		}
+		timestamp = NormalizeTimestamp(timestamp) // Миллисекунды и микросекунды приводим к секундам
+
+		var values [5]float64
+		names := [5]string{"open", "high", "low", "close", "volume"}
+		valid := true
+		for j := range values {
+			valueStr := strings.TrimSpace(record[j+1])
+			values[j], err = parseNumeric(valueStr)
+			if err != nil {
+				logging.Warnf("Skipping record in %s at line %d: invalid %s %s", zipPath, i+1, names[j], valueStr)
+				valid = false
+				break
+			}
+		}
+		if !valid {
+			skipped++
+			continue
+		}
+
+		if _, err := batch.exec(timeframe, timestamp, values[0], values[1], values[2], values[3], values[4]); err != nil {
+			logging.Errorf("Failed to insert record in %s at line %d: %v", zipPath, i+1, err)
+			skipped++
+			continue
+		}
+	}
+
+	if err := batch.commit(); err != nil {
+		return err
+	}
+	inserted := batch.done
+	sourceRows := source.lines - headerRows
+	logging.Debugf("Committed transaction for %s kline CSV %s in %s, %d source rows, inserted %d rows, skipped %d rows", timeframe, csvPath, db.path, sourceRows, inserted, skipped)
+	return checkRowCount(zipPath, sourceRows, inserted, skipped, strict)
+}
@@ -0,0 +1,23 @@
+package db
+
+import "testing"
+
+func TestKlineTimeframe(t *testing.T) {
+	tests := []struct {
+		zipPath, timeframe, want string
+	}{
+		{"/data/kline/UMCBL/BTCUSDT/20250101.zip", "", "m1"},
+		{"/data/kline/UMCBL/BTCUSDT/20250101_1H.zip", "", "h1"},
+		{"/data/kline/UMCBL/BTCUSDT/20250101-5min.zip", "", "m5"},
+		{"/import/4h/20250101.zip", "", "h4"},
+		{"/data/kline/UMCBL/BTCUSDT/20250101_1H.zip", "d1", "d1"},
+		// Каталоги выше родительского задаёт пользователь: таймфрейм по ним не определяется
+		{"/home/1h/data/kline/UMCBL/BTCUSDT/20250101.zip", "", "m1"},
+		{"/srv/5m/kline/UMCBL/BTCUSDT/20250101.zip", "", "m1"},
+	}
+	for _, tt := range tests {
+		if got := klineTimeframe(tt.zipPath, tt.timeframe); got != tt.want {
+			t.Errorf("klineTimeframe(%q, %q) = %s, want %s", tt.zipPath, tt.timeframe, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// ColumnMapping сопоставляет поля схемы (timestamp, price, ...) с колонками CSV.
+// Значение — номер колонки (с нуля) или имя колонки из заголовка.
+type ColumnMapping map[string]string
+
+// fieldAliases задаёт короткие имена полей для спецификации маппинга.
+var fieldAliases = map[string]string{
+	"id":    "trade_id",
+	"ts":    "timestamp",
+	"time":  "timestamp",
+	"size":  "size_base",
+	"qty":   "size_base",
+	"quote": "volume_quote",
+	"ask":   "ask_price",
+	"bid":   "bid_price",
+	"askv":  "ask_volume",
+	"bidv":  "bid_volume",
+}
+
+// schemaFields возвращает поля схемы и обязательные из них для типа данных.
+func schemaFields(dataType string) (fields []string, required []string) {
+	if dataType == "trades" {
+		return []string{"trade_id", "timestamp", "price", "side", "volume_quote", "size_base"},
+			[]string{"timestamp", "price", "side", "size_base"}
+	}
+	return []string{"timestamp", "ask_price", "bid_price", "ask_volume", "bid_volume"},
+		[]string{"timestamp", "ask_price", "bid_price"}
+}
+
+// ParseColumnMapping разбирает спецификацию вида "ts=0,price=2,side=3" или "ts=time,price=px".
+func ParseColumnMapping(spec string) (ColumnMapping, error) {
+	mapping := make(ColumnMapping)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid mapping entry %q (expected field=column)", part)
+		}
+		field := strings.ToLower(strings.TrimSpace(kv[0]))
+		if alias, ok := fieldAliases[field]; ok {
+			field = alias
+		}
+		if _, exists := mapping[field]; exists {
+			return nil, fmt.Errorf("duplicate mapping for field %s", field)
+		}
+		mapping[field] = strings.TrimSpace(kv[1])
+	}
+	if len(mapping) == 0 {
+		return nil, fmt.Errorf("empty column mapping")
+	}
+	return mapping, nil
+}
+
+// resolve превращает маппинг в индексы колонок, используя заголовок для именованных колонок.
+func (m ColumnMapping) resolve(dataType string, header []string) (map[string]int, error) {
+	fields, required := schemaFields(dataType)
+	known := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		known[f] = true
+	}
+
+	indexes := make(map[string]int, len(m))
+	for field, column := range m {
+		if !known[field] {
+			return nil, fmt.Errorf("unknown field %s for %s (expected one of %s)", field, dataType, strings.Join(fields, ", "))
+		}
+		if idx, err := strconv.Atoi(column); err == nil {
+			if idx < 0 {
+				return nil, fmt.Errorf("invalid column index %d for field %s", idx, field)
+			}
+			indexes[field] = idx
+			continue
+		}
+		if header == nil {
+			return nil, fmt.Errorf("column %q for field %s requires a header row", column, field)
+		}
+		found := -1
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return nil, fmt.Errorf("column %q for field %s not found in header", column, field)
+		}
+		indexes[field] = found
+	}
+
+	for _, f := range required {
+		if _, ok := indexes[f]; !ok {
+			return nil, fmt.Errorf("missing mapping for required field %s", f)
+		}
+	}
+	return indexes, nil
+}
+
+// usesNames сообщает, ссылается ли маппинг на колонки по имени.
+func (m ColumnMapping) usesNames() bool {
+	for _, column := range m {
+		if _, err := strconv.Atoi(column); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportMappedCSV импортирует CSV произвольной схемы в таблицу trades или depth.
+// Для depth tableName задаёт таблицу рынка ("1" или "2"). Заголовок определяется
+// автоматически: первая строка с нечисловым timestamp считается заголовком.
+func (db *DB) ImportMappedCSV(csvPath string, mapping ColumnMapping, tableName string, debug bool) error {
+	if err := db.requireSQLite("mapped CSV import"); err != nil {
+		return err
+	}
+	csvFile, err := os.Open(csvPath)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV %s: %w", csvPath, err)
+	}
+	defer csvFile.Close()
+
+	reader := newCSVReader(csvFile) // Разделитель определяется по первой строке
+	first, err := reader.Read()
+	if err == io.EOF {
+		return fmt.Errorf("CSV %s is empty", csvPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read CSV %s: %w", csvPath, err)
+	}
+
+	// Определяем, является ли первая строка заголовком
+	var header []string
+	if mapping.usesNames() {
+		header = first
+	} else if idx, err := strconv.Atoi(mapping["timestamp"]); err == nil {
+		if idx >= len(first) {
+			header = first
+		} else if _, err := strconv.ParseInt(strings.TrimSpace(first[idx]), 10, 64); err != nil {
+			header = first
+		}
+	}
+	indexes, err := mapping.resolve(db.dataType, header)
+	if err != nil {
+		return fmt.Errorf("invalid column mapping for %s: %w", csvPath, err)
+	}
+
+	var query string
+	if db.dataType == "trades" {
+		query = "INSERT OR IGNORE INTO trades (trade_id, timestamp, price, side, volume_quote, size_base, source_file) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	} else {
+		query = fmt.Sprintf(`INSERT OR IGNORE INTO "%s" (timestamp, ask_price, bid_price, ask_volume, bid_volume, source_file) VALUES (?, ?, ?, ?, ?, ?)`, tableName)
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction in %s: %w", db.path, err)
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prepare statement in %s: %w", db.path, err)
+	}
+	defer stmt.Close()
+
+	// get возвращает значение поля строки или пустую строку, если поле не задано
+	get := func(record []string, field string) string {
+		idx, ok := indexes[field]
+		if !ok || idx >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[idx])
+	}
+	// getFloat разбирает числовое поле, подставляя значение по умолчанию для незаданных полей
+	getFloat := func(record []string, field string, def float64) (float64, error) {
+		if _, ok := indexes[field]; !ok {
+			return def, nil
+		}
+		return parseNumeric(get(record, field))
+	}
+
+	sourceName := strings.TrimSuffix(filepath.Base(csvPath), filepath.Ext(csvPath))
+	inserted := 0
+	skipped := 0
+
+	// importRecord разбирает и вставляет одну строку CSV
+	importRecord := func(record []string, line int) {
+		timestampStr := get(record, "timestamp")
+		timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+		if err != nil {
+			logging.Warnf("Skipping record in %s at line %d: invalid timestamp %s", csvPath, line, timestampStr)
+			skipped++
+			return
+		}
+		timestamp = NormalizeTimestamp(timestamp) // Миллисекунды и микросекунды приводим к секундам
+
+		var args []interface{}
+		if db.dataType == "trades" {
+			price, err := getFloat(record, "price", 0)
+			if err != nil {
+				logging.Warnf("Skipping record in %s at line %d: invalid price %s", csvPath, line, get(record, "price"))
+				skipped++
+				return
+			}
+			side, ok := normalizeSide(get(record, "side"))
+			if !ok {
+				logging.Warnf("Skipping record in %s at line %d: invalid side %s", csvPath, line, get(record, "side"))
+				skipped++
+				return
+			}
+			sizeBase, err := getFloat(record, "size_base", 0)
+			if err != nil {
+				logging.Warnf("Skipping record in %s at line %d: invalid size_base %s", csvPath, line, get(record, "size_base"))
+				skipped++
+				return
+			}
+			volumeQuote, err := getFloat(record, "volume_quote", price*sizeBase)
+			if err != nil {
+				logging.Warnf("Skipping record in %s at line %d: invalid volume_quote %s", csvPath, line, get(record, "volume_quote"))
+				skipped++
+				return
+			}
+			// Без trade_id формируем стабильный идентификатор, чтобы повторный импорт не дублировал строки
+			tradeID := get(record, "trade_id")
+			if tradeID == "" {
+				tradeID = fmt.Sprintf("%s:%d", sourceName, line)
+			}
+			args = []interface{}{tradeID, timestamp, price, side, volumeQuote, sizeBase, filepath.Base(csvPath)}
+		} else {
+			args = []interface{}{timestamp}
+			for _, field := range []string{"ask_price", "bid_price", "ask_volume", "bid_volume"} {
+				v, err := getFloat(record, field, 0)
+				if err != nil {
+					logging.Warnf("Skipping record in %s at line %d: invalid %s %s", csvPath, line, field, get(record, field))
+					skipped++
+					return
+				}
+				args = append(args, v)
+			}
+			args = append(args, filepath.Base(csvPath))
+		}
+
+		result, err := stmt.Exec(args...)
+		if err != nil {
+			logging.Errorf("Failed to insert record in %s at line %d: %v", csvPath, line, err)
+			skipped++
+			return
+		}
+		affected, _ := result.RowsAffected()
+		if affected > 0 {
+			inserted++
+		} else {
+			skipped++
+		}
+	}
+
+	if header == nil {
+		importRecord(first, 1)
+	}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logging.Warnf("Skipping unreadable record in %s at line %d: %v", csvPath, line, err)
+			skipped++
+			continue
+		}
+		importRecord(record, line)
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit transaction in %s: %w", db.path, err)
+	}
+	db.inserted += int64(inserted)
+	logging.Infof("Imported mapped CSV %s into %s: inserted %d rows, skipped %d rows", csvPath, db.path, inserted, skipped)
+	logging.Debugf("Column mapping for %s: %v", csvPath, indexes)
+	return nil
+}
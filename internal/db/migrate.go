@@ -0,0 +1,187 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// migration — шаг обновления схемы. Каждый шаг идемпотентен: повторный запуск
+// на уже обновлённой базе ничего не меняет.
+type migration struct {
+	version     int
+	description string
+	dataTypes   []string // Типы данных, к которым применяется шаг; пусто — ко всем
+	apply       func(conn *sql.DB, path string) error
+}
+
+// migrations — упорядоченный список миграций. Новые шаги добавляются только в конец
+// со следующим номером версии.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "add source_file column",
+		dataTypes:   []string{"trades", "depth"},
+		apply: func(conn *sql.DB, path string) error {
+			tables, err := existingTables(conn, path, append([]string{"trades"}, depthTables...))
+			if err != nil {
+				return err
+			}
+			for _, table := range tables {
+				if err := EnsureColumn(conn, path, table, "source_file", "TEXT"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     2,
+		description: "deduplicate depth rows and add unique index",
+		dataTypes:   []string{"depth"},
+		apply: func(conn *sql.DB, path string) error {
+			for _, table := range depthTables {
+				if err := ensureDepthUnique(conn, path, table); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     3,
+		description: "create depth_all view",
+		dataTypes:   []string{"depth"},
+		apply: func(conn *sql.DB, path string) error {
+			if _, err := conn.Exec(depthAllView); err != nil {
+				return fmt.Errorf("failed to create view depth_all in %s: %w", path, err)
+			}
+			return nil
+		},
+	},
+}
+
+// depthAllView объединяет таблицы рынков depth с колонкой market ("spot"/"futures"),
+// чтобы потребителям не нужно было знать коды таблиц "1" и "2".
+const depthAllView = `
+	CREATE VIEW IF NOT EXISTS depth_all AS
+		SELECT 'spot' AS market, timestamp, ask_price, bid_price, ask_volume, bid_volume, source_file FROM "1"
+		UNION ALL
+		SELECT 'futures' AS market, timestamp, ask_price, bid_price, ask_volume, bid_volume, source_file FROM "2"
+`
+
+// SchemaVersion возвращает последнюю версию схемы, известную этой сборке.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate применяет к базе миграции новее её PRAGMA user_version и сохраняет
+// номер версии после каждого шага, чтобы прерванное обновление продолжилось с того же места.
+// Необязательные индексы из schema не версионируются и создаются при каждом открытии, если их нет.
+func migrate(conn *sql.DB, path, dataType string, schema SchemaOptions) error {
+	var current int
+	if err := conn.QueryRow("PRAGMA user_version").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version of %s: %w", path, err)
+	}
+	if current > SchemaVersion() {
+		logging.Warnf("Database %s has schema version %d, newer than supported %d", path, current, SchemaVersion())
+		return nil
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if appliesTo(m, dataType) {
+			logging.Infof("Applying migration %d (%s) to %s", m.version, m.description, path)
+			if err := m.apply(conn, path); err != nil {
+				return fmt.Errorf("migration %d (%s) failed for %s: %w", m.version, m.description, path, err)
+			}
+		}
+		// PRAGMA не поддерживает параметры, версия подставляется числом
+		if _, err := conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version)); err != nil {
+			return fmt.Errorf("failed to set schema version %d for %s: %w", m.version, path, err)
+		}
+		current = m.version
+	}
+
+	if schema.PriceIndex {
+		if err := ensurePriceIndexes(conn, path, dataType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// priceIndex — необязательный индекс по цене.
+type priceIndex struct {
+	name string
+	ddl  string
+}
+
+// priceIndexes возвращает индексы по цене для типа данных.
+func priceIndexes(dataType string) []priceIndex {
+	var indexes []priceIndex
+	switch dataType {
+	case "trades":
+		indexes = append(indexes, priceIndex{"idx_trades_price", `CREATE INDEX IF NOT EXISTS idx_trades_price ON trades(price)`})
+	case "depth":
+		for _, table := range depthTables {
+			for _, column := range []string{"ask_price", "bid_price"} {
+				name := fmt.Sprintf("idx_%s_%s", table, column)
+				indexes = append(indexes, priceIndex{name, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON "%s"(%s)`, name, table, column)})
+			}
+		}
+	}
+	return indexes
+}
+
+// ensurePriceIndexes создаёт недостающие индексы по цене. Выключение опции
+// уже созданные индексы не удаляет.
+func ensurePriceIndexes(conn *sql.DB, path, dataType string) error {
+	for _, index := range priceIndexes(dataType) {
+		var existing string
+		err := conn.QueryRow(`SELECT name FROM sqlite_master WHERE type='index' AND name=?`, index.name).Scan(&existing)
+		if err == nil {
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("failed to check index %s in %s: %w", index.name, path, err)
+		}
+		logging.Infof("Creating price index %s in %s", index.name, path)
+		if _, err := conn.Exec(index.ddl); err != nil {
+			return fmt.Errorf("failed to create index %s in %s: %w", index.name, path, err)
+		}
+	}
+	return nil
+}
+
+// appliesTo сообщает, относится ли миграция к базе данного типа.
+func appliesTo(m migration, dataType string) bool {
+	if len(m.dataTypes) == 0 {
+		return true
+	}
+	for _, t := range m.dataTypes {
+		if t == dataType {
+			return true
+		}
+	}
+	return false
+}
+
+// existingTables возвращает те из таблиц, что есть в базе.
+func existingTables(conn *sql.DB, path string, tables []string) ([]string, error) {
+	var found []string
+	for _, table := range tables {
+		var name string
+		err := conn.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name=?`, table).Scan(&name)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check table %s in %s: %w", table, path, err)
+		}
+		found = append(found, name)
+	}
+	return found, nil
+}
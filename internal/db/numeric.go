@@ -0,0 +1,26 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+)
+
+// normalizeNumeric приводит числовое поле выгрузки к виду, который понимает ParseFloat
+// и SQLite: пробелы обрезаются, пустое значение становится "0.0", завершающая точка ("123.")
+// дополняется нулём. Экспоненциальная запись (1.2e-5) остаётся как есть.
+func normalizeNumeric(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "0.0"
+	}
+	if strings.HasSuffix(value, ".") {
+		value += "0"
+	}
+	return value
+}
+
+// parseNumeric разбирает числовое поле после normalizeNumeric; одинаково используется
+// для CSV из архивов и CSV, сконвертированных из XLSX.
+func parseNumeric(value string) (float64, error) {
+	return strconv.ParseFloat(normalizeNumeric(value), 64)
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// extractResult — результат распаковки одного архива воркером.
+type extractResult struct {
+	csvPaths   []string
+	marketCode string
+	skip       bool // Пустой архив, импортировать нечего
+	fatal      bool // Ошибка прерывает импорт, как в последовательном режиме
+	err        error
+}
+
+// processZipsParallel распаковывает архивы в workers горутинах, а импортирует их в базу
+// по одному в исходном порядке: SQLite всё равно сериализует запись. Распакованных,
+// но ещё не импортированных архивов не больше 2*workers, чтобы не забивать tmp_raw_path.
+func (db *DB) processZipsParallel(ctx context.Context, zipFiles []string, tmpRawDataDir string, opts ImportOptions, debug bool) error {
+	workers := opts.Workers
+	results := make([]chan extractResult, len(zipFiles))
+	for i := range results {
+		results[i] = make(chan extractResult, 1)
+	}
+	slots := make(chan struct{}, 2*workers)
+	jobs := make(chan int)
+	// Закрывается при выходе, чтобы раздача заданий не зависла после отмены или ошибки
+	done := make(chan struct{})
+
+	go func() {
+		defer close(jobs)
+		for i := range zipFiles {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] <- extractOne(ctx, zipFiles[i], tmpRawDataDir, opts, debug)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(done)
+
+	logging.Infof("Processing %d zip files with %d extract workers", len(zipFiles), workers)
+	for i, zipPath := range zipFiles {
+		var res extractResult
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			fmt.Fprintln(os.Stdout)
+			return fmt.Errorf("import into %s interrupted: %w", db.path, ctx.Err())
+		}
+		<-slots
+		if res.err != nil {
+			if ctx.Err() != nil {
+				fmt.Fprintln(os.Stdout)
+				return fmt.Errorf("import into %s interrupted: %w", db.path, ctx.Err())
+			}
+			if res.fatal {
+				fmt.Fprintln(os.Stdout)
+				return res.err
+			}
+			logging.Errorf("Failed to process %s: %v", zipPath, res.err)
+			continue // Продолжаем с другими файлами
+		}
+		if res.skip {
+			continue
+		}
+
+		if debug {
+			logging.Debugf("Importing zip file: %s", zipPath)
+		} else if logging.Pretty() {
+			fmt.Fprintf(os.Stdout, "\r  Processing zip file: %-70s                    \r", zipPath)
+		}
+		if err := db.importExtracted(zipPath, res.csvPaths, res.marketCode, opts, debug); err != nil {
+			if errors.Is(err, ErrRowCountMismatch) {
+				fmt.Fprintln(os.Stdout)
+				return err // Только при opts.Strict
+			}
+			logging.Errorf("Failed to process %s: %v", zipPath, err)
+			continue
+		}
+	}
+	fmt.Fprintln(os.Stdout)
+	return nil
+}
+
+// extractOne проверяет и распаковывает один архив для processZipsParallel.
+func extractOne(ctx context.Context, zipPath, tmpRawDataDir string, opts ImportOptions, debug bool) extractResult {
+	if err := ctx.Err(); err != nil {
+		return extractResult{err: err}
+	}
+	fileInfo, err := os.Stat(zipPath)
+	if err != nil {
+		return extractResult{fatal: true, err: fmt.Errorf("failed to stat file %s: %w", zipPath, err)}
+	}
+	if fileInfo.Size() == 0 {
+		logging.Debugf("Skipping empty file %s (0 bytes)", zipPath)
+		return extractResult{skip: true}
+	}
+	logging.Debugf("Extracting zip file: %s", zipPath)
+	csvPaths, marketCode, err := extractZip(zipPath, tmpRawDataDir, opts, debug)
+	if err != nil {
+		return extractResult{err: err}
+	}
+	return extractResult{csvPaths: csvPaths, marketCode: marketCode}
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/magf/bitget-history/internal/logging"
+	_ "github.com/mattn/go-sqlite3" // Драйвер SQLite
+)
+
+// sqliteStore — Store поверх файла SQLite: база пары trades/<MARKET>/<PAIR>.db или depth/<PAIR>.db.
+type sqliteStore struct {
+	conn     *sql.DB
+	path     string
+	dataType string
+	schema   SchemaOptions
+}
+
+// openSQLiteStore открывает файл SQLite в режиме WAL; схема создаётся в EnsureSchema.
+// busy_timeout и synchronous передаются в строке подключения, чтобы действовать на каждом
+// соединении пула.
+func openSQLiteStore(path, dataType string, schema SchemaOptions) (*sqliteStore, error) {
+	dsn := path + "?_journal_mode=WAL&cache=shared"
+	if schema.BusyTimeout > 0 {
+		dsn += fmt.Sprintf("&_busy_timeout=%d", schema.BusyTimeout.Milliseconds())
+	}
+	if schema.Synchronous != "" {
+		dsn += "&_synchronous=" + schema.Synchronous
+	}
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	if _, err := conn.Exec("PRAGMA journal_mode=WAL;"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set WAL mode for %s: %w", path, err)
+	}
+	return &sqliteStore{conn: conn, path: path, dataType: dataType, schema: schema}, nil
+}
+
+// EnsureSchema создаёт таблицы типа данных и приводит базы старых версий к текущей схеме.
+func (s *sqliteStore) EnsureSchema() error {
+	switch s.dataType {
+	case "trades":
+		_, err := s.conn.Exec(`
+			CREATE TABLE IF NOT EXISTS trades (
+				trade_id TEXT PRIMARY KEY,
+				timestamp INTEGER,
+				price REAL,
+				side TEXT,
+				volume_quote REAL,
+				size_base REAL,
+				source_file TEXT
+			);
+			CREATE INDEX IF NOT EXISTS idx_trades_timestamp ON trades(timestamp);
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to create trades schema in %s: %w", s.path, err)
+		}
+	case "kline":
+		if _, err := s.conn.Exec(klineSchema); err != nil {
+			return fmt.Errorf("failed to create kline schema in %s: %w", s.path, err)
+		}
+	case "funding":
+		if _, err := s.conn.Exec(fundingSchema); err != nil {
+			return fmt.Errorf("failed to create funding schema in %s: %w", s.path, err)
+		}
+	default:
+		for _, table := range depthTables {
+			if _, err := s.conn.Exec(depthTableSchema(table)); err != nil {
+				return fmt.Errorf("failed to create depth schema in %s: %w", s.path, err)
+			}
+		}
+	}
+	logging.Infof("Initialized %s schema in %s", s.dataType, s.path)
+
+	// Приводим базы, созданные старыми версиями, к текущей схеме
+	return migrate(s.conn, s.path, s.dataType, s.schema)
+}
+
+// InsertTrades вставляет сделки через INSERT OR IGNORE: повтор trade_id пропускается.
+func (s *sqliteStore) InsertTrades(rows []TradeRow) (int64, error) {
+	return s.insert("INSERT OR IGNORE INTO trades (trade_id, timestamp, price, side, volume_quote, size_base, source_file) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		len(rows), func(i int) []interface{} {
+			r := rows[i]
+			return []interface{}{r.TradeID, r.Timestamp, r.Price, r.Side, r.VolumeQuote, r.SizeBase, r.SourceFile}
+		})
+}
+
+// InsertDepth вставляет строки стакана через INSERT OR IGNORE по уникальному индексу таблицы.
+func (s *sqliteStore) InsertDepth(table string, rows []DepthRow) (int64, error) {
+	return s.insert(fmt.Sprintf(`INSERT OR IGNORE INTO "%s" (timestamp, ask_price, bid_price, ask_volume, bid_volume, source_file) VALUES (?, ?, ?, ?, ?, ?)`, table),
+		len(rows), func(i int) []interface{} {
+			r := rows[i]
+			return []interface{}{r.Timestamp, r.AskPrice, r.BidPrice, r.AskVolume, r.BidVolume, r.SourceFile}
+		})
+}
+
+// insert выполняет query для n строк одной транзакцией. Ошибка вставки отдельной строки
+// логируется, строка пропускается.
+func (s *sqliteStore) insert(query string, n int, args func(i int) []interface{}) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	batch := newBatchInserter(s.conn, s.path, query, n)
+	defer batch.rollback()
+	var inserted int64
+	for i := 0; i < n; i++ {
+		result, err := batch.exec(args(i)...)
+		if err != nil {
+			if batch.tx == nil {
+				return 0, err // Не удалось открыть транзакцию
+			}
+			logging.Errorf("Failed to insert record into %s: %v", s.path, err)
+			continue
+		}
+		affected, _ := result.RowsAffected()
+		inserted += affected
+	}
+	if err := batch.commit(); err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+// Close переносит WAL в основной файл и закрывает подключение.
+func (s *sqliteStore) Close() error {
+	if _, err := s.conn.Exec("PRAGMA wal_checkpoint(FULL);"); err != nil {
+		logging.Errorf("Failed to perform WAL checkpoint for %s: %v", s.path, err)
+	} else {
+		logging.Infof("WAL checkpoint successful for %s", s.path)
+	}
+	if err := s.conn.Close(); err != nil {
+		return fmt.Errorf("failed to close database %s: %w", s.path, err)
+	}
+	return nil
+}
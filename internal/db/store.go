@@ -0,0 +1,36 @@
+package db
+
+// TradeRow — строка таблицы trades.
+type TradeRow struct {
+	TradeID     string
+	Timestamp   int64 // Секунды
+	Price       float64
+	Side        string // buy или sell
+	VolumeQuote float64
+	SizeBase    float64
+	SourceFile  string // Архив, из которого пришла строка
+}
+
+// DepthRow — строка таблицы depth.
+type DepthRow struct {
+	Timestamp  int64 // Секунды
+	AskPrice   float64
+	BidPrice   float64
+	AskVolume  float64
+	BidVolume  float64
+	SourceFile string
+}
+
+// Store — хранилище импортируемых сделок и стаканов: файл SQLite пары или общая база PostgreSQL.
+// Повторы (тот же trade_id, та же строка стакана) не вставляются; Insert* возвращают число
+// действительно добавленных строк.
+type Store interface {
+	// EnsureSchema создаёт таблицы и индексы, если их нет, и обновляет схему старых баз.
+	EnsureSchema() error
+	// InsertTrades вставляет порцию сделок одной транзакцией.
+	InsertTrades(rows []TradeRow) (int64, error)
+	// InsertDepth вставляет порцию стакана рынка table ("1" — spot, "2" — futures) одной транзакцией.
+	InsertDepth(table string, rows []DepthRow) (int64, error)
+	// Close закрывает подключение.
+	Close() error
+}
@@ -0,0 +1,20 @@
+package db
+
+// Пороги, по которым определяется единица измерения timestamp.
+// Секунды в ближайшие столетия меньше 1e11, миллисекунды — больше 1e12, микросекунды — больше 1e15.
+const (
+	millisecondThreshold = 1e12
+	microsecondThreshold = 1e15
+)
+
+// NormalizeTimestamp приводит timestamp к секундам: по величине значения определяет,
+// задан ли он в секундах, миллисекундах или микросекундах. В базе хранятся только секунды.
+func NormalizeTimestamp(ts int64) int64 {
+	switch {
+	case ts >= microsecondThreshold || ts <= -microsecondThreshold:
+		return ts / 1_000_000
+	case ts >= millisecondThreshold || ts <= -millisecondThreshold:
+		return ts / 1000
+	}
+	return ts
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeTimestamp(t *testing.T) {
+	tests := []struct {
+		name string
+		in   int64
+		want int64
+	}{
+		{"seconds", 1700000000, 1700000000},
+		{"milliseconds", 1700000000123, 1700000000},
+		{"microseconds", 1700000000123456, 1700000000},
+		{"largest seconds below threshold", 999999999999, 999999999999},
+		{"millisecond threshold", 1000000000000, 1000000000},
+		{"largest milliseconds below threshold", 999999999999999, 999999999999},
+		{"microsecond threshold", 1000000000000000, 1000000000},
+		{"zero", 0, 0},
+	}
+	for _, tt := range tests {
+		if got := NormalizeTimestamp(tt.in); got != tt.want {
+			t.Errorf("%s: NormalizeTimestamp(%d) = %d, want %d", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestImportTradesTimestampUnits(t *testing.T) {
+	files := map[string]string{
+		"seconds": "trade_id,timestamp,price,side,volume_quote,size_base\n" +
+			"1,1700000000,100,buy,100,1\n" +
+			"2,1700000061,101,sell,202,2\n",
+		"milliseconds": "trade_id,timestamp,price,side,volume_quote,size_base\n" +
+			"1,1700000000123,100,buy,100,1\n" +
+			"2,1700000061999,101,sell,202,2\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			csvPath := filepath.Join(dir, "trades.csv")
+			if err := os.WriteFile(csvPath, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			d, err := NewDB(filepath.Join(dir, "trades.db"), "trades", SchemaOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			if err := d.importCSVtoTrades(csvPath, csvPath, 100, true, false); err != nil {
+				t.Fatal(err)
+			}
+			rows, err := d.conn.Query("SELECT timestamp FROM trades ORDER BY timestamp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var got []int64
+			for rows.Next() {
+				var ts int64
+				if err := rows.Scan(&ts); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, ts)
+			}
+			want := []int64{1700000000, 1700000061}
+			if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+				t.Errorf("timestamps %v, want %v", got, want)
+			}
+		})
+	}
+}
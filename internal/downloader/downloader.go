@@ -2,11 +2,13 @@ package downloader
 
 import (
 	"archive/zip"
+	"bufio"
+	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math/rand"
 	"net/http"
 	"net/url"
@@ -14,10 +16,11 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/magf/bitget-history/internal/logging"
 	"github.com/magf/bitget-history/internal/proxymanager"
-	"golang.org/x/net/proxy"
 
 	_ "github.com/bdandy/go-socks4" // Поддержка SOCKS4
 )
@@ -30,12 +33,63 @@ type Downloader struct {
 	proxyMgr      *proxymanager.ProxyManager
 	maxRetries    int
 	checkedUrlsDB *sql.DB
+	noCache       bool         // Не читать и не писать результаты проверок в checked_urls
+	quiet         bool         // Не логировать каждый файл и попытку, только прогресс и ошибки
+	limiter       *rateLimiter // Общий лимит скорости загрузки; nil — без ограничения
+	checkLimit    int          // Одновременных проверок URL в GenerateURLs
+	downloadLimit int          // Одновременных загрузок в DownloadFiles
+	checked       sync.Map     // URL → checkedURL: результаты CheckFileOnline за время работы процесса
+
+	tradesMissRun int // Подряд отсутствующих частей trades, после которых перебор даты прекращается
+
+	userAgents   []string      // Чередуемые User-Agent; пусто — всегда userAgent
+	uaRoundRobin bool          // Перебирать userAgents по кругу, а не случайно
+	uaNext       atomic.Uint64 // Номер следующего User-Agent при переборе по кругу
+
+	statsMu sync.Mutex
+	stats   DownloadStats // Итоги всех вызовов DownloadFiles
+
+	maxFileBytes int64 // Предел размера одного скачиваемого файла
+
+	timeouts Timeouts // Таймауты этапов HTTP-запроса
+}
+
+// DownloadStats — итоги загрузок за время работы Downloader.
+type DownloadStats struct {
+	Downloaded   int      // Скачано файлов, включая перекачанные
+	Skipped      int      // Пропущено: файл уже есть с нужным размером
+	Redownloaded int      // Перекачано поверх локального файла с другим размером или устаревшей версии
+	Failed       int      // Не скачано после всех попыток
+	FailedURLs   []string // URL-ы нескачанных файлов
+}
+
+// checkedURL — результат проверки URL в памяти перед кэшем checked_urls.
+type checkedURL struct {
+	statusCode    int
+	contentLength int64
+	etag          string // Заголовок ETag; пусто, если сервер его не прислал
+	lastModified  string // Заголовок Last-Modified
 }
 
+// Пределы параллельности по умолчанию.
+const (
+	DefaultCheckConcurrency    = 50 // Проверок URL одновременно по умолчанию
+	DefaultDownloadConcurrency = 20 // Загрузок одновременно по умолчанию
+)
+
+// DefaultTradesMissRun — сколько частей trades подряд (две пачки по 10) может отсутствовать,
+// прежде чем перебор номеров для даты прекращается.
+const DefaultTradesMissRun = 20
+
+// DefaultMaxFileBytes — предел размера одного скачиваемого файла по умолчанию (2 ГиБ).
+const DefaultMaxFileBytes = 2 << 30
+
 // FileInfo хранит информацию о файле.
 type FileInfo struct {
 	URL           string
 	ContentLength int64
+
+	Exists bool // Файл уже есть локально (--skip-exists), скачивать его не нужно
 }
 
 // NewDownloader создаёт новый загрузчик.
@@ -47,156 +101,465 @@ func NewDownloader(baseURL, userAgent, outputDir string, proxyMgr *proxymanager.
 		proxyMgr:      proxyMgr,
 		maxRetries:    5,
 		checkedUrlsDB: checkedUrlsDB,
+		checkLimit:    DefaultCheckConcurrency,
+		downloadLimit: DefaultDownloadConcurrency,
+		tradesMissRun: DefaultTradesMissRun,
+		maxFileBytes:  DefaultMaxFileBytes,
+		timeouts: Timeouts{
+			Dial:           DefaultDialTimeout,
+			TLSHandshake:   DefaultTLSHandshakeTimeout,
+			ResponseHeader: DefaultResponseHeaderTimeout,
+			Stall:          DefaultStallTimeout,
+		},
 	}, nil
 }
 
+// SetCacheEnabled включает или отключает кэш checked_urls для CheckFileOnline. Без кэша каждый
+// URL проверяется HEAD-запросом, поэтому DownloadFiles видит актуальный ETag и замечает архивы,
+// перевыложенные на сервере. Сведения о скачанных версиях файлов сохраняются в любом случае.
+func (d *Downloader) SetCacheEnabled(enabled bool) {
+	d.noCache = !enabled
+}
+
+// SetQuiet отключает подробный лог по каждому файлу в DownloadFiles.
+func (d *Downloader) SetQuiet(quiet bool) {
+	d.quiet = quiet
+}
+
+// SetMaxBytesPerSec ограничивает суммарную скорость всех загрузок; 0 снимает ограничение.
+func (d *Downloader) SetMaxBytesPerSec(bytesPerSec int64) {
+	if bytesPerSec <= 0 {
+		d.limiter = nil
+		return
+	}
+	d.limiter = newRateLimiter(bytesPerSec)
+}
+
+// SetConcurrency ограничивает число одновременных проверок URL и загрузок; 0 и меньше —
+// значения по умолчанию.
+func (d *Downloader) SetConcurrency(check, download int) {
+	if check <= 0 {
+		check = DefaultCheckConcurrency
+	}
+	if download <= 0 {
+		download = DefaultDownloadConcurrency
+	}
+	d.checkLimit, d.downloadLimit = check, download
+}
+
+// CheckConcurrency возвращает предел одновременных проверок URL для GenerateURLs.
+func (d *Downloader) CheckConcurrency() int {
+	return d.checkLimit
+}
+
+// SetTradesMissRun задаёт, после скольких отсутствующих подряд частей trades (_NNN.zip)
+// GenerateURLs прекращает перебор номеров для даты; 0 и меньше — DefaultTradesMissRun.
+func (d *Downloader) SetTradesMissRun(n int) {
+	if n <= 0 {
+		n = DefaultTradesMissRun
+	}
+	d.tradesMissRun = n
+}
+
+// SetMaxFileBytes задаёт предел размера одного скачиваемого файла: загрузка больше предела
+// прерывается, а недокачанный файл удаляется; 0 и меньше — DefaultMaxFileBytes.
+func (d *Downloader) SetMaxFileBytes(n int64) {
+	if n <= 0 {
+		n = DefaultMaxFileBytes
+	}
+	d.maxFileBytes = n
+}
+
+// ErrFileTooLarge — файл больше предела SetMaxFileBytes. Такую загрузку не повторяют: каждая
+// попытка снова скачала бы тело до предела.
+var ErrFileTooLarge = errors.New("file exceeds max_file_bytes")
+
+// TradesMissRun возвращает число отсутствующих подряд частей trades, завершающее перебор даты.
+func (d *Downloader) TradesMissRun() int {
+	return d.tradesMissRun
+}
+
+// Режимы чередования User-Agent для SetUserAgents.
+const (
+	UserAgentRandom     = "random"
+	UserAgentRoundRobin = "round_robin"
+)
+
+// SetUserAgents задаёт список User-Agent, чередуемых от запроса к запросу: случайно
+// (UserAgentRandom) или по кругу (UserAgentRoundRobin). Пустой список — всегда один userAgent.
+func (d *Downloader) SetUserAgents(agents []string, rotation string) error {
+	switch rotation {
+	case "", UserAgentRandom:
+		d.uaRoundRobin = false
+	case UserAgentRoundRobin:
+		d.uaRoundRobin = true
+	default:
+		return fmt.Errorf("invalid user agent rotation: %s (must be %s or %s)", rotation, UserAgentRandom, UserAgentRoundRobin)
+	}
+	d.userAgents = nil
+	for _, ua := range agents {
+		if ua = strings.TrimSpace(ua); ua != "" {
+			d.userAgents = append(d.userAgents, ua)
+		}
+	}
+	return nil
+}
+
+// nextUserAgent возвращает User-Agent для очередного запроса.
+func (d *Downloader) nextUserAgent() string {
+	switch n := len(d.userAgents); {
+	case n == 0:
+		return d.userAgent
+	case d.uaRoundRobin:
+		return d.userAgents[(d.uaNext.Add(1)-1)%uint64(n)]
+	default:
+		return d.userAgents[rand.Intn(n)]
+	}
+}
+
+// logf логирует подробности загрузки, если не включён тихий режим.
+func (d *Downloader) logf(format string, args ...interface{}) {
+	if !d.quiet {
+		logging.Infof(format, args...)
+	}
+}
+
 // CheckFileOnline проверяет доступность файла по URL и возвращает код состояния и размер.
-func (d *Downloader) CheckFileOnline(urlStr string, debug bool) (statusCode int, contentLength int64, err error) {
+func (d *Downloader) CheckFileOnline(ctx context.Context, urlStr string, debug bool) (statusCode int, contentLength int64, err error) {
+	if d.noCache {
+		c, err := d.headFile(ctx, urlStr, debug)
+		if err != nil {
+			return 0, 0, err
+		}
+		d.checked.Store(urlStr, c) // Для сравнения ETag в DownloadFiles, не как кэш
+		return c.statusCode, c.contentLength, nil
+	}
+
+	// URL, уже проверенный в этом процессе, не ищем в базе повторно
+	if v, ok := d.checked.Load(urlStr); ok {
+		c := v.(checkedURL)
+		return c.statusCode, c.contentLength, nil
+	}
+
 	// Проверяем, есть ли URL в базе
+	var c checkedURL
 	var checkedAt time.Time
 	err = d.checkedUrlsDB.QueryRow(`
-		SELECT status_code, content_length, checked_at
+		SELECT status_code, content_length, checked_at, etag, last_modified
 		FROM checked_urls
 		WHERE url = ?
-	`, urlStr).Scan(&statusCode, &contentLength, &checkedAt)
+	`, urlStr).Scan(&c.statusCode, &c.contentLength, &checkedAt, &c.etag, &c.lastModified)
 	if err == nil {
-		if debug {
-			log.Printf("Found cached URL %s: status=%d, size=%d, checked_at=%s", urlStr, statusCode, contentLength, checkedAt)
-		}
-		return statusCode, contentLength, nil
+		logging.Debugf("Found cached URL %s: status=%d, size=%d, checked_at=%s", urlStr, c.statusCode, c.contentLength, checkedAt)
+		d.checked.Store(urlStr, c)
+		return c.statusCode, c.contentLength, nil
 	}
 	if err != sql.ErrNoRows {
-		log.Printf("Failed to query checked_urls for %s: %v", urlStr, err)
+		logging.Errorf("Failed to query checked_urls for %s: %v", urlStr, err)
 	}
 
 	// Если в базе нет, делаем HEAD-запрос
+	c, err = d.headFile(ctx, urlStr, debug)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Сохраняем результат в память и в базу; сведения о скачанной версии не трогаем
+	d.checked.Store(urlStr, c)
+	_, err = d.checkedUrlsDB.Exec(`
+		INSERT INTO checked_urls (url, status_code, content_length, checked_at, etag, last_modified)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			status_code = excluded.status_code,
+			content_length = excluded.content_length,
+			checked_at = excluded.checked_at,
+			etag = excluded.etag,
+			last_modified = excluded.last_modified
+	`, urlStr, c.statusCode, c.contentLength, time.Now(), c.etag, c.lastModified)
+	if err != nil {
+		logging.Errorf("Failed to save URL %s to checked_urls: %v", urlStr, err)
+	}
+
+	return c.statusCode, c.contentLength, nil
+}
+
+// HeadFile выполняет HEAD-запрос через случайный прокси без обращения к кэшу checked_urls.
+func (d *Downloader) HeadFile(ctx context.Context, urlStr string, debug bool) (statusCode int, contentLength int64, err error) {
+	c, err := d.headFile(ctx, urlStr, debug)
+	if err != nil {
+		return 0, 0, err
+	}
+	return c.statusCode, c.contentLength, nil
+}
+
+// randomProxyClient возвращает HTTP-клиент через случайный рабочий прокси.
+func (d *Downloader) randomProxyClient() (*http.Client, error) {
 	proxies, err := d.proxyMgr.GetProxies()
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to get proxies: %w", err)
+		return nil, fmt.Errorf("failed to get proxies: %w", err)
 	}
 	if len(proxies) == 0 {
-		return 0, 0, fmt.Errorf("no proxies available")
+		return nil, fmt.Errorf("no proxies available")
 	}
 
 	proxyURL, err := url.Parse(proxies[rand.Intn(len(proxies))])
 	if err != nil {
-		return 0, 0, fmt.Errorf("invalid proxy URL: %w", err)
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
 	}
+	return d.proxyClient(proxyURL)
+}
 
-	dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+// headFile выполняет HEAD-запрос и возвращает вместе с кодом и размером ETag и Last-Modified.
+func (d *Downloader) headFile(ctx context.Context, urlStr string, debug bool) (checkedURL, error) {
+	client, err := d.randomProxyClient()
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to create proxy %s: %w", proxyURL.String(), err)
+		return checkedURL{}, err
 	}
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			Dial: dialer.Dial,
-		},
-		Timeout: 30 * time.Second,
+	req, err := http.NewRequestWithContext(ctx, "HEAD", urlStr, nil)
+	if err != nil {
+		return checkedURL{}, fmt.Errorf("failed to create request for %s: %w", urlStr, err)
 	}
+	req.Header.Set("User-Agent", d.nextUserAgent())
 
-	req, err := http.NewRequest("HEAD", urlStr, nil)
+	resp, err := client.Do(req)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to create request for %s: %w", urlStr, err)
+		return checkedURL{}, fmt.Errorf("failed to check %s: %w", urlStr, err)
+	}
+	defer resp.Body.Close()
+
+	c := checkedURL{
+		statusCode:    resp.StatusCode,
+		contentLength: resp.ContentLength,
+		etag:          resp.Header.Get("ETag"),
+		lastModified:  resp.Header.Get("Last-Modified"),
 	}
-	req.Header.Set("User-Agent", d.userAgent)
+	logging.Debugf("Checked URL %s: status=%d, size=%d, etag=%q", urlStr, c.statusCode, c.contentLength, c.etag)
+	return c, nil
+}
 
+// FetchPage выполняет GET через случайный рабочий прокси и возвращает код ответа и не больше
+// limit байт тела. Нужен для небольших страниц вроде листинга каталога, не для архивов.
+func (d *Downloader) FetchPage(ctx context.Context, urlStr string, limit int64) (int, []byte, error) {
+	client, err := d.randomProxyClient()
+	if err != nil {
+		return 0, nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create request for %s: %w", urlStr, err)
+	}
+	req.Header.Set("User-Agent", d.nextUserAgent())
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to check %s: %w", urlStr, err)
+		return 0, nil, fmt.Errorf("failed to GET %s: %w", urlStr, err)
 	}
 	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(newStallReader(resp.Body, d.timeouts.Stall, cancel), limit))
+	if err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("failed to read %s: %w", urlStr, err)
+	}
+	return resp.StatusCode, body, nil
+}
 
-	statusCode = resp.StatusCode
-	contentLength = resp.ContentLength
-	if debug {
-		log.Printf("Checked URL %s: status=%d, size=%d", urlStr, statusCode, contentLength)
+// changedOnServer сообщает, что архив на сервере отличается от скачанной версии: ETag (или
+// Last-Modified, если ETag нет) последней проверки URL не совпадает с записанным при загрузке.
+// Без сведений с одной из сторон считается, что архив не менялся.
+func (d *Downloader) changedOnServer(fileURL string) bool {
+	v, ok := d.checked.Load(fileURL)
+	if !ok {
+		return false
 	}
+	current := v.(checkedURL)
+	var etag, lastModified string
+	err := d.checkedUrlsDB.QueryRow(`
+		SELECT downloaded_etag, downloaded_last_modified
+		FROM checked_urls
+		WHERE url = ?
+	`, fileURL).Scan(&etag, &lastModified)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logging.Errorf("Failed to query checked_urls for %s: %v", fileURL, err)
+		}
+		return false
+	}
+	if current.etag != "" && etag != "" {
+		return current.etag != etag
+	}
+	if current.lastModified != "" && lastModified != "" {
+		return current.lastModified != lastModified
+	}
+	return false
+}
 
-	// Сохраняем результат в базу
-	_, err = d.checkedUrlsDB.Exec(`
-		INSERT OR REPLACE INTO checked_urls (url, status_code, content_length, checked_at)
-		VALUES (?, ?, ?, ?)
-	`, urlStr, statusCode, contentLength, time.Now())
+// recordDownload запоминает ETag и Last-Modified скачанной версии файла.
+func (d *Downloader) recordDownload(fileURL string, size int64, etag, lastModified string) {
+	_, err := d.checkedUrlsDB.Exec(`
+		INSERT INTO checked_urls (url, status_code, content_length, checked_at, etag, last_modified, downloaded_etag, downloaded_last_modified)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			etag = excluded.etag,
+			last_modified = excluded.last_modified,
+			downloaded_etag = excluded.downloaded_etag,
+			downloaded_last_modified = excluded.downloaded_last_modified
+	`, fileURL, http.StatusOK, size, time.Now(), etag, lastModified, etag, lastModified)
 	if err != nil {
-		log.Printf("Failed to save URL %s to checked_urls: %v", urlStr, err)
+		logging.Errorf("Failed to save download of %s to checked_urls: %v", fileURL, err)
 	}
+}
 
-	return statusCode, contentLength, nil
+// Stats возвращает итоги загрузок за время работы Downloader.
+func (d *Downloader) Stats() DownloadStats {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+	stats := d.stats
+	stats.FailedURLs = append([]string{}, d.stats.FailedURLs...)
+	return stats
+}
+
+// countFile учитывает исход загрузки одного файла в Stats.
+func (d *Downloader) countFile(fileURL string, ok, replaced bool) {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+	if !ok {
+		d.stats.Failed++
+		d.stats.FailedURLs = append(d.stats.FailedURLs, fileURL)
+		return
+	}
+	d.stats.Downloaded++
+	if replaced {
+		d.stats.Redownloaded++
+	}
 }
 
 // DownloadFiles загружает файлы по списку URL-ов.
+// При отмене ctx новые загрузки не начинаются, а недокачанные файлы удаляются.
+// Периодически логируется общий прогресс со скоростью и оценкой оставшегося времени.
 func (d *Downloader) DownloadFiles(ctx context.Context, files []FileInfo) error {
-	log.Printf("Starting download of %d files", len(files))
+	logging.Infof("Starting download of %d files", len(files))
+	prog := newProgress(files)
+	reportDone := make(chan struct{})
+	go prog.report(reportDone)
+	defer func() {
+		close(reportDone)
+		logging.Infof("%s", prog.summary())
+		if err := d.proxyMgr.SaveBlacklist(); err != nil {
+			logging.Warnf("failed to save proxy blacklist: %v", err)
+		}
+	}()
+
 	var wg sync.WaitGroup
 	errChan := make(chan error, len(files))
 	failedURLs := make([]string, 0)
 	var mu sync.Mutex
 	badProxies := make(map[string]struct{}) // Кэш нерабочих прокси
+	sem := make(chan struct{}, d.downloadLimit)
 
 	for i, file := range files {
 		wg.Add(1)
+		sem <- struct{}{}
 		go func(i int, file FileInfo) {
 			defer wg.Done()
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
 			// Проверяем, существует ли файл и совпадает ли размер
 			relativePath := strings.TrimPrefix(file.URL, d.BaseURL+"/")
 			outputPath := filepath.Join(d.outputDir, relativePath)
-			if file.ContentLength > 0 {
-				if stat, err := os.Stat(outputPath); err == nil && stat.Size() == file.ContentLength {
-					log.Printf("Skipping %s: file exists with correct size %d", file.URL, file.ContentLength)
+			stat, statErr := os.Stat(outputPath)
+			replaced := statErr == nil && stat.Size() > 0 // Локальный файл есть, но будет перекачан
+			if file.ContentLength > 0 && statErr == nil && stat.Size() == file.ContentLength {
+				if !d.changedOnServer(file.URL) {
+					d.logf("Skipping %s: file exists with correct size %d", file.URL, file.ContentLength)
+					prog.skip(file)
+					d.statsMu.Lock()
+					d.stats.Skipped++
+					d.statsMu.Unlock()
 					return
 				}
+				logging.Infof("Re-downloading %s: archive changed on server (ETag differs)", file.URL)
 			}
+			defer prog.fileDone()
 
-			log.Printf("Downloading file %d: %s", i+1, file.URL)
+			d.logf("Downloading file %d: %s", i+1, file.URL)
 			for attempt := 1; attempt <= d.maxRetries; attempt++ {
 				proxies, err := d.proxyMgr.GetProxies()
 				if err != nil {
-					log.Printf("Failed to get proxies: %v", err)
+					logging.Errorf("Failed to get proxies: %v", err)
 					errChan <- err
 					return
 				}
 				if len(proxies) == 0 {
-					log.Printf("No proxies available")
+					logging.Warnf("No proxies available")
 					errChan <- fmt.Errorf("no proxies available")
 					return
 				}
 
 				// Фильтруем нерабочие прокси
 				var availableProxies []string
+				mu.Lock()
 				for _, p := range proxies {
 					if _, bad := badProxies[p]; !bad {
 						availableProxies = append(availableProxies, p)
 					}
 				}
+				mu.Unlock()
 				if len(availableProxies) == 0 {
-					log.Printf("All proxies marked as bad for %s", file.URL)
+					logging.Warnf("All proxies marked as bad for %s", file.URL)
 					mu.Lock()
 					failedURLs = append(failedURLs, file.URL)
 					mu.Unlock()
+					d.countFile(file.URL, false, replaced)
 					errChan <- fmt.Errorf("no good proxies left for %s", file.URL)
 					return
 				}
 
 				proxyIndex := rand.Intn(len(availableProxies))
 				proxyURL := availableProxies[proxyIndex]
-				log.Printf("Attempt %d/%d for %s using proxy %s", attempt, d.maxRetries, file.URL, proxyURL)
+				d.logf("Attempt %d/%d for %s using proxy %s", attempt, d.maxRetries, file.URL, proxyURL)
 
-				err = d.downloadWithProxy(ctx, file.URL, proxyURL)
+				err = d.downloadWithProxy(ctx, file.URL, proxyURL, prog)
 				if err == nil {
+					d.proxyMgr.ReportSuccess(proxyURL)
+					d.countFile(file.URL, true, replaced)
 					return
 				}
-				log.Printf("Failed attempt %d for %s with proxy %s: %v", attempt, file.URL, proxyURL, err)
+				if ctx.Err() != nil {
+					return // Прерваны сигналом, повторять не нужно
+				}
+				if errors.Is(err, ErrFileTooLarge) {
+					// Повтор снова скачал бы тело до предела
+					logging.Warnf("Giving up on %s: %v", file.URL, err)
+					mu.Lock()
+					failedURLs = append(failedURLs, file.URL)
+					mu.Unlock()
+					d.countFile(file.URL, false, replaced)
+					errChan <- err
+					return
+				}
+				d.logf("Failed attempt %d for %s with proxy %s: %v", attempt, file.URL, proxyURL, err)
 				// Помечаем прокси как нерабочий при определённых ошибках
-				if strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "timeout") {
+				// и учитываем отказ в статистике, переживающей запуск
+				if strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "timeout") || errors.Is(err, ErrNotZip) || errors.Is(err, ErrStalled) {
+					mu.Lock()
 					badProxies[proxyURL] = struct{}{}
-					log.Printf("Marked proxy %s as bad", proxyURL)
+					mu.Unlock()
+					d.proxyMgr.ReportFailure(proxyURL)
+					d.logf("Marked proxy %s as bad", proxyURL)
+				}
+				select {
+				case <-time.After(time.Second * time.Duration(attempt)):
+				case <-ctx.Done():
+					return
 				}
-				time.Sleep(time.Second * time.Duration(attempt))
 			}
 			mu.Lock()
 			failedURLs = append(failedURLs, file.URL)
 			mu.Unlock()
+			d.countFile(file.URL, false, replaced)
 			errChan <- fmt.Errorf("failed to download %s after %d attempts", file.URL, d.maxRetries)
 		}(i, file)
 	}
@@ -206,43 +569,42 @@ func (d *Downloader) DownloadFiles(ctx context.Context, files []FileInfo) error
 
 	for err := range errChan {
 		if err != nil {
-			log.Printf("Download error: %v", err)
+			logging.Warnf("Download error: %v", err)
 		}
 	}
 
+	if ctx.Err() != nil {
+		return fmt.Errorf("download interrupted: %w", ctx.Err())
+	}
 	if len(failedURLs) > 0 {
-		log.Printf("Failed to download the following files: %v", failedURLs)
+		logging.Errorf("Failed to download the following files: %v", failedURLs)
 		return fmt.Errorf("failed to download %d files", len(failedURLs))
 	}
-	log.Println("All files downloaded successfully")
+	logging.Infof("All files downloaded successfully")
 	return nil
 }
 
-// downloadWithProxy выполняет загрузку через указанный прокси.
-func (d *Downloader) downloadWithProxy(ctx context.Context, fileURL, proxyURLStr string) error {
+// downloadWithProxy выполняет загрузку через указанный прокси и учитывает байты в prog.
+func (d *Downloader) downloadWithProxy(ctx context.Context, fileURL, proxyURLStr string, prog *progress) error {
 	proxyURL, err := url.Parse(proxyURLStr)
 	if err != nil {
 		return fmt.Errorf("invalid proxy URL %s: %w", proxyURLStr, err)
 	}
 
-	// Используем proxy.FromURL для socks4 и socks5
-	dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+	// proxy.FromURL внутри proxyClient поддерживает socks4 и socks5
+	client, err := d.proxyClient(proxyURL)
 	if err != nil {
-		return fmt.Errorf("failed to create proxy %s: %w", proxyURLStr, err)
-	}
-
-	client := &http.Client{
-		Transport: &http.Transport{
-			Dial: dialer.Dial,
-		},
-		Timeout: 60 * time.Second,
+		return err
 	}
 
+	// Общего таймаута нет: запрос отменяется, только если тело перестаёт приходить
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("User-Agent", d.userAgent)
+	req.Header.Set("User-Agent", d.nextUserAgent())
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -250,15 +612,27 @@ func (d *Downloader) downloadWithProxy(ctx context.Context, fileURL, proxyURLStr
 	}
 	defer resp.Body.Close()
 
-	log.Printf("Response status for %s: %d", fileURL, resp.StatusCode)
+	d.logf("Response status for %s: %d", fileURL, resp.StatusCode)
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("unexpected status code for %s: %d", fileURL, resp.StatusCode)
 	}
+	// Некоторые прокси отвечают на 200 страницей ошибки: проверяем сигнатуру до записи файла
+	bodyReader := bufio.NewReader(newStallReader(resp.Body, d.timeouts.Stall, cancel))
+	if err := checkZipMagic(bodyReader, resp.Header.Get("Content-Type")); err != nil {
+		if errors.Is(err, ErrNotZip) {
+			logging.Warnf("Response for %s via proxy %s is %v", fileURL, proxyURLStr, err)
+		}
+		return fmt.Errorf("%s via proxy %s: %w", fileURL, proxyURLStr, err)
+	}
+
+	if resp.ContentLength > d.maxFileBytes {
+		return fmt.Errorf("%s is %d bytes: %w (%d)", fileURL, resp.ContentLength, ErrFileTooLarge, d.maxFileBytes)
+	}
 
 	// Формируем путь сохранения
 	relativePath := strings.TrimPrefix(fileURL, d.BaseURL+"/")
 	outputPath := filepath.Join(d.outputDir, relativePath)
-	log.Printf("Saving file to %s", outputPath)
+	d.logf("Saving file to %s", outputPath)
 	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
 		return err
 	}
@@ -270,22 +644,67 @@ func (d *Downloader) downloadWithProxy(ctx context.Context, fileURL, proxyURLStr
 	}
 	defer f.Close()
 
-	n, err := io.Copy(f, resp.Body)
+	var body io.Reader = bodyReader
+	if d.limiter != nil {
+		body = &limitedReader{ctx: ctx, r: bodyReader, limiter: d.limiter}
+	}
+	// Байт сверх предела хватает, чтобы заметить превышение, не дописывая тело до конца
+	n, err := io.Copy(io.MultiWriter(f, countingWriter{prog}), io.LimitReader(body, d.maxFileBytes+1))
+	if err == nil && n > d.maxFileBytes {
+		err = fmt.Errorf("%s: %w (%d), aborted", fileURL, ErrFileTooLarge, d.maxFileBytes)
+	}
 	if err != nil {
+		// Не оставляем недокачанный файл, в том числе при прерывании
+		f.Close()
+		os.Remove(outputPath)
+		prog.addBytes(-n) // Файл будет скачан заново
 		return err
 	}
-	log.Printf("Wrote %d bytes to %s", n, outputPath)
+	d.logf("Wrote %d bytes to %s", n, outputPath)
 
 	// Проверяем, что файл является Zip
 	if err := CheckZipFile(outputPath); err != nil {
-		log.Printf("Invalid Zip file %s: %v", outputPath, err)
+		logging.Warnf("Invalid Zip file %s: %v", outputPath, err)
 		os.Remove(outputPath)
+		prog.addBytes(-n)
 		return err
 	}
 
+	d.recordDownload(fileURL, n, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
 	return nil
 }
 
+// ErrNotZip — ответ с кодом 200, который не является Zip (обычно HTML-страница прокси).
+var ErrNotZip = errors.New("not a zip")
+
+// zipMagics — сигнатуры начала Zip: локальный заголовок файла и пустой архив.
+var zipMagics = [][]byte{[]byte("PK\x03\x04"), []byte("PK\x05\x06")}
+
+// checkZipMagic проверяет первые байты ответа, не забирая их из r. Пустой ответ допускается,
+// как и в CheckZipFile. contentType попадает в ошибку для диагностики.
+func checkZipMagic(r *bufio.Reader, contentType string) error {
+	head, err := r.Peek(4)
+	if len(head) == 0 && err == io.EOF {
+		return nil
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for _, magic := range zipMagics {
+		if bytes.Equal(head, magic) {
+			return nil
+		}
+	}
+	hint := ""
+	if strings.Contains(strings.ToLower(contentType), "html") || bytes.HasPrefix(bytes.TrimSpace(head), []byte("<")) {
+		hint = ", got HTML?"
+	}
+	if contentType == "" {
+		contentType = "none"
+	}
+	return fmt.Errorf("%w (Content-Type %s, starts with %q%s)", ErrNotZip, contentType, head, hint)
+}
+
 // CheckZipFile проверяет, является ли файл валидным Zip.
 func CheckZipFile(path string) error {
 	// Проверяем размер файла
@@ -294,7 +713,7 @@ func CheckZipFile(path string) error {
 		return fmt.Errorf("failed to stat file %s: %w", path, err)
 	}
 	if fileInfo.Size() == 0 {
-		log.Printf("Skipping empty file %s (0 bytes)", path)
+		logging.Warnf("Skipping empty file %s (0 bytes)", path)
 		return nil
 	}
 
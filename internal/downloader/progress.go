@@ -0,0 +1,147 @@
+package downloader
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+const (
+	progressInterval = 10 * time.Second // Как часто печатать строку прогресса
+	progressWindow   = 30 * time.Second // Окно для расчёта текущей скорости
+)
+
+// progressSample — число скачанных байт на момент времени.
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// progress считает файлы и байты за один вызов DownloadFiles и оценивает оставшееся время.
+type progress struct {
+	mu         sync.Mutex
+	started    time.Time
+	totalFiles int
+	totalBytes int64 // Сумма известных Content-Length
+	doneFiles  int
+	doneBytes  int64
+	samples    []progressSample
+}
+
+// newProgress создаёт счётчик для списка файлов.
+func newProgress(files []FileInfo) *progress {
+	p := &progress{started: time.Now(), totalFiles: len(files)}
+	for _, f := range files {
+		if f.ContentLength > 0 {
+			p.totalBytes += f.ContentLength
+		}
+	}
+	p.samples = []progressSample{{at: p.started}}
+	return p
+}
+
+// addBytes учитывает скачанные байты.
+func (p *progress) addBytes(n int64) {
+	p.mu.Lock()
+	p.doneBytes += n
+	p.mu.Unlock()
+}
+
+// fileDone отмечает файл завершённым (успешно или нет).
+func (p *progress) fileDone() {
+	p.mu.Lock()
+	p.doneFiles++
+	p.mu.Unlock()
+}
+
+// skip исключает из расчёта файл, который не нужно скачивать.
+func (p *progress) skip(file FileInfo) {
+	p.mu.Lock()
+	p.totalFiles--
+	if file.ContentLength > 0 {
+		p.totalBytes -= file.ContentLength
+	}
+	p.mu.Unlock()
+}
+
+// line возвращает строку прогресса: процент, объём, скорость за последнее окно и ETA.
+func (p *progress) line() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.samples = append(p.samples, progressSample{at: now, bytes: p.doneBytes})
+	for len(p.samples) > 2 && now.Sub(p.samples[1].at) >= progressWindow {
+		p.samples = p.samples[1:]
+	}
+	first := p.samples[0]
+	var speed float64 // байт в секунду
+	if elapsed := now.Sub(first.at).Seconds(); elapsed > 0 {
+		speed = float64(p.doneBytes-first.bytes) / elapsed
+	}
+
+	// Процент считаем по байтам, если размеры известны, иначе по файлам
+	percent := 100.0
+	if p.totalBytes > 0 {
+		percent = float64(p.doneBytes) / float64(p.totalBytes) * 100
+	} else if p.totalFiles > 0 {
+		percent = float64(p.doneFiles) / float64(p.totalFiles) * 100
+	}
+	if percent > 100 {
+		percent = 100
+	}
+
+	eta := "unknown"
+	if remaining := p.totalBytes - p.doneBytes; p.totalBytes > 0 && remaining <= 0 {
+		eta = "0s"
+	} else if p.totalBytes > 0 && speed > 0 {
+		eta = time.Duration(float64(remaining) / speed * float64(time.Second)).Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("Progress: %d/%d files (%.1f%%), %.1f/%.1f MB, %.2f MB/s, ETA %s",
+		p.doneFiles, p.totalFiles, percent, megabytes(p.doneBytes), megabytes(p.totalBytes), speed/1024/1024, eta)
+}
+
+// summary возвращает итоговую строку по завершении загрузки.
+func (p *progress) summary() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elapsed := time.Since(p.started)
+	var speed float64
+	if elapsed > 0 {
+		speed = float64(p.doneBytes) / elapsed.Seconds()
+	}
+	return fmt.Sprintf("Downloaded %d/%d files, %.1f MB in %s (%.2f MB/s)",
+		p.doneFiles, p.totalFiles, megabytes(p.doneBytes), elapsed.Round(time.Second), speed/1024/1024)
+}
+
+// report печатает строку прогресса каждые progressInterval до закрытия done.
+func (p *progress) report(done <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			logging.Infof("%s", p.line())
+		case <-done:
+			return
+		}
+	}
+}
+
+// countingWriter передаёт число записанных байт в progress.
+type countingWriter struct {
+	progress *progress
+}
+
+func (w countingWriter) Write(b []byte) (int, error) {
+	w.progress.addBytes(int64(len(b)))
+	return len(b), nil
+}
+
+// megabytes переводит байты в мегабайты.
+func megabytes(n int64) float64 {
+	return float64(n) / 1024 / 1024
+}
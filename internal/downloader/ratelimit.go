@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter — общий для всех загрузок token bucket, ограничивающий скорость в байтах в секунду.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Байт в секунду
+	burst  float64 // Ёмкость корзины: не больше секунды трафика
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter создаёт ограничитель на bytesPerSec байт в секунду.
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(bytesPerSec),
+		burst:  float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// wait резервирует n байт и ждёт, пока корзина их покроет, или отмены ctx.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitedReader читает из r не быстрее, чем позволяет limiter.
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	// Читаем небольшими порциями, чтобы скорость была ровной даже при низком лимите
+	chunk := 32 * 1024
+	if int(lr.limiter.burst) < chunk {
+		chunk = int(lr.limiter.burst)
+	}
+	if chunk < 1 {
+		chunk = 1
+	}
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.limiter.wait(lr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
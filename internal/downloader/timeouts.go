@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Таймауты HTTP-запросов по умолчанию.
+const (
+	DefaultDialTimeout           = 15 * time.Second
+	DefaultTLSHandshakeTimeout   = 15 * time.Second
+	DefaultResponseHeaderTimeout = 30 * time.Second
+	DefaultStallTimeout          = 30 * time.Second
+)
+
+// Timeouts — таймауты отдельных этапов HTTP-запроса вместо общего таймаута на весь запрос,
+// чтобы медленная, но идущая загрузка большого файла не прерывалась.
+type Timeouts struct {
+	Dial           time.Duration // Подключение к прокси вместе с рукопожатием SOCKS
+	TLSHandshake   time.Duration // Рукопожатие TLS с сервером
+	ResponseHeader time.Duration // От отправки запроса до заголовков ответа
+	Stall          time.Duration // Наибольшее ожидание очередных байт тела ответа
+}
+
+// ErrStalled — тело ответа не приходило дольше Timeouts.Stall.
+var ErrStalled = errors.New("download stalled")
+
+// SetTimeouts задаёт таймауты HTTP-запросов; нулевые и отрицательные поля — значения по умолчанию.
+func (d *Downloader) SetTimeouts(t Timeouts) {
+	if t.Dial <= 0 {
+		t.Dial = DefaultDialTimeout
+	}
+	if t.TLSHandshake <= 0 {
+		t.TLSHandshake = DefaultTLSHandshakeTimeout
+	}
+	if t.ResponseHeader <= 0 {
+		t.ResponseHeader = DefaultResponseHeaderTimeout
+	}
+	if t.Stall <= 0 {
+		t.Stall = DefaultStallTimeout
+	}
+	d.timeouts = t
+}
+
+// proxyClient возвращает HTTP-клиент через прокси proxyURL с таймаутами этапов запроса.
+// Общего таймаута у клиента нет: чтение тела ограничивает stallReader.
+func (d *Downloader) proxyClient(proxyURL *url.URL) (*http.Client, error) {
+	dialTimeout := d.timeouts.Dial
+	dialer, err := proxy.FromURL(proxyURL, &net.Dialer{Timeout: dialTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxy %s: %w", proxyURL.String(), err)
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+				defer cancel()
+				if cd, ok := dialer.(proxy.ContextDialer); ok {
+					return cd.DialContext(ctx, network, addr)
+				}
+				return dialer.Dial(network, addr)
+			},
+			TLSHandshakeTimeout:   d.timeouts.TLSHandshake,
+			ResponseHeaderTimeout: d.timeouts.ResponseHeader,
+		},
+	}, nil
+}
+
+// stallReader отменяет запрос, если очередное чтение из r ждёт данных дольше timeout.
+// Таймер идёт только внутри Read, поэтому паузы ограничителя скорости не считаются простоем.
+type stallReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+// newStallReader оборачивает тело ответа; cancel отменяет контекст запроса при простое.
+func newStallReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *stallReader {
+	s := &stallReader{r: r, timeout: timeout}
+	s.timer = time.AfterFunc(timeout, func() {
+		s.stalled.Store(true)
+		cancel()
+	})
+	s.timer.Stop()
+	return s
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	s.timer.Reset(s.timeout)
+	n, err := s.r.Read(p)
+	s.timer.Stop()
+	if err != nil && s.stalled.Load() {
+		err = fmt.Errorf("%w: no data for %s", ErrStalled, s.timeout)
+	}
+	return n, err
+}
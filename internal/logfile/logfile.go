@@ -0,0 +1,128 @@
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotatingFile — io.Writer, пишущий в файл с ротацией по размеру и возрасту.
+// Старый файл переименовывается в <path>.<YYYYMMDD-HHMMSS.mmm>, лишние копии удаляются.
+type RotatingFile struct {
+	path        string
+	maxSize     int64         // Максимальный размер файла в байтах (0 — без ограничения)
+	rotateEvery time.Duration // Максимальный возраст текущего файла (0 — без ограничения)
+	maxBackups  int           // Сколько старых файлов хранить (0 — все)
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// NewRotatingFile открывает (или создаёт) лог-файл path для дозаписи.
+func NewRotatingFile(path string, maxSize int64, rotateEvery time.Duration, maxBackups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for log file %s: %w", path, err)
+	}
+	r := &RotatingFile{path: path, maxSize: maxSize, rotateEvery: rotateEvery, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open открывает файл и запоминает его размер и время последнего изменения.
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", r.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log file %s: %w", r.path, err)
+	}
+	r.file = f
+	r.size = info.Size()
+	r.openedAt = time.Now()
+	if r.size > 0 {
+		r.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// Write пишет p в файл, предварительно выполняя ротацию при необходимости.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize) ||
+		(r.rotateEvery > 0 && time.Since(r.openedAt) > r.rotateEvery)) {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %v\n", r.path, err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate переименовывает текущий файл, открывает новый и удаляет лишние копии.
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file %s: %w", r.path, err)
+	}
+	backupPath := r.path + "." + time.Now().Format("20060102-150405.000")
+	if _, err := os.Stat(backupPath); err == nil {
+		backupPath += fmt.Sprintf(".%d", time.Now().UnixNano())
+	}
+	renameErr := os.Rename(r.path, backupPath)
+	if err := r.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("failed to rename log file %s: %w", r.path, renameErr)
+	}
+	r.prune()
+	return nil
+}
+
+// prune удаляет самые старые копии сверх maxBackups.
+func (r *RotatingFile) prune() {
+	if r.maxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return
+	}
+	// Имена содержат время ротации, поэтому сортировка по имени — сортировка по времени
+	sort.Strings(backups)
+	var old []string
+	for _, b := range backups {
+		if strings.HasPrefix(filepath.Base(b), filepath.Base(r.path)+".") {
+			old = append(old, b)
+		}
+	}
+	for len(old) > r.maxBackups {
+		os.Remove(old[0])
+		old = old[1:]
+	}
+}
+
+// Close закрывает текущий файл.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Options задаёт уровень и формат логов.
+type Options struct {
+	Level     string // error, warn, info или debug (по умолчанию info)
+	Format    string // pretty (как стандартный log), text (key=value) или json
+	AddSource bool   // Добавлять файл и строку вызова
+}
+
+// pretty сообщает, что логи пишутся в человекочитаемом виде и можно выводить прогресс через \r.
+var pretty = true
+
+// ParseLevel разбирает имя уровня; пустая строка — info.
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q (must be error, warn, info or debug)", name)
+}
+
+// Setup делает slog-обработчик с параметрами opts логгером по умолчанию. Стандартный
+// пакет log после этого тоже пишет через него, с уровнем info.
+func Setup(w io.Writer, opts Options) error {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level, AddSource: opts.AddSource}
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "pretty":
+		handler = newPrettyHandler(w, handlerOpts)
+		pretty = true
+	case "text":
+		handler = slog.NewTextHandler(w, handlerOpts)
+		pretty = false
+	case "json":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+		pretty = false
+	default:
+		return fmt.Errorf("invalid log format %q (must be pretty, text or json)", opts.Format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Pretty сообщает, можно ли выводить в stdout строки прогресса с возвратом каретки:
+// формат pretty и stdout — терминал.
+func Pretty() bool {
+	if !pretty {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Debugf пишет сообщение уровня debug.
+func Debugf(format string, args ...interface{}) { logf(slog.LevelDebug, format, args...) }
+
+// Infof пишет сообщение уровня info.
+func Infof(format string, args ...interface{}) { logf(slog.LevelInfo, format, args...) }
+
+// Warnf пишет сообщение уровня warn.
+func Warnf(format string, args ...interface{}) { logf(slog.LevelWarn, format, args...) }
+
+// Errorf пишет сообщение уровня error.
+func Errorf(format string, args ...interface{}) { logf(slog.LevelError, format, args...) }
+
+// Fatalf пишет сообщение уровня error и завершает процесс с кодом 1.
+func Fatalf(format string, args ...interface{}) {
+	logf(slog.LevelError, format, args...)
+	os.Exit(1)
+}
+
+// Enabled сообщает, пишутся ли сообщения уровня level; позволяет не готовить дорогие сообщения зря.
+func Enabled(level slog.Level) bool {
+	return slog.Default().Enabled(context.Background(), level)
+}
+
+// logf форматирует сообщение и передаёт его обработчику с адресом вызвавшего кода.
+func logf(level slog.Level, format string, args ...interface{}) {
+	logger := slog.Default()
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:]) // Пропускаем Callers, logf и Debugf/Infof/...
+	record := slog.NewRecord(time.Now(), level, fmt.Sprintf(format, args...), pcs[0])
+	_ = logger.Handler().Handle(context.Background(), record)
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// prettyHandler пишет записи в виде стандартного log: "2006/01/02 15:04:05 сообщение",
+// с уровнем перед сообщением для всего, кроме info, и атрибутами key=value в конце.
+type prettyHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	opts   slog.HandlerOptions
+	attrs  []slog.Attr
+	groups string // Префикс групп для имён атрибутов
+}
+
+func newPrettyHandler(w io.Writer, opts *slog.HandlerOptions) *prettyHandler {
+	return &prettyHandler{mu: &sync.Mutex{}, w: w, opts: *opts}
+}
+
+func (h *prettyHandler) Enabled(_ context.Context, level slog.Level) bool {
+	min := slog.LevelInfo
+	if h.opts.Level != nil {
+		min = h.opts.Level.Level()
+	}
+	return level >= min
+}
+
+func (h *prettyHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	buf.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+	if h.opts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		fmt.Fprintf(&buf, "%s:%d: ", filepath.Base(frame.File), frame.Line)
+	}
+	if r.Level != slog.LevelInfo {
+		buf.WriteString(r.Level.String())
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(r.Message)
+	for _, a := range h.attrs {
+		writeAttr(&buf, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&buf, h.groups, a)
+		return true
+	})
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func (h *prettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), qualify(h.groups, attrs)...)
+	return &clone
+}
+
+func (h *prettyHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.groups = h.groups + name + "."
+	return &clone
+}
+
+// qualify добавляет к именам атрибутов префикс групп.
+func qualify(prefix string, attrs []slog.Attr) []slog.Attr {
+	if prefix == "" {
+		return attrs
+	}
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = slog.Attr{Key: prefix + a.Key, Value: a.Value}
+	}
+	return out
+}
+
+// writeAttr дописывает атрибут как " key=value".
+func writeAttr(buf *bytes.Buffer, prefix string, a slog.Attr) {
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	fmt.Fprintf(buf, " %s%s=%v", prefix, a.Key, a.Value.Resolve())
+}
@@ -0,0 +1,93 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// Notifier отправляет сводку запуска во внешние системы (webhook и/или shell-команда).
+type Notifier struct {
+	webhookURL string
+	command    string
+	timeout    time.Duration
+}
+
+// NewNotifier создаёт новый нотификатор. Пустые webhookURL и command отключают соответствующий канал.
+func NewNotifier(webhookURL, command string) *Notifier {
+	return &Notifier{
+		webhookURL: webhookURL,
+		command:    command,
+		timeout:    30 * time.Second,
+	}
+}
+
+// Enabled сообщает, настроен ли хотя бы один канал уведомлений.
+func (n *Notifier) Enabled() bool {
+	return n != nil && (n.webhookURL != "" || n.command != "")
+}
+
+// Notify отправляет payload (JSON) во все настроенные каналы.
+// Ошибка одного канала не мешает отправке в другой.
+func (n *Notifier) Notify(ctx context.Context, payload []byte) error {
+	if !n.Enabled() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	var errs []error
+	if n.webhookURL != "" {
+		if err := n.postWebhook(ctx, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if n.command != "" {
+		if err := n.runCommand(ctx, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("notification failed: %v", errs)
+	}
+	return nil
+}
+
+// postWebhook отправляет payload POST-запросом на webhook.
+func (n *Notifier) postWebhook(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", n.webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected webhook status code: %d", resp.StatusCode)
+	}
+	logging.Infof("Sent run notification to webhook")
+	return nil
+}
+
+// runCommand запускает shell-команду, передавая payload на stdin и в BITGET_HISTORY_SUMMARY.
+func (n *Notifier) runCommand(ctx context.Context, payload []byte) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", n.command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(), "BITGET_HISTORY_SUMMARY="+string(payload))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("notify command failed: %w: %s", err, bytes.TrimSpace(output))
+	}
+	logging.Infof("Ran notify command")
+	return nil
+}
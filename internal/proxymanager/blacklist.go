@@ -0,0 +1,174 @@
+package proxymanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// BlacklistOptions — параметры исключения прокси, которые часто отказывают при загрузке.
+type BlacklistOptions struct {
+	File        string        // JSON со статистикой отказов; пусто — статистика живёт только до выхода
+	MinFailures int           // Прокси с меньшим числом отказов не исключается
+	Threshold   float64       // Доля отказов среди попыток, выше которой прокси исключается
+	Cooldown    time.Duration // Сколько прокси исключён после последнего отказа; 0 — не исключать
+}
+
+// proxyStat — статистика попыток загрузки через один прокси.
+type proxyStat struct {
+	Failures    int       `json:"failures"`
+	Successes   int       `json:"successes"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+// SetBlacklist включает исключение часто отказывающих прокси и загружает статистику прошлых
+// запусков из opts.File. Отсутствующий файл — пустая статистика, повреждённый — тоже, с предупреждением.
+func (pm *ProxyManager) SetBlacklist(opts BlacklistOptions) {
+	stats := make(map[string]*proxyStat)
+	if opts.File != "" {
+		data, err := os.ReadFile(opts.File)
+		if err == nil {
+			if err := json.Unmarshal(data, &stats); err != nil {
+				logging.Warnf("Ignoring unreadable proxy blacklist %s: %v", opts.File, err)
+				stats = make(map[string]*proxyStat)
+			}
+		} else if !os.IsNotExist(err) {
+			logging.Warnf("Ignoring unreadable proxy blacklist %s: %v", opts.File, err)
+		}
+	}
+	pm.mu.Lock()
+	pm.blacklist = opts
+	pm.stats = stats
+	pm.mu.Unlock()
+}
+
+// ReportFailure учитывает неудачную попытку загрузки через прокси.
+func (pm *ProxyManager) ReportFailure(proxyURL string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.stats == nil {
+		return
+	}
+	st := pm.statLocked(proxyURL)
+	st.Failures++
+	st.LastFailure = time.Now()
+}
+
+// ReportSuccess учитывает удачную загрузку через прокси.
+func (pm *ProxyManager) ReportSuccess(proxyURL string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.stats == nil {
+		return
+	}
+	pm.statLocked(proxyURL).Successes++
+}
+
+// statLocked возвращает статистику прокси, создавая её при необходимости. Вызывается под pm.mu.
+func (pm *ProxyManager) statLocked(proxyURL string) *proxyStat {
+	st, ok := pm.stats[proxyURL]
+	if !ok {
+		st = &proxyStat{}
+		pm.stats[proxyURL] = st
+	}
+	return st
+}
+
+// filterBlacklisted убирает из списка исключённые прокси. Прокси, у которых cooldown истёк,
+// реабилитируются: их статистика обнуляется. Если исключены все, возвращается исходный список,
+// чтобы загрузка не остановилась совсем.
+func (pm *ProxyManager) filterBlacklisted(proxies []string) []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.stats == nil || pm.blacklist.Cooldown <= 0 {
+		return proxies
+	}
+
+	now := time.Now()
+	var allowed []string
+	for _, p := range proxies {
+		st, ok := pm.stats[p]
+		if !ok {
+			allowed = append(allowed, p)
+			continue
+		}
+		if st.Failures > 0 && now.Sub(st.LastFailure) >= pm.blacklist.Cooldown {
+			delete(pm.stats, p)
+			logging.Debugf("Proxy %s rehabilitated after cooldown", p)
+			allowed = append(allowed, p)
+			continue
+		}
+		rate := float64(st.Failures) / float64(st.Failures+st.Successes)
+		if st.Failures >= pm.blacklist.MinFailures && rate > pm.blacklist.Threshold {
+			continue
+		}
+		allowed = append(allowed, p)
+	}
+	if excluded := len(proxies) - len(allowed); excluded > 0 {
+		if len(allowed) == 0 {
+			logging.Warnf("All %d proxies are blacklisted, using them anyway", len(proxies))
+			return proxies
+		}
+		logging.Debugf("Excluded %d blacklisted proxies, %d left", excluded, len(allowed))
+	}
+	return allowed
+}
+
+// SaveBlacklist атомарно сохраняет статистику отказов в файл. Записи без отказов не сохраняются.
+func (pm *ProxyManager) SaveBlacklist() error {
+	pm.mu.Lock()
+	file := pm.blacklist.File
+	stats := make(map[string]*proxyStat, len(pm.stats))
+	for p, st := range pm.stats {
+		if st.Failures > 0 {
+			copied := *st
+			stats[p] = &copied
+		}
+	}
+	pm.mu.Unlock()
+	if file == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	// Временный файл удаляется при любой ошибке до переименования
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Chmod(0644); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, file); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package proxymanager
+
+import (
+	"net/url"
+	"strings"
+)
+
+// bareSchemes — схемы, под которыми проверяются записи без схемы (голые ip:port).
+var bareSchemes = []string{"socks5", "socks4"}
+
+// normalizeProxy приводит запись списка к виду scheme://[user:password@]host:port, сохраняя логин
+// и пароль. Запись без схемы даёт по варианту на каждую из bareSchemes: проверка покажет, какой
+// протокол у прокси. Вместе с вариантами возвращаются ключи scheme://host:port без учётных данных.
+func normalizeProxy(entry string) (variants, keys []string, ok bool) {
+	schemes := bareSchemes
+	if i := strings.Index(entry, "://"); i >= 0 {
+		schemes = []string{strings.ToLower(entry[:i])}
+		entry = entry[i+3:]
+	}
+	for _, scheme := range schemes {
+		u, err := url.Parse(scheme + "://" + entry)
+		if err != nil || u.Host == "" || u.Port() == "" {
+			return nil, nil, false
+		}
+		host := u.Scheme + "://" + strings.ToLower(u.Host)
+		normalized := host
+		if u.User != nil {
+			normalized = u.Scheme + "://" + u.User.String() + "@" + u.Host
+		}
+		variants = append(variants, normalized)
+		keys = append(keys, host)
+	}
+	return variants, keys, true
+}
+
+// dedupProxies нормализует записи и оставляет по одной на scheme://host:port; при повторе
+// остаётся первая запись. Возвращает уникальные прокси в исходном порядке и число удалённых
+// дубликатов и невалидных записей.
+func dedupProxies(proxies []string) (unique []string, removed int) {
+	seen := make(map[string]bool, len(proxies))
+	for _, entry := range proxies {
+		variants, keys, ok := normalizeProxy(entry)
+		if !ok {
+			removed++
+			continue
+		}
+		added := false
+		for i, key := range keys {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			unique = append(unique, variants[i])
+			added = true
+		}
+		if !added {
+			removed++
+		}
+	}
+	return unique, removed
+}
@@ -0,0 +1,155 @@
+package proxymanager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// NetFilterOptions — отбор прокси по подсетям адреса хоста.
+type NetFilterOptions struct {
+	Allow          []string // CIDR; если список не пуст, остаются только прокси из этих подсетей
+	Block          []string // CIDR; прокси из этих подсетей отбрасываются
+	KeepUnresolved bool     // Оставлять прокси, имя хоста которых не разрешилось в IP
+}
+
+// netFilter — разобранные подсети NetFilterOptions.
+type netFilter struct {
+	allow          []*net.IPNet
+	block          []*net.IPNet
+	keepUnresolved bool
+}
+
+// SetNetFilter задаёт подсети, по которым прокси отбираются до проверки (EnsureProxies)
+// и при чтении статического списка. Пустые Allow и Block выключают фильтр.
+func (pm *ProxyManager) SetNetFilter(opts NetFilterOptions) error {
+	allow, err := parseCIDRs(opts.Allow)
+	if err != nil {
+		return fmt.Errorf("invalid proxy allow list: %w", err)
+	}
+	block, err := parseCIDRs(opts.Block)
+	if err != nil {
+		return fmt.Errorf("invalid proxy block list: %w", err)
+	}
+	if len(allow) == 0 && len(block) == 0 {
+		pm.netFilter = nil
+		return nil
+	}
+	pm.netFilter = &netFilter{allow: allow, block: block, keepUnresolved: opts.KeepUnresolved}
+	return nil
+}
+
+// parseCIDRs разбирает подсети; одиночный адрес без маски считается подсетью из одного адреса.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("%s is not an IP address or CIDR", cidr)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// filterNetworks оставляет прокси, адрес хоста которых проходит netFilter. Имена хостов
+// разрешаются в IP; прокси отбрасывается, если хоть один его адрес в блок-листе, и остаётся
+// при списке разрешённых, только если хоть один адрес в нём.
+func (pm *ProxyManager) filterNetworks(ctx context.Context, proxies []string) []string {
+	f := pm.netFilter
+	if f == nil {
+		return proxies
+	}
+	var kept []string
+	for _, p := range proxies {
+		entry := p
+		if !strings.Contains(entry, "://") {
+			entry = "socks5://" + entry
+		}
+		u, err := url.Parse(entry)
+		if err != nil || u.Hostname() == "" {
+			continue // Невалидные записи отбросит проверка
+		}
+		ips, err := pm.resolveHost(ctx, u.Hostname())
+		if err != nil {
+			if f.keepUnresolved {
+				kept = append(kept, p)
+			} else {
+				logging.Debugf("Dropping proxy %s: %v", u.Host, err)
+			}
+			continue
+		}
+		if f.allowed(ips) {
+			kept = append(kept, p)
+		}
+	}
+	if dropped := len(proxies) - len(kept); dropped > 0 {
+		logging.Infof("Dropped %d of %d proxies by allow_cidrs/block_cidrs", dropped, len(proxies))
+	}
+	return kept
+}
+
+// resolveHost возвращает IP хоста: адрес как есть или результат DNS-запроса с таймаутом проверки.
+func (pm *ProxyManager) resolveHost(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, pm.timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, nil
+}
+
+// allowed сообщает, проходят ли адреса хоста фильтр.
+func (f *netFilter) allowed(ips []net.IP) bool {
+	for _, ip := range ips {
+		if containsIP(f.block, ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, ip := range ips {
+		if containsIP(f.allow, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsIP сообщает, входит ли ip хоть в одну из подсетей.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,9 +2,11 @@ package proxymanager
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
@@ -13,7 +15,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/magf/bitget-history/internal/logging"
 	"golang.org/x/net/proxy"
+
+	_ "github.com/bdandy/go-socks4" // Поддержка SOCKS4 в proxy.FromURL
 )
 
 // ProxyManager управляет списком прокси.
@@ -24,6 +29,19 @@ type ProxyManager struct {
 	username    string
 	password    string
 	timeout     time.Duration
+	debug       bool
+	staticFile  string // Собственный список рабочих прокси; пусто — скачивать и проверять
+	staticCheck bool   // Проверять доступность прокси из staticFile
+	mu          sync.Mutex
+	static      []string // Загруженный список из staticFile; защищён mu
+	checkLimit  int      // Одновременных проверок прокси
+	blacklist   BlacklistOptions
+	stats       map[string]*proxyStat // Статистика отказов; nil — исключение выключено; защищена mu
+	netFilter   *netFilter            // Отбор по подсетям (SetNetFilter); nil — без отбора
+
+	latency map[string]time.Duration // Время последней успешной проверки прокси; защищено mu
+
+	sourceInterval time.Duration // Минимальный интервал между скачиваниями списков (SetSourceInterval)
 }
 
 // NewProxyManager создаёт новый менеджер прокси.
@@ -35,12 +53,36 @@ func NewProxyManager(rawFile, workingFile, fallback, username, password string,
 		username:    username,
 		password:    password,
 		timeout:     timeout,
+		checkLimit:  DefaultCheckConcurrency,
+
+		sourceInterval: DefaultSourceInterval,
 	}, nil
 }
 
-// EnsureProxies загружает или проверяет список прокси.
+// DefaultCheckConcurrency — проверок прокси одновременно по умолчанию.
+const DefaultCheckConcurrency = 500
+
+// SetCheckConcurrency ограничивает число одновременных проверок прокси; 0 и меньше — по умолчанию.
+func (pm *ProxyManager) SetCheckConcurrency(n int) {
+	if n <= 0 {
+		n = DefaultCheckConcurrency
+	}
+	pm.checkLimit = n
+}
+
+// SetDebug включает подробный лог проверки прокси.
+func (pm *ProxyManager) SetDebug(debug bool) {
+	pm.debug = debug
+}
+
+// EnsureProxies загружает или проверяет список прокси. Со статическим списком
+// (SetStaticFile) только читает его.
 func (pm *ProxyManager) EnsureProxies(ctx context.Context) error {
-	// Проверяем наличие rawFile
+	if pm.staticFile != "" {
+		return pm.loadStatic(ctx)
+	}
+
+	// Скачиваем списки, если rawFile нет или он устарел
 	if err := pm.downloadProxies(ctx); err != nil {
 		return fmt.Errorf("failed to download proxies: %w", err)
 	}
@@ -54,6 +96,14 @@ func (pm *ProxyManager) EnsureProxies(ctx context.Context) error {
 		return fmt.Errorf("proxy list is empty: %s", pm.rawFile)
 	}
 
+	// Повторы scheme://host:port из разных списков проверяем один раз, голые ip:port — как socks5 и socks4
+	proxies, removed := dedupProxies(proxies)
+	logging.Debugf("Removed %d duplicate or invalid proxies from %s, %d left to check", removed, pm.rawFile, len(proxies))
+	// Прокси, исключённые за частые отказы, не проверяем до конца cooldown
+	proxies = pm.filterBlacklisted(proxies)
+	// Прокси из запрещённых подсетей (или вне разрешённых) не проверяем
+	proxies = pm.filterNetworks(ctx, proxies)
+
 	// Проверяем прокси многопоточно
 	workingProxies, err := pm.checkProxies(ctx, proxies)
 	if err != nil {
@@ -70,23 +120,44 @@ func (pm *ProxyManager) EnsureProxies(ctx context.Context) error {
 	return nil
 }
 
-// downloadProxies скачивает список прокси, если файл отсутствует.
-func (pm *ProxyManager) downloadProxies(ctx context.Context) error {
-	if _, err := os.Stat(pm.rawFile); err == nil {
-		return nil // Файл существует
+// UseExistingProxies готовит уже имеющийся список прокси, ничего не записывая на диск: статический
+// список читается как в EnsureProxies, а рабочий файл не скачивается и не перепроверяется.
+func (pm *ProxyManager) UseExistingProxies(ctx context.Context) error {
+	if pm.staticFile != "" {
+		return pm.loadStatic(ctx)
 	}
-
-	// Создаём директорию
-	if err := os.MkdirAll(filepath.Dir(pm.rawFile), 0755); err != nil {
-		return err
+	proxies, err := pm.GetProxies()
+	if err != nil {
+		return fmt.Errorf("failed to read working proxies %s: %w", pm.workingFile, err)
+	}
+	if len(proxies) == 0 {
+		return fmt.Errorf("no working proxies in %s", pm.workingFile)
 	}
+	return nil
+}
 
-	// Создаём временный файл
-	f, err := os.Create(pm.rawFile)
-	if err != nil {
+// proxySources — списки бесплатных SOCKS4 и SOCKS5 прокси.
+var proxySources = []string{
+	"https://cdn.jsdelivr.net/gh/proxifly/free-proxy-list@main/proxies/protocols/socks4/data.txt",
+	"https://cdn.jsdelivr.net/gh/proxifly/free-proxy-list@main/proxies/protocols/socks5/data.txt",
+}
+
+const (
+	sourceAttempts = 3               // Попыток скачать один список прокси
+	sourceBackoff  = 2 * time.Second // Базовая пауза перед повтором, удваивается с каждой попыткой
+)
+
+// downloadProxies скачивает списки прокси, если файл отсутствует или старше sourceInterval, но не
+// чаще раза в sourceInterval. Список, который не удалось скачать и после повторов, пропускается;
+// если не скачался ни один, остаётся прежний файл, а без него возвращается ошибка.
+func (pm *ProxyManager) downloadProxies(ctx context.Context) error {
+	fetch, err := pm.needSourceFetch()
+	if err != nil || !fetch {
 		return err
 	}
-	defer f.Close()
+	_, statErr := os.Stat(pm.rawFile)
+	stale := statErr == nil // Устаревший файл остаётся, если обновить его не удалось
+	pm.markSourceFetch()
 
 	// Настраиваем HTTP-клиент
 	client := &http.Client{
@@ -115,34 +186,80 @@ func (pm *ProxyManager) downloadProxies(ctx context.Context) error {
 		}
 	}
 
-	// Скачиваем списки для SOCKS4 и SOCKS5
-	for _, proto := range []string{"4", "5"} {
-		url := fmt.Sprintf("https://cdn.jsdelivr.net/gh/proxifly/free-proxy-list@main/proxies/protocols/socks%s/data.txt", proto)
-		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	// Скачиваем списки для SOCKS4 и SOCKS5; файл пишем, только если скачался хотя бы один
+	var buf bytes.Buffer
+	var lastErr error
+	for _, source := range proxySources {
+		data, err := fetchSource(ctx, client, source)
 		if err != nil {
-			return err
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logging.Warnf("failed to download proxy list %s: %v", source, err)
+			lastErr = err
+			continue
 		}
-
-		resp, err := client.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to download %s: %w", url, err)
+		buf.Write(data)
+		buf.WriteString("\n")
+	}
+	if buf.Len() == 0 {
+		if stale {
+			logging.Warnf("failed to refresh proxy lists, keeping %s: %v", pm.rawFile, lastErr)
+			return nil
 		}
-		defer resp.Body.Close()
+		return fmt.Errorf("all proxy sources failed, last error: %w", lastErr)
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("unexpected status code for %s: %d", url, resp.StatusCode)
-		}
+	// Создаём директорию
+	if err := os.MkdirAll(filepath.Dir(pm.rawFile), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(pm.rawFile, buf.Bytes(), 0644)
+}
 
-		_, err = io.Copy(f, resp.Body)
-		if err != nil {
-			return err
+// fetchSource скачивает один список прокси, повторяя запрос после сетевых ошибок
+// и ответов 429/5xx с растущей паузой.
+func fetchSource(ctx context.Context, client *http.Client, source string) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		data, retry, err := fetchSourceOnce(ctx, client, source)
+		if err == nil {
+			return data, nil
 		}
-		_, err = f.WriteString("\n")
-		if err != nil {
-			return err
+		if !retry || attempt >= sourceAttempts || ctx.Err() != nil {
+			return nil, err
+		}
+		delay := sourceBackoff<<(attempt-1) + time.Duration(rand.Int63n(int64(sourceBackoff)))
+		logging.Debugf("Retrying %s in %v after error: %v", source, delay.Round(time.Millisecond), err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 	}
-	return nil
+}
+
+// fetchSourceOnce выполняет один запрос списка прокси и закрывает тело ответа до возврата.
+// retry=true, если запрос имеет смысл повторить.
+func fetchSourceOnce(ctx context.Context, client *http.Client, source string) (data []byte, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to download %s: %w", source, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, retry, fmt.Errorf("unexpected status code for %s: %d", source, resp.StatusCode)
+	}
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read %s: %w", source, err)
+	}
+	return data, false, nil
 }
 
 // loadProxies загружает список прокси из файла.
@@ -170,12 +287,15 @@ func (pm *ProxyManager) checkProxies(ctx context.Context, proxies []string) ([]s
 	results := make(chan string, len(proxies))
 	var mu sync.Mutex
 	var workingProxies []string
+	sem := make(chan struct{}, pm.checkLimit)
 
-	// Запускаем goroutine для каждого прокси
+	// Запускаем goroutine для каждого прокси, не больше checkLimit одновременно
 	for _, p := range proxies {
 		wg.Add(1)
+		sem <- struct{}{}
 		go func(proxyURL string) {
 			defer wg.Done()
+			defer func() { <-sem }()
 			ok, err := pm.checkProxy(ctx, proxyURL)
 			if err != nil {
 				return
@@ -202,9 +322,14 @@ func (pm *ProxyManager) checkProxies(ctx context.Context, proxies []string) ([]s
 	return workingProxies, nil
 }
 
-// checkProxy проверяет работоспособность одного прокси.
+const (
+	checkAttempts = 2                      // Попыток проверки прокси до отбраковки
+	checkBackoff  = 500 * time.Millisecond // Базовая пауза перед повтором, удваивается с каждой попыткой
+)
+
+// checkProxy проверяет работоспособность одного прокси. Прокси, не ответивший вовремя,
+// проверяется повторно после паузы со случайной добавкой, чтобы не отбраковывать временно медленные.
 func (pm *ProxyManager) checkProxy(ctx context.Context, proxyURL string) (bool, error) {
-	proxyURL = strings.Replace(proxyURL, "socks4://", "socks5://", 1) // Унифицируем для SOCKS5
 	parsedURL, err := url.Parse(proxyURL)
 	if err != nil {
 		return false, nil // Игнорируем невалидные URL
@@ -221,49 +346,134 @@ func (pm *ProxyManager) checkProxy(ctx context.Context, proxyURL string) (bool,
 		Transport: transport,
 		Timeout:   pm.timeout,
 	}
+	proxyIP := parsedURL.Hostname()
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		ok, retry := probeProxy(ctx, client, proxyIP)
+		if ok {
+			pm.recordLatency(proxyURL, time.Since(start))
+		}
+		if ok || !retry || attempt >= checkAttempts {
+			return ok, nil
+		}
+		delay := checkBackoff<<(attempt-1) + time.Duration(rand.Int63n(int64(checkBackoff)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false, nil
+		}
+	}
+}
 
+// probeProxy запрашивает внешний IP через прокси и сравнивает его с адресом прокси.
+// retry=true, если запрос не удался (таймаут, обрыв) и проверку имеет смысл повторить.
+func probeProxy(ctx context.Context, client *http.Client, proxyIP string) (ok, retry bool) {
 	req, err := http.NewRequestWithContext(ctx, "GET", "https://ifconfig.io", nil)
 	if err != nil {
-		return false, nil
+		return false, false
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return false, nil
+		return false, ctx.Err() == nil
 	}
 	defer resp.Body.Close()
 
 	// Проверяем, что IP совпадает с прокси
-	proxyIP := strings.Split(strings.TrimPrefix(proxyURL, "socks5://"), ":")[0]
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return false, nil
+		return false, ctx.Err() == nil
 	}
-	return strings.TrimSpace(string(body)) == proxyIP, nil
+	return strings.TrimSpace(string(body)) == proxyIP, false
 }
 
-// saveProxies сохраняет рабочие прокси в файл.
+// recordLatency запоминает время успешной проверки прокси.
+func (pm *ProxyManager) recordLatency(proxyURL string, d time.Duration) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.latency == nil {
+		pm.latency = make(map[string]time.Duration)
+	}
+	pm.latency[proxyURL] = d
+}
+
+// Latency возвращает время успешной проверки прокси в последнем EnsureProxies: запрос внешнего IP
+// через прокси или, для статического списка с проверкой, TCP-соединение. ok=false, если прокси
+// в этом запуске не проверялся.
+func (pm *ProxyManager) Latency(proxyURL string) (time.Duration, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	d, ok := pm.latency[proxyURL]
+	return d, ok
+}
+
+// saveProxies атомарно сохраняет рабочие прокси в файл: список пишется во временный файл
+// рядом с рабочим и переименовывается поверх него, поэтому сбой посреди записи не оставляет усечённый список.
 func (pm *ProxyManager) saveProxies(proxies []string) error {
 	// Создаём директорию
-	if err := os.MkdirAll(filepath.Dir(pm.workingFile), 0755); err != nil {
+	dir := filepath.Dir(pm.workingFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	f, err := os.Create(pm.workingFile)
+	f, err := os.CreateTemp(dir, filepath.Base(pm.workingFile)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	tmpPath := f.Name()
+	// Временный файл удаляется при любой ошибке до переименования
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
 
+	w := bufio.NewWriter(f)
 	for _, p := range proxies {
-		if _, err := f.WriteString(p + "\n"); err != nil {
-			return err
+		if _, err := w.WriteString(p + "\n"); err != nil {
+			return fail(err)
 		}
 	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Chmod(0644); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, pm.workingFile); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", pm.workingFile, err)
+	}
 	return nil
 }
 
-// GetProxies возвращает список рабочих прокси.
+// GetProxies возвращает список рабочих прокси: статический, если он задан, иначе из workingFile.
+// Прокси, исключённые за частые отказы (SetBlacklist), в список не попадают.
 func (pm *ProxyManager) GetProxies() ([]string, error) {
-	return pm.loadProxies(pm.workingFile)
+	if pm.staticFile != "" {
+		pm.mu.Lock()
+		static := pm.static
+		pm.mu.Unlock()
+		if static != nil {
+			return pm.filterBlacklisted(static), nil
+		}
+		entries, err := pm.loadProxies(pm.staticFile)
+		if err != nil {
+			return nil, err
+		}
+		return pm.filterBlacklisted(staticProxies(entries)), nil
+	}
+	proxies, err := pm.loadProxies(pm.workingFile)
+	if err != nil {
+		return nil, err
+	}
+	return pm.filterBlacklisted(proxies), nil
 }
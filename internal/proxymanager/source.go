@@ -0,0 +1,78 @@
+package proxymanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// DefaultSourceInterval — минимальный интервал между скачиваниями списков прокси по умолчанию.
+const DefaultSourceInterval = 30 * time.Minute
+
+// SetSourceInterval задаёт минимальный интервал между скачиваниями списков прокси: rawFile моложе
+// интервала используется как есть, более старый обновляется, а после неудачной попытки источники
+// не запрашиваются повторно до истечения интервала. 0 — rawFile не обновляется и скачивается,
+// только если его нет.
+func (pm *ProxyManager) SetSourceInterval(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	pm.sourceInterval = d
+}
+
+// fetchedFile — файл рядом с rawFile со временем последней попытки скачать списки прокси.
+func (pm *ProxyManager) fetchedFile() string {
+	return pm.rawFile + ".fetched"
+}
+
+// lastSourceFetch возвращает время последней попытки скачать списки прокси.
+func (pm *ProxyManager) lastSourceFetch() (time.Time, bool) {
+	data, err := os.ReadFile(pm.fetchedFile())
+	if err != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// markSourceFetch запоминает время попытки скачать списки прокси.
+func (pm *ProxyManager) markSourceFetch() {
+	path := pm.fetchedFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logging.Warnf("failed to create directory for %s: %v", path, err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		logging.Warnf("failed to write %s: %v", path, err)
+	}
+}
+
+// needSourceFetch решает, скачивать ли списки прокси. Без ошибки и с false — rawFile
+// используется как есть; ошибка — rawFile нет, а источники запрашивались слишком недавно.
+func (pm *ProxyManager) needSourceFetch() (bool, error) {
+	info, err := os.Stat(pm.rawFile)
+	exists := err == nil
+	if exists && (pm.sourceInterval == 0 || time.Since(info.ModTime()) < pm.sourceInterval) {
+		return false, nil // Файл свежий или обновление выключено
+	}
+	if pm.sourceInterval == 0 {
+		return true, nil
+	}
+	last, ok := pm.lastSourceFetch()
+	if !ok || time.Since(last) >= pm.sourceInterval {
+		return true, nil
+	}
+	wait := (pm.sourceInterval - time.Since(last)).Round(time.Second)
+	if exists {
+		logging.Infof("Reusing %s: proxy sources were fetched less than %s ago, next refresh in %s", pm.rawFile, pm.sourceInterval, wait)
+		return false, nil
+	}
+	return false, fmt.Errorf("%s is missing and proxy sources were fetched less than %s ago; next fetch allowed in %s (proxy.source_interval)", pm.rawFile, pm.sourceInterval, wait)
+}
@@ -0,0 +1,110 @@
+package proxymanager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// SetStaticFile задаёт собственный список рабочих прокси. С ним EnsureProxies не скачивает
+// и не проверяет бесплатные списки, а GetProxies возвращает записи из file. check=true включает
+// быструю проверку доступности: оставляются прокси, к которым открывается TCP-соединение.
+func (pm *ProxyManager) SetStaticFile(file string, check bool) {
+	pm.staticFile = file
+	pm.staticCheck = check
+	pm.mu.Lock()
+	pm.static = nil
+	pm.mu.Unlock()
+}
+
+// loadStatic читает список из staticFile и при staticCheck отбрасывает недоступные прокси.
+func (pm *ProxyManager) loadStatic(ctx context.Context) error {
+	entries, err := pm.loadProxies(pm.staticFile)
+	if err != nil {
+		return fmt.Errorf("failed to load static proxies: %w", err)
+	}
+	proxies := pm.filterNetworks(ctx, staticProxies(entries))
+	if len(proxies) == 0 {
+		return fmt.Errorf("static proxy list is empty: %s", pm.staticFile)
+	}
+	if pm.staticCheck {
+		alive := pm.aliveProxies(ctx, proxies)
+		logging.Infof("%d of %d static proxies are reachable", len(alive), len(proxies))
+		if len(alive) == 0 {
+			return fmt.Errorf("no reachable proxies in %s", pm.staticFile)
+		}
+		proxies = alive
+	}
+	pm.mu.Lock()
+	pm.static = proxies
+	pm.mu.Unlock()
+	return nil
+}
+
+// staticProxies оставляет валидные записи без повторов, сохраняя логин и пароль.
+// Записи без схемы считаются SOCKS5: без проверки запросом протокол не определить.
+func staticProxies(entries []string) []string {
+	seen := make(map[string]bool, len(entries))
+	var proxies []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry, "#") {
+			continue // Комментарий
+		}
+		if !strings.Contains(entry, "://") {
+			entry = "socks5://" + entry
+		}
+		u, err := url.Parse(entry)
+		if err != nil || u.Host == "" || u.Port() == "" {
+			logging.Warnf("Skipping invalid static proxy %s", entry)
+			continue
+		}
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		proxies = append(proxies, entry)
+	}
+	return proxies
+}
+
+// aliveProxies параллельно открывает TCP-соединение с каждым прокси и возвращает
+// ответившие за pm.timeout в исходном порядке.
+func (pm *ProxyManager) aliveProxies(ctx context.Context, proxies []string) []string {
+	alive := make([]bool, len(proxies))
+	dialer := &net.Dialer{Timeout: pm.timeout}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, pm.checkLimit)
+	for i, p := range proxies {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, proxyURL string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			u, _ := url.Parse(proxyURL) // Проверен в staticProxies
+			start := time.Now()
+			conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+			if err != nil {
+				logging.Debugf("Static proxy %s is unreachable: %v", u.Host, err)
+				return
+			}
+			conn.Close()
+			pm.recordLatency(proxyURL, time.Since(start))
+			alive[i] = true
+		}(i, p)
+	}
+	wg.Wait()
+
+	var result []string
+	for i, ok := range alive {
+		if ok {
+			result = append(result, proxies[i])
+		}
+	}
+	return result
+}
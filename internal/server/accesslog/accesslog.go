@@ -0,0 +1,131 @@
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Форматы журнала запросов (server.access_log).
+const (
+	FormatLog  = "log"  // Через общий логгер в формате log.format (по умолчанию)
+	FormatJSON = "json" // JSON-строки для сбора в систему логов
+	FormatOff  = "off"  // Журнал выключен
+)
+
+// redactedParams — параметры запроса, значения которых не попадают в журнал.
+var redactedParams = []string{"key"}
+
+// Options задаёт формат и вывод журнала запросов.
+type Options struct {
+	Format string    // FormatLog (по умолчанию), FormatJSON или FormatOff
+	Output io.Writer // Общий вывод логов: туда идут JSON-строки, если File не задан
+	File   io.Writer // Отдельный файл журнала; nil — журнал идёт в общий вывод
+}
+
+// Logger пишет строку журнала на каждый HTTP-запрос.
+type Logger struct {
+	logger *slog.Logger
+}
+
+// New создаёт журнал запросов. FormatLog пишет через общий логгер, а при File — строками
+// key=value в файл; FormatJSON пишет JSON-строки в File или Output. Для FormatOff возвращает
+// nil: Wrap у nil отдаёт обработчик без изменений.
+func New(opts Options) (*Logger, error) {
+	switch strings.ToLower(strings.TrimSpace(opts.Format)) {
+	case "", FormatLog:
+		if opts.File != nil {
+			return &Logger{logger: slog.New(slog.NewTextHandler(opts.File, nil))}, nil
+		}
+		return &Logger{logger: slog.Default()}, nil
+	case FormatJSON:
+		w := opts.File
+		if w == nil {
+			w = opts.Output
+		}
+		return &Logger{logger: slog.New(slog.NewJSONHandler(w, nil))}, nil
+	case FormatOff:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("invalid access log format %q (must be log, json or off)", opts.Format)
+}
+
+// Wrap оборачивает next: после ответа в журнал пишутся метод, путь, параметры запроса,
+// код ответа, размер тела, время обработки и адрес клиента.
+func (l *Logger) Wrap(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		l.logger.Info("HTTP request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("query", redactQuery(r.URL.RawQuery)),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+// redactQuery скрывает значения redactedParams (ключ API в параметре key).
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	redacted := false
+	for _, name := range redactedParams {
+		if _, ok := values[name]; ok {
+			values.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return rawQuery
+	}
+	return values.Encode()
+}
+
+// responseRecorder запоминает код ответа и считает байты тела.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+// WriteHeader запоминает первый код и передаёт его дальше.
+func (rec *responseRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.status = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Write считает байты тела.
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush передаёт сброс буфера дальше, чтобы потоковые ответы не задерживались.
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
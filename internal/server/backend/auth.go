@@ -0,0 +1,33 @@
+package backend
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// apiKeyHeader — заголовок с ключом доступа к данным.
+const apiKeyHeader = "X-API-Key"
+
+// withAPIKey пропускает запрос, только если ключ из заголовка X-API-Key или параметра key
+// совпадает с настроенным. Без настроенного ключа handler возвращается без изменений.
+func (s *Server) withAPIKey(handler http.HandlerFunc) http.HandlerFunc {
+	if s.apiKey == "" {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(apiKeyHeader)
+		if key == "" {
+			key = r.URL.Query().Get("key")
+		}
+		// Сравнение за постоянное время, чтобы ключ нельзя было подобрать по задержке ответа
+		if subtle.ConstantTimeCompare([]byte(key), []byte(s.apiKey)) != 1 {
+			logging.Warnf("Rejected unauthorized request to %s from %s", r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", apiKeyHeader)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
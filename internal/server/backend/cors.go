@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"net/http"
+)
+
+// corsExposedHeaders — заголовки ответа, которые браузер отдаёт скрипту с другого origin.
+const corsExposedHeaders = "X-Page-Limit, X-Next-Offset, X-Stream-Error"
+
+// withCORS добавляет CORS-заголовки и отвечает на preflight-запросы OPTIONS.
+// Пустой allowedOrigins разрешает любой origin ("*"), иначе Origin запроса
+// возвращается только при совпадении со списком.
+func (s *Server) withCORS(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := false
+		if len(s.allowedOrigins) == 0 {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			allowed = true
+		} else {
+			w.Header().Add("Vary", "Origin")
+			if origin != "" && s.originAllowed(origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				allowed = true
+			}
+		}
+		if allowed {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		}
+
+		// Preflight: браузер спрашивает разрешение до настоящего запроса
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+				if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+					w.Header().Set("Access-Control-Allow-Headers", reqHeaders)
+				}
+				w.Header().Set("Access-Control-Max-Age", "600")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// originAllowed сообщает, есть ли origin в списке разрешённых; "*" в списке разрешает любой.
+func (s *Server) originAllowed(origin string) bool {
+	for _, allowed := range s.allowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
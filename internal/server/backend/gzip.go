@@ -0,0 +1,54 @@
+package backend
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// gzipResponseWriter сжимает тело ответа gzip.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+// Write пишет сжатые данные.
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	return g.gz.Write(p)
+}
+
+// Flush сбрасывает сжатый буфер клиенту, чтобы потоковые ответы не задерживались.
+func (g *gzipResponseWriter) Flush() {
+	g.gz.Flush()
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// withGzip сжимает ответы handler для клиентов, приславших Accept-Encoding: gzip.
+// Остальные клиенты получают ответ без изменений.
+func withGzip(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			handler(w, r)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		handler(&gzipResponseWriter{ResponseWriter: w, gz: gz}, r)
+	}
+}
+
+// acceptsGzip сообщает, принимает ли клиент gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc = strings.TrimSpace(strings.SplitN(enc, ";", 2)[0])
+		if enc == "gzip" || enc == "*" {
+			return true
+		}
+	}
+	return false
+}
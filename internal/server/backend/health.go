@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// healthStatus — ответ /readyz.
+type healthStatus struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// HealthzHandler сообщает, что сервер запущен; базу не трогает.
+func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// ReadyzHandler проверяет, что каталог баз существует и база depth пары по умолчанию открывается.
+// При неготовности отвечает 503 с причиной в JSON.
+func (s *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	status := healthStatus{Status: "ready"}
+	if err := s.checkReady(r); err != nil {
+		status = healthStatus{Status: "unavailable", Reason: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if status.Reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// checkReady возвращает причину неготовности или nil.
+func (s *Server) checkReady(r *http.Request) error {
+	if info, err := os.Stat(s.dbRoot); err != nil || !info.IsDir() {
+		return fmt.Errorf("database root %s is missing", s.dbRoot)
+	}
+	dbPath, err := s.depthDBPath(defaultPair)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("default database %s is missing", dbPath)
+	}
+	db, err := openDB(dbPath, true)
+	if err != nil {
+		s.countDBOpenError(r)
+		return fmt.Errorf("failed to open default database: %v", err)
+	}
+	defer db.Close()
+	ctx, cancel := s.queryContext(r)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		s.countDBOpenError(r)
+		return fmt.Errorf("failed to open default database: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics — метрики Prometheus backend-сервера, отдаются на /metrics (Options.Metrics).
+type metrics struct {
+	registry     *prometheus.Registry
+	requests     *prometheus.CounterVec   // Запросы по обработчику и коду ответа
+	duration     *prometheus.HistogramVec // Время обработки запроса
+	rows         *prometheus.CounterVec   // Строк (свечей, сделок) отдано клиентам
+	dbOpenErrors *prometheus.CounterVec   // Неудачные открытия баз
+}
+
+// newMetrics создаёт метрики в собственном реестре вместе со стандартными метриками Go и процесса.
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bitget_history_http_requests_total",
+			Help: "HTTP requests by handler and status code.",
+		}, []string{"handler", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bitget_history_http_request_duration_seconds",
+			Help:    "Time to serve an HTTP request, including streaming the response.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 4, 8), // 5 мс … ~82 с
+		}, []string{"handler"}),
+		rows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bitget_history_rows_returned_total",
+			Help: "Rows, candles or trades sent to clients by handler.",
+		}, []string{"handler"}),
+		dbOpenErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bitget_history_db_open_errors_total",
+			Help: "Failures to open a database by handler.",
+		}, []string{"handler"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.duration, m.rows, m.dbOpenErrors,
+	)
+	return m
+}
+
+// handler возвращает обработчик /metrics.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// handlerNameKey — ключ контекста запроса с именем обработчика для метрик.
+type handlerNameKey struct{}
+
+// statusRecorder запоминает код ответа для метрик.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader запоминает код и передаёт его дальше.
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Flush передаёт сброс буфера дальше, чтобы потоковые ответы не задерживались.
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument считает запросы и время обработки handler под именем name. Без метрик
+// возвращает handler без изменений.
+func (s *Server) instrument(name string, handler http.HandlerFunc) http.HandlerFunc {
+	if s.metrics == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(rec, r.WithContext(context.WithValue(r.Context(), handlerNameKey{}, name)))
+		s.metrics.requests.WithLabelValues(name, strconv.Itoa(rec.status)).Inc()
+		s.metrics.duration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+}
+
+// countRows учитывает n строк, отданных в ответ на запрос r.
+func (s *Server) countRows(r *http.Request, n int) {
+	if name, ok := r.Context().Value(handlerNameKey{}).(string); ok && s.metrics != nil {
+		s.metrics.rows.WithLabelValues(name).Add(float64(n))
+	}
+}
+
+// countDBOpenError учитывает неудачное открытие базы при обработке запроса r.
+func (s *Server) countDBOpenError(r *http.Request) {
+	if name, ok := r.Context().Value(handlerNameKey{}).(string); ok && s.metrics != nil {
+		s.metrics.dbOpenErrors.WithLabelValues(name).Inc()
+	}
+}
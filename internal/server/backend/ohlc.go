@@ -0,0 +1,126 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/magf/bitget-history/internal/cmdutils/export"
+	"github.com/magf/bitget-history/internal/logging"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// OHLCHandler возвращает свечи за период: [{time, open, high, low, close, volume}].
+// По умолчанию свечи строятся по сделкам (source=trades), при source=depth — по mid-цене стакана.
+func (s *Server) OHLCHandler(w http.ResponseWriter, r *http.Request) {
+	// Получаем параметры
+	q := r.URL.Query()
+	pair := q.Get("pair")
+	if pair == "" {
+		pair = defaultPair
+	}
+	market := q.Get("market")
+	timeframe := q.Get("timeframe")
+	if timeframe == "" {
+		timeframe = "m1"
+	}
+	source := q.Get("source")
+	if source == "" {
+		source = "trades"
+	}
+
+	aggregator, err := export.NewAggregator(timeframe, q.Get("fill_gaps") == "true")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid timeframe %s (supported: %s)", timeframe, strings.Join(export.SupportedTimeframes, ", ")), http.StatusBadRequest)
+		return
+	}
+	if q.Get("start") == "" || q.Get("end") == "" {
+		http.Error(w, "Missing start or end parameter", http.StatusBadRequest)
+		return
+	}
+	startTs, err := strconv.ParseInt(q.Get("start"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid start parameter", http.StatusBadRequest)
+		return
+	}
+	endTs, err := strconv.ParseInt(q.Get("end"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid end parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Выбираем базу и запрос: цена и объём тика
+	var dbPath, query string
+	switch source {
+	case "trades":
+		dbPath, err = s.tradesDBPath(pair, market)
+		query = `SELECT timestamp, price, size_base FROM trades
+			WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp`
+	case "depth":
+		dbPath, err = s.depthDBPath(pair)
+		table := "1"
+		if market == "futures" {
+			table = "2"
+		} else if market != "" && market != "spot" {
+			err = fmt.Errorf("invalid market %q (must be spot or futures)", market)
+		}
+		query = fmt.Sprintf(`SELECT timestamp, (ask_price + bid_price) / 2.0, ask_volume + bid_volume FROM "%s"
+			WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp`, table)
+	default:
+		err = fmt.Errorf("invalid source %q (must be trades or depth)", source)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Проверяем существование базы
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		logging.Infof("Database file does not exist: %s", dbPath)
+		http.Error(w, fmt.Sprintf("Database file does not exist: %s", dbPath), http.StatusNotFound)
+		return
+	}
+
+	db, err := openDB(dbPath, true)
+	if err != nil {
+		s.countDBOpenError(r)
+		logging.Errorf("Failed to open database: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to open database: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+	ctx, cancel := s.queryContext(r)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, startTs, endTs)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	// Свечи собираются за один проход по тикам
+	for rows.Next() {
+		var timestamp int64
+		var price, volume float64
+		if err := rows.Scan(&timestamp, &price, &volume); err != nil {
+			logging.Errorf("Failed to scan row: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to scan row: %v", err), http.StatusInternalServerError)
+			return
+		}
+		aggregator.Add(timestamp, price, volume)
+	}
+	if err := rows.Err(); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	// Отправляем JSON
+	candles := aggregator.Candles()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(candles)
+	s.countRows(r, len(candles))
+}
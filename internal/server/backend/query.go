@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/magf/bitget-history/internal/logging"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// busyTimeoutMs — сколько SQLite ждёт снятия блокировки (например, во время импорта), прежде чем вернуть SQLITE_BUSY.
+const busyTimeoutMs = 5000
+
+// openDB открывает базу для запроса с busy_timeout; readOnly открывает её только для чтения.
+func openDB(dbPath string, readOnly bool) (*sql.DB, error) {
+	dsn := fmt.Sprintf("%s?_busy_timeout=%d", dbPath, busyTimeoutMs)
+	if readOnly {
+		dsn += "&mode=ro"
+	}
+	return sql.Open("sqlite3", dsn)
+}
+
+// queryContext возвращает контекст запроса, ограниченный queryTimeout. Запрос к базе
+// прерывается и при отключении клиента.
+func (s *Server) queryContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), s.queryTimeout)
+}
+
+// writeQueryError отвечает на ошибку запроса к базе: 503 при превышении таймаута,
+// 408 при отключении клиента, иначе 500.
+func writeQueryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		logging.Warnf("Database query timed out: %v", err)
+		http.Error(w, "Database query timed out", http.StatusServiceUnavailable)
+	case errors.Is(err, context.Canceled):
+		logging.Infof("Database query canceled by client: %v", err)
+		http.Error(w, "Request canceled", http.StatusRequestTimeout)
+	default:
+		logging.Errorf("Failed to query database: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to query database: %v", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,164 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/magf/bitget-history/internal/logging"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// pairPattern ограничивает имя пары, чтобы оно не могло выйти за пределы каталога баз.
+var pairPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// tradeRecord — сделка в ответах /trades и /replay.
+type tradeRecord struct {
+	TradeID     string  `json:"trade_id"`
+	Timestamp   int64   `json:"timestamp"`
+	Price       float64 `json:"price"`
+	Side        string  `json:"side"`
+	VolumeQuote float64 `json:"volume_quote"`
+	SizeBase    float64 `json:"size_base"`
+}
+
+// tradesDBPath возвращает путь к базе trades для пары и рынка spot/futures.
+func (s *Server) tradesDBPath(pair, market string) (string, error) {
+	if !pairPattern.MatchString(pair) {
+		return "", fmt.Errorf("invalid pair %q", pair)
+	}
+	var marketCode string
+	switch market {
+	case "spot", "":
+		marketCode = "SPBL"
+	case "futures":
+		marketCode = "UMCBL"
+	default:
+		return "", fmt.Errorf("invalid market %q (must be spot or futures)", market)
+	}
+	return filepath.Join(s.dbRoot, "trades", marketCode, pair+".db"), nil
+}
+
+// ReplayHandler воспроизводит сделки в порядке времени в виде NDJSON или SSE (format=sse).
+// При speed > 0 паузы между сделками равны реальным, делённым на speed; иначе сделки отдаются без пауз.
+func (s *Server) ReplayHandler(w http.ResponseWriter, r *http.Request) {
+	// Получаем параметры
+	q := r.URL.Query()
+	pair := q.Get("pair")
+	if pair == "" {
+		pair = defaultPair
+	}
+	dbPath, err := s.tradesDBPath(pair, q.Get("market"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Get("start") == "" || q.Get("end") == "" {
+		http.Error(w, "Missing start or end parameter", http.StatusBadRequest)
+		return
+	}
+	startTs, err := strconv.ParseInt(q.Get("start"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid start parameter", http.StatusBadRequest)
+		return
+	}
+	endTs, err := strconv.ParseInt(q.Get("end"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid end parameter", http.StatusBadRequest)
+		return
+	}
+	speed := 0.0
+	if v := q.Get("speed"); v != "" {
+		speed, err = strconv.ParseFloat(v, 64)
+		if err != nil || speed < 0 {
+			http.Error(w, "Invalid speed parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	sse := q.Get("format") == "sse"
+
+	// Проверяем существование базы
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		logging.Infof("Database file does not exist: %s", dbPath)
+		http.Error(w, fmt.Sprintf("Database file does not exist: %s", dbPath), http.StatusNotFound)
+		return
+	}
+
+	// Воспроизведение длится сколько угодно, поэтому queryTimeout не применяется: запрос прерывается при отключении клиента
+	db, err := openDB(dbPath, true)
+	if err != nil {
+		s.countDBOpenError(r)
+		logging.Errorf("Failed to open database: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to open database: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(r.Context(), `SELECT trade_id, timestamp, price, side, volume_quote, size_base
+		FROM trades WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp, trade_id`, startTs, endTs)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	flusher, _ := w.(http.Flusher)
+
+	// Отдаём сделки по одной, выдерживая паузы между ними
+	var prevTs int64
+	sent := 0
+	for rows.Next() {
+		var rec tradeRecord
+		if err := rows.Scan(&rec.TradeID, &rec.Timestamp, &rec.Price, &rec.Side, &rec.VolumeQuote, &rec.SizeBase); err != nil {
+			logging.Errorf("Failed to scan row: %v", err)
+			return
+		}
+		if speed > 0 && sent > 0 && rec.Timestamp > prevTs {
+			delay := time.Duration(float64(time.Duration(rec.Timestamp-prevTs)*time.Second) / speed)
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		prevTs = rec.Timestamp
+
+		data, err := json.Marshal(rec)
+		if err != nil {
+			logging.Errorf("Failed to encode trade: %v", err)
+			continue
+		}
+		if sse {
+			_, err = fmt.Fprintf(w, "event: trade\ndata: %s\n\n", data)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", data)
+		}
+		if err != nil {
+			return // Клиент отключился
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		sent++
+	}
+	if err := rows.Err(); err != nil {
+		logging.Warnf("Replay of %s interrupted after %d trades: %v", dbPath, sent, err)
+		return
+	}
+	if sse {
+		fmt.Fprint(w, "event: end\ndata: {}\n\n")
+	}
+	logging.Infof("Replayed %d trades from %s", sent, dbPath)
+	s.countRows(r, sent)
+}
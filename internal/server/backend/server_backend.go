@@ -1,113 +1,332 @@
 package backend
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
+	"github.com/magf/bitget-history/internal/logging"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// maxPageLimit — максимум строк в одном ответе /depth и /trades и значение limit по умолчанию.
+const maxPageLimit = 100000
+
+// defaultPair — пара, которую обработчики используют, если параметр pair не задан.
+const defaultPair = "BTCUSDT"
+
+// parsePage разбирает параметры limit и offset; limit ограничивается maxPageLimit.
+func parsePage(r *http.Request) (limit, offset int, err error) {
+	limit = maxPageLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit parameter")
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset parameter")
+		}
+	}
+	return limit, offset, nil
+}
+
+// setNextOffset сообщает клиенту смещение следующей страницы, если она есть.
+// Наличие следующей страницы проверяется отдельным запросом до начала потоковой выдачи.
+func setNextOffset(ctx context.Context, w http.ResponseWriter, db *sql.DB, query string, limit, offset int, args ...interface{}) error {
+	w.Header().Set("X-Page-Limit", strconv.Itoa(limit))
+	var one int
+	err := db.QueryRowContext(ctx, query, append(args, offset+limit)...).Scan(&one)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("X-Next-Offset", strconv.Itoa(offset+limit))
+	return nil
+}
+
+// depthRecord — строка depth в ответе /depth.
+type depthRecord struct {
+	Timestamp int64   `json:"timestamp"`
+	AskPrice  float64 `json:"ask_price"`
+	BidPrice  float64 `json:"bid_price"`
+	AskVolume float64 `json:"ask_volume"`
+	BidVolume float64 `json:"bid_volume"`
+}
+
+// Options задаёт параметры backend-сервера.
+type Options struct {
+	DBRoot       string        // Корневой каталог баз (database.path из конфига)
+	Gzip         bool          // Сжимать ответы gzip для клиентов, которые это поддерживают
+	QueryTimeout time.Duration // Предельное время запроса к базе для /depth, /trades и /ohlc (0 — без ограничения)
+	// AllowedOrigins — origin'ы, которым разрешены CORS-запросы; пустой список разрешает всем
+	AllowedOrigins []string
+	APIKey         string // Ключ доступа к данным (X-API-Key или ?key=); пустой — без проверки
+	Metrics        bool   // Отдавать метрики Prometheus на /metrics
+}
+
+// Server обслуживает запросы к базам данных в каталоге dbRoot.
+type Server struct {
+	dbRoot         string
+	gzip           bool
+	queryTimeout   time.Duration
+	allowedOrigins []string
+	apiKey         string
+	metrics        *metrics // nil — метрики выключены
+}
+
+// NewServer создаёт обработчики с параметрами opts.
+func NewServer(opts Options) *Server {
+	s := &Server{
+		dbRoot:         opts.DBRoot,
+		gzip:           opts.Gzip,
+		queryTimeout:   opts.QueryTimeout,
+		allowedOrigins: opts.AllowedOrigins,
+		apiKey:         opts.APIKey,
+	}
+	if opts.Metrics {
+		s.metrics = newMetrics()
+	}
+	return s
+}
+
+// wrap добавляет к обработчику общие middleware.
+func (s *Server) wrap(handler http.HandlerFunc) http.HandlerFunc {
+	if s.gzip {
+		handler = withGzip(handler)
+	}
+	return s.withCORS(handler)
+}
+
+// wrapData добавляет к обработчику данных проверку ключа и общие middleware.
+// Проверка ключа идёт после CORS, чтобы preflight-запросы без ключа проходили.
+func (s *Server) wrapData(handler http.HandlerFunc) http.HandlerFunc {
+	return s.wrap(s.withAPIKey(handler))
+}
+
+// depthDBPath возвращает путь к базе depth для пары.
+func (s *Server) depthDBPath(pair string) (string, error) {
+	if !pairPattern.MatchString(pair) {
+		return "", fmt.Errorf("invalid pair %q", pair)
+	}
+	return filepath.Join(s.dbRoot, "depth", pair+".db"), nil
+}
+
 // DepthHandler обрабатывает запросы к данным depth.
-func DepthHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) DepthHandler(w http.ResponseWriter, r *http.Request) {
 	// Получаем параметры
+	pair := r.URL.Query().Get("pair")
 	start := r.URL.Query().Get("start")
 	end := r.URL.Query().Get("end")
 	table := r.URL.Query().Get("table")
-	dbPath := "/var/lib/bitget-history/database/depth/BTCUSDT.db"
 
+	if pair == "" {
+		pair = defaultPair // По умолчанию, как раньше
+	}
+	dbPath, err := s.depthDBPath(pair)
+	if err != nil {
+		logging.Warnf("Invalid depth request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if table == "" {
 		table = "2" // По умолчанию futures
 	}
+	if table != "1" && table != "2" {
+		logging.Warnf("Invalid table parameter: %s", table)
+		http.Error(w, "Invalid table parameter (must be 1 or 2)", http.StatusBadRequest)
+		return
+	}
 	if start == "" || end == "" {
-		log.Printf("Missing start or end parameter")
+		logging.Warnf("Missing start or end parameter")
 		http.Error(w, "Missing start or end parameter", http.StatusBadRequest)
 		return
 	}
 
 	startTs, err := strconv.ParseInt(start, 10, 64)
 	if err != nil {
-		log.Printf("Invalid start parameter: %v", err)
+		logging.Warnf("Invalid start parameter: %v", err)
 		http.Error(w, "Invalid start parameter", http.StatusBadRequest)
 		return
 	}
 	endTs, err := strconv.ParseInt(end, 10, 64)
 	if err != nil {
-		log.Printf("Invalid end parameter: %v", err)
+		logging.Warnf("Invalid end parameter: %v", err)
 		http.Error(w, "Invalid end parameter", http.StatusBadRequest)
 		return
 	}
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		logging.Warnf("Invalid paging parameters: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Проверяем существование базы
 	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		log.Printf("Database file does not exist: %s", dbPath)
+		logging.Infof("Database file does not exist: %s", dbPath)
 		http.Error(w, fmt.Sprintf("Database file does not exist: %s", dbPath), http.StatusInternalServerError)
 		return
 	}
 
 	// Открываем базу
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := openDB(dbPath, false)
 	if err != nil {
-		log.Printf("Failed to open database: %v", err)
+		s.countDBOpenError(r)
+		logging.Errorf("Failed to open database: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to open database: %v", err), http.StatusInternalServerError)
 		return
 	}
 	defer db.Close()
+	ctx, cancel := s.queryContext(r)
+	defer cancel()
 
 	// Проверяем существование таблицы
 	var tableExists string
-	err = db.QueryRow(fmt.Sprintf(`SELECT name FROM sqlite_master WHERE type='table' AND name="%s"`, table)).Scan(&tableExists)
+	err = db.QueryRowContext(ctx, fmt.Sprintf(`SELECT name FROM sqlite_master WHERE type='table' AND name="%s"`, table)).Scan(&tableExists)
 	if err == sql.ErrNoRows {
-		log.Printf("Table %s does not exist", table)
+		logging.Infof("Table %s does not exist", table)
 		http.Error(w, fmt.Sprintf("Table %s does not exist", table), http.StatusBadRequest)
 		return
 	} else if err != nil {
-		log.Printf("Failed to check table existence: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to check table: %v", err), http.StatusInternalServerError)
+		writeQueryError(w, err)
+		return
+	}
+
+	// Проверяем, есть ли следующая страница
+	nextQuery := fmt.Sprintf(`SELECT 1 FROM "%s" WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp, id LIMIT 1 OFFSET ?`, table)
+	if err := setNextOffset(ctx, w, db, nextQuery, limit, offset, startTs, endTs); err != nil {
+		writeQueryError(w, err)
 		return
 	}
 
 	// Запрашиваем данные
-	rows, err := db.Query(fmt.Sprintf(`SELECT timestamp, ask_price, bid_price, ask_volume, bid_volume 
-		FROM "%s" WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp`, table), startTs, endTs)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT timestamp, ask_price, bid_price, ask_volume, bid_volume
+		FROM "%s" WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp, id LIMIT ? OFFSET ?`, table), startTs, endTs, limit, offset)
 	if err != nil {
-		log.Printf("Failed to query database: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to query database: %v", err), http.StatusInternalServerError)
+		writeQueryError(w, err)
 		return
 	}
 	defer rows.Close()
 
-	// Формируем JSON
-	type DepthRecord struct {
-		Timestamp int64   `json:"timestamp"`
-		AskPrice  float64 `json:"ask_price"`
-		BidPrice  float64 `json:"bid_price"`
-		AskVolume float64 `json:"ask_volume"`
-		BidVolume float64 `json:"bid_volume"`
-	}
-
-	var records []DepthRecord
-	for rows.Next() {
-		var rec DepthRecord
-		if err := rows.Scan(&rec.Timestamp, &rec.AskPrice, &rec.BidPrice, &rec.AskVolume, &rec.BidVolume); err != nil {
-			log.Printf("Failed to scan row: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to scan row: %v", err), http.StatusInternalServerError)
-			return
-		}
-		records = append(records, rec)
+	// Отправляем JSON построчно
+	w.Header().Set("Content-Type", "application/json")
+	s.countRows(r, streamJSONArray(w, rows, func(rows *sql.Rows) (interface{}, error) {
+		var rec depthRecord
+		err := rows.Scan(&rec.Timestamp, &rec.AskPrice, &rec.BidPrice, &rec.AskVolume, &rec.BidVolume)
+		return rec, err
+	}))
+}
+
+// TradesHandler обрабатывает запросы к данным trades.
+func (s *Server) TradesHandler(w http.ResponseWriter, r *http.Request) {
+	// Получаем параметры
+	pair := r.URL.Query().Get("pair")
+	market := r.URL.Query().Get("market")
+	start := r.URL.Query().Get("start")
+	end := r.URL.Query().Get("end")
+
+	if pair == "" {
+		pair = defaultPair
+	}
+	dbPath, err := s.tradesDBPath(pair, market)
+	if err != nil {
+		logging.Warnf("Invalid trades request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if start == "" || end == "" {
+		logging.Warnf("Missing start or end parameter")
+		http.Error(w, "Missing start or end parameter", http.StatusBadRequest)
+		return
+	}
+
+	startTs, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		logging.Warnf("Invalid start parameter: %v", err)
+		http.Error(w, "Invalid start parameter", http.StatusBadRequest)
+		return
+	}
+	endTs, err := strconv.ParseInt(end, 10, 64)
+	if err != nil {
+		logging.Warnf("Invalid end parameter: %v", err)
+		http.Error(w, "Invalid end parameter", http.StatusBadRequest)
+		return
+	}
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		logging.Warnf("Invalid paging parameters: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Проверяем существование базы
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		logging.Infof("Database file does not exist: %s", dbPath)
+		http.Error(w, fmt.Sprintf("Database file does not exist: %s", dbPath), http.StatusNotFound)
+		return
+	}
+
+	// Открываем базу
+	db, err := openDB(dbPath, true)
+	if err != nil {
+		s.countDBOpenError(r)
+		logging.Errorf("Failed to open database: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to open database: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+	ctx, cancel := s.queryContext(r)
+	defer cancel()
+
+	// Проверяем, есть ли следующая страница
+	nextQuery := `SELECT 1 FROM trades WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp, trade_id LIMIT 1 OFFSET ?`
+	if err := setNextOffset(ctx, w, db, nextQuery, limit, offset, startTs, endTs); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	// Запрашиваем данные
+	rows, err := db.QueryContext(ctx, `SELECT trade_id, timestamp, price, side, volume_quote, size_base
+		FROM trades WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp, trade_id LIMIT ? OFFSET ?`, startTs, endTs, limit, offset)
+	if err != nil {
+		writeQueryError(w, err)
+		return
 	}
+	defer rows.Close()
 
-	// Отправляем JSON
+	// Отправляем JSON построчно
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	json.NewEncoder(w).Encode(records)
+	s.countRows(r, streamJSONArray(w, rows, func(rows *sql.Rows) (interface{}, error) {
+		var rec tradeRecord
+		err := rows.Scan(&rec.TradeID, &rec.Timestamp, &rec.Price, &rec.Side, &rec.VolumeQuote, &rec.SizeBase)
+		return rec, err
+	}))
 }
 
-// StartServer запускает сервер с endpoint'ом /depth.
-func StartServer(mux *http.ServeMux) {
-	mux.HandleFunc("/depth", DepthHandler)
+// StartServer регистрирует endpoint'ы /depth, /trades, /ohlc, /replay, проверки /healthz, /readyz
+// и, если включены метрики, /metrics. Проверки и метрики доступны без ключа API.
+func StartServer(mux *http.ServeMux, opts Options) {
+	s := NewServer(opts)
+	mux.HandleFunc("/healthz", s.instrument("healthz", s.wrap(s.HealthzHandler)))
+	mux.HandleFunc("/readyz", s.instrument("readyz", s.wrap(s.ReadyzHandler)))
+	mux.HandleFunc("/depth", s.instrument("depth", s.wrapData(s.DepthHandler)))
+	mux.HandleFunc("/trades", s.instrument("trades", s.wrapData(s.TradesHandler)))
+	mux.HandleFunc("/ohlc", s.instrument("ohlc", s.wrapData(s.OHLCHandler)))
+	mux.HandleFunc("/replay", s.instrument("replay", s.wrapData(s.ReplayHandler)))
+	if s.metrics != nil {
+		mux.Handle("/metrics", s.metrics.handler())
+	}
 }
@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/magf/bitget-history/internal/logging"
+)
+
+// streamErrorTrailer — HTTP-трейлер с описанием ошибки, возникшей после начала ответа.
+const streamErrorTrailer = "X-Stream-Error"
+
+// streamJSONArray пишет строки rows в w как JSON-массив по одной, не накапливая их в памяти.
+// scan читает текущую строку в значение для кодирования. Ошибка посреди потока логируется,
+// массив закрывается, чтобы JSON остался валидным, а ошибка передаётся в трейлере X-Stream-Error.
+// Возвращает число записанных строк.
+func streamJSONArray(w http.ResponseWriter, rows *sql.Rows, scan func(*sql.Rows) (interface{}, error)) int {
+	w.Header().Set("Trailer", streamErrorTrailer)
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	written := 0
+	var streamErr error
+	bw.WriteString("[")
+	for rows.Next() {
+		rec, err := scan(rows)
+		if err != nil {
+			streamErr = err
+			break
+		}
+		if written > 0 {
+			bw.WriteString(",")
+		}
+		// Encoder дописывает перевод строки, что допустимо между элементами массива
+		if err := enc.Encode(rec); err != nil {
+			streamErr = err
+			break
+		}
+		written++
+	}
+	if streamErr == nil {
+		streamErr = rows.Err()
+	}
+	bw.WriteString("]\n")
+	if err := bw.Flush(); err != nil {
+		logging.Errorf("Failed to write response: %v", err)
+		return written
+	}
+	if streamErr != nil {
+		logging.Warnf("Response stream interrupted after %d rows: %v", written, streamErr)
+		w.Header().Set(streamErrorTrailer, streamErr.Error())
+	}
+	return written
+}
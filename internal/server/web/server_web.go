@@ -1,14 +1,32 @@
 package web
 
 import (
+	"embed"
+	"io/fs"
 	"net/http"
-	"path/filepath"
+
+	"github.com/magf/bitget-history/internal/logging"
 )
 
+// staticFiles — статические файлы, встроенные в бинарник.
+//
+//go:embed static
+var staticFiles embed.FS
+
 // StartServer настраивает веб-сервер для раздачи статических файлов.
-func StartServer(mux *http.ServeMux) {
-	// Раздаём статические файлы из internal/server/web/static
-	staticDir := http.Dir(filepath.Join("internal", "server", "web", "static"))
-	fs := http.FileServer(staticDir)
-	mux.Handle("/", fs)
+// По умолчанию файлы раздаются из встроенной файловой системы; непустой staticDir
+// позволяет раздавать их с диска (удобно при разработке фронтенда).
+func StartServer(mux *http.ServeMux, staticDir string) {
+	var root http.FileSystem
+	if staticDir != "" {
+		logging.Infof("Serving static files from %s", staticDir)
+		root = http.Dir(staticDir)
+	} else {
+		sub, err := fs.Sub(staticFiles, "static")
+		if err != nil {
+			logging.Fatalf("Failed to open embedded static files: %v", err)
+		}
+		root = http.FS(sub)
+	}
+	mux.Handle("/", http.FileServer(root))
 }